@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/service"
+)
+
+// BindingAction 修改绑定链动作的请求
+type BindingAction struct {
+	Action int // 1 告警 2 阻断
+}
+
+// handleBindings GET 查看动作和所有绑定，POST 增加绑定，DELETE 清空绑定
+// POST 请求体是绑定数组，Content-Type为text/csv时按CSV导入，?replace=true 替换已有的绑定
+func (s *Server) handleBindings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		config, err := s.Bindings.GetConfig()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, config)
+
+	case http.MethodPost:
+		var bindings []model.IpMacBinding
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			bindings, err = service.ParseBindingCSV(r.Body)
+		} else {
+			err = readJSON(r, &bindings)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := s.Bindings.AddBindings(bindings, r.URL.Query().Get("replace") == "true"); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, bindings)
+
+	case http.MethodDelete:
+		if err := s.Bindings.FlushBindings(); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleBinding /api/bindings/{ip} DELETE 删除绑定
+func (s *Server) handleBinding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/bindings/", 1)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	if err := s.Bindings.DeleteBindings(params); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
+
+// handleBindingAction PUT 修改MAC不一致时的动作
+func (s *Server) handleBindingAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var action BindingAction
+	if err := readJSON(r, &action); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.Bindings.SetAction(action.Action); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, action)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// handleBlacklist GET 查看黑名单，POST 增加地址，DELETE 清空黑名单
+// POST 请求体是地址数组，IPv4/IPv6/MAC地址可以混合，?ttl=30m 临时封禁，到期自动删除
+func (s *Server) handleBlacklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := s.Blacklist.ListBlacklist()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, entries)
+
+	case http.MethodPost:
+		var ttl time.Duration
+		if value := r.URL.Query().Get("ttl"); len(value) != 0 {
+			var err error
+			if ttl, err = time.ParseDuration(value); err != nil {
+				writeError(w, strerror.CreateError("blacklist ttl error:"+value))
+				return
+			}
+		}
+
+		var values []string
+		if err := readJSON(r, &values); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := s.Blacklist.AddBlacklist(values, ttl); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, values)
+
+	case http.MethodDelete:
+		if err := s.Blacklist.FlushBlacklist(); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleBlacklistEntry /api/blacklist/{addr} DELETE 删除地址
+func (s *Server) handleBlacklistEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/blacklist/", 1)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	if err := s.Blacklist.DeleteBlacklist(params); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
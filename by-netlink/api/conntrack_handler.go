@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"netvine.com/firewall/server/model"
+)
+
+// handleConntrack GET 查看启用的连接跟踪规则，?table= 指定表，默认策略表
+// PUT 按请求体开启或关闭已建立连接快速放行和无效状态阻断
+func (s *Server) handleConntrack(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		config, err := s.Conntrack.GetConfig(r.URL.Query().Get("table"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, config)
+
+	case http.MethodPut:
+		var config model.ConntrackConfig
+		if err := readJSON(r, &config); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := s.Conntrack.SetConfig(config); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, config)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import "net/http"
+
+// handleDrift GET 对比策略链和策略文件，POST 对比并在不一致时重新下发策略文件
+func (s *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	var heal bool
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		heal = true
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	report, err := s.Drift.Check(heal)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, report)
+}
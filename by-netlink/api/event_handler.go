@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// parseEventQuery ?since=2022-11-22 18:00:00&until=&policy=policy1&ip=192.168.0.0/24&port=502&action=drop&limit=100
+func parseEventQuery(values url.Values) (model.EventQuery, error) {
+	query := model.EventQuery{PolicyId: values.Get("policy"), Ip: values.Get("ip"), Action: values.Get("action")}
+
+	var err error
+	if value := values.Get("since"); len(value) != 0 {
+		if query.Since, err = store.ParseEventTime(value); err != nil {
+			return query, err
+		}
+	}
+	if value := values.Get("until"); len(value) != 0 {
+		if query.Until, err = store.ParseEventTime(value); err != nil {
+			return query, err
+		}
+	}
+	if value := values.Get("port"); len(value) != 0 {
+		if query.Port, err = strconv.Atoi(value); err != nil {
+			return query, strerror.CreateError("event port error:" + value)
+		}
+	}
+	if value := values.Get("limit"); len(value) != 0 {
+		if query.Limit, err = strconv.Atoi(value); err != nil {
+			return query, strerror.CreateError("event limit error:" + value)
+		}
+	}
+	return query, nil
+}
+
+// handleEvents GET 按时间倒序查询告警或者安全日志
+func (s *Server) handleEvents(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+
+		query, err := parseEventQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		events, err := s.Events.Query(kind, query)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if events == nil {
+			events = []model.LogEvent{}
+		}
+		writeData(w, http.StatusOK, events)
+	}
+}
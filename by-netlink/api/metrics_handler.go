@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"netvine.com/firewall/server/utils/metrics"
+)
+
+// handleMetrics GET Prometheus文本格式的指标，策略计数器、集合元素数和黑名单在采集时读取
+// 读取失败的指标跳过，不影响其他指标
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writePolicyMetrics(w)
+	s.writeSetMetrics(w)
+	s.writeBlacklistMetrics(w)
+	metrics.WriteAll(w)
+}
+
+// writePolicyMetrics 每条策略的报文数、字节数和最近命中时间，计数器只用策略ID作为标签，策略更新后计数继续累加
+// 策略版本单独作为 fd_policy_info 的标签
+func (s *Server) writePolicyMetrics(w http.ResponseWriter) {
+	stats, err := s.Stats.Stats()
+	if err != nil {
+		fmt.Printf("collect policy metrics failed: %v\n", err)
+		return
+	}
+
+	var info, packets, bytes, lastHit []metrics.Sample
+	for _, stat := range stats {
+		// 没有策略ID时与计数器名称一致使用策略版本
+		id := stat.PolicyId
+		if len(id) == 0 {
+			id = stat.Version
+		}
+		labels := []metrics.Label{{Name: "policy_id", Value: id}}
+		info = append(info, metrics.Sample{
+			Labels: []metrics.Label{{Name: "policy_id", Value: id}, {Name: "version", Value: stat.Version}},
+			Value:  1,
+		})
+		packets = append(packets, metrics.Sample{Labels: labels, Value: float64(stat.Packets)})
+		bytes = append(bytes, metrics.Sample{Labels: labels, Value: float64(stat.Bytes)})
+		if !stat.LastHit.IsZero() {
+			lastHit = append(lastHit, metrics.Sample{Labels: labels, Value: float64(stat.LastHit.Unix())})
+		}
+	}
+	metrics.WriteMetric(w, "fd_policy_info", "gauge", "策略当前的版本", info)
+	metrics.WriteMetric(w, "fd_policy_packets_total", "counter", "策略命中的报文数", packets)
+	metrics.WriteMetric(w, "fd_policy_bytes_total", "counter", "策略命中的字节数", bytes)
+	metrics.WriteMetric(w, "fd_policy_last_hit_timestamp_seconds", "gauge", "策略最近命中的时间", lastHit)
+}
+
+// writeSetMetrics 每个命名集合的元素数
+func (s *Server) writeSetMetrics(w http.ResponseWriter) {
+	tables, err := s.Objects.ListTables()
+	if err != nil {
+		fmt.Printf("collect set metrics failed: %v\n", err)
+		return
+	}
+
+	var samples []metrics.Sample
+	for _, table := range tables {
+		sets, err := s.Objects.ListSets(table.Family, table.Name)
+		if err != nil {
+			fmt.Printf("collect set metrics %s %s failed: %v\n", table.Family, table.Name, err)
+			continue
+		}
+		for _, set := range sets {
+			samples = append(samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "family", Value: set.Family}, {Name: "table", Value: set.Table}, {Name: "set", Value: set.Name}},
+				Value:  float64(len(set.Elements)),
+			})
+		}
+	}
+	metrics.WriteMetric(w, "fd_set_elements", "gauge", "命名集合的元素数", samples)
+}
+
+// writeBlacklistMetrics 按地址类型统计黑名单条目数，采集时不创建黑名单表和集合
+func (s *Server) writeBlacklistMetrics(w http.ResponseWriter) {
+	counts, err := s.Blacklist.CountBlacklist()
+	if err != nil {
+		fmt.Printf("collect blacklist metrics failed: %v\n", err)
+		return
+	}
+
+	types := make([]string, 0, len(counts))
+	for entryType := range counts {
+		types = append(types, entryType)
+	}
+	sort.Strings(types)
+
+	var samples []metrics.Sample
+	for _, entryType := range types {
+		samples = append(samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "type", Value: entryType}},
+			Value:  float64(counts[entryType]),
+		})
+	}
+	metrics.WriteMetric(w, "fd_blacklist_entries", "gauge", "黑名单条目数", samples)
+}
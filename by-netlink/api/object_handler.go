@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+
+	"netvine.com/firewall/server/model"
+)
+
+// handleTables GET 查看所有表，POST 创建表
+func (s *Server) handleTables(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tables, err := s.Objects.ListTables()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, tables)
+
+	case http.MethodPost:
+		var table model.TableInfo
+		if err := readJSON(r, &table); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := s.Objects.AddTable(table); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, table)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleTable /api/tables/{family}/{name} DELETE 删除表
+func (s *Server) handleTable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/tables/", 2)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	if err := s.Objects.DeleteTable(params[0], params[1]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
+
+// handleChains GET 查看所有链，POST 创建链
+func (s *Server) handleChains(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		chains, err := s.Objects.ListChains()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, chains)
+
+	case http.MethodPost:
+		var chain model.ChainInfo
+		if err := readJSON(r, &chain); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := s.Objects.AddChain(chain); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, chain)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleChain /api/chains/{family}/{table}/{name} DELETE 删除链
+func (s *Server) handleChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/chains/", 3)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	if err := s.Objects.DeleteChain(params[0], params[1], params[2]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
+
+// handleSets /api/sets/{family}/{table} GET 查看集合，POST 创建集合
+// /api/sets/{family}/{table}/{name} DELETE 删除集合
+func (s *Server) handleSets(w http.ResponseWriter, r *http.Request) {
+	if params := pathParams(r, "/api/sets/", 3); params != nil {
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, r)
+			return
+		}
+		if err := s.Objects.DeleteSet(params[0], params[1], params[2]); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+		return
+	}
+
+	params := pathParams(r, "/api/sets/", 2)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	family, table := params[0], params[1]
+
+	switch r.Method {
+	case http.MethodGet:
+		sets, err := s.Objects.ListSets(family, table)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, sets)
+
+	case http.MethodPost:
+		var set model.SetInfo
+		if err := readJSON(r, &set); err != nil {
+			writeError(w, err)
+			return
+		}
+		set.Family, set.Table = family, table
+		if err := s.Objects.AddSet(set); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, set)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
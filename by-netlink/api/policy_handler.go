@@ -0,0 +1,268 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// PolicyDetail 策略以及对应的内核规则
+type PolicyDetail struct {
+	Policy model.Policy     `json:"policy"`
+	Rules  []model.RuleInfo `json:"rules"`
+}
+
+func (s *Server) handlePolicySchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	writeData(w, http.StatusOK, PolicySchema())
+}
+
+// readPolicy 解析请求中的策略，接口管理的策略必须有策略ID，端口错误的策略不保存
+func readPolicy(r *http.Request) (model.Policy, error) {
+	var policy model.Policy
+	if err := readJSON(r, &policy); err != nil {
+		return policy, err
+	}
+	policy.Manager = ""
+	return policy, nft.CheckPorts(policy)
+}
+
+// handlePolicys GET 查看保存的策略，POST 新增策略，PUT 替换全部策略
+func (s *Server) handlePolicys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshot, err := s.Store.Load()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, snapshot)
+
+	case http.MethodPost:
+		policy, err := readPolicy(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := nft.CheckPolicyId(policy.Id); err != nil {
+			writeError(w, err)
+			return
+		}
+		if _, err := s.Store.GetPolicy(policy.Id); err == nil {
+			writeError(w, strerror.CreateCodeError(strerror.CodeConflict, "policy exist:"+policy.Id))
+			return
+		}
+
+		if err := service.ApplyPolicys(s.Backend, []model.Policy{policy}); err != nil {
+			writeError(w, err)
+			return
+		}
+		if _, err := s.Store.SavePolicy(policy); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusCreated, policy)
+
+	case http.MethodPut:
+		var policys []model.Policy
+		if err := readJSON(r, &policys); err != nil {
+			writeError(w, err)
+			return
+		}
+		ids := make(map[string]bool)
+		for i := range policys {
+			policys[i].Manager = ""
+			if err := nft.CheckPolicyId(policys[i].Id); err != nil {
+				writeError(w, err)
+				return
+			}
+			if err := nft.CheckPorts(policys[i]); err != nil {
+				writeError(w, err)
+				return
+			}
+			if ids[policys[i].Id] {
+				writeError(w, strerror.CreateError("duplicate policy id:"+policys[i].Id))
+				return
+			}
+			ids[policys[i].Id] = true
+		}
+
+		if err := service.SyncPolicys(s.Backend, policys); err != nil {
+			writeError(w, err)
+			return
+		}
+		snapshot, err := s.Store.Save(policys)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, snapshot)
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePolicy /api/policys/{id} GET 查看，PUT 更新，DELETE 删除
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	params := pathParams(r, "/api/policys/", 1)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	id := params[0]
+	if err := nft.CheckPolicyId(id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	identifier, ok := s.Backend.(service.PolicyIdentifier)
+	if !ok && r.Method != http.MethodGet {
+		writeStatus(w, http.StatusNotImplemented, "backend does not support policy id")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		policy, err := s.Store.GetPolicy(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		detail := PolicyDetail{Policy: policy}
+		if ok {
+			// 策略链被清空时策略文件中的策略没有对应的规则
+			rules, err := identifier.GetPolicyRules(id)
+			if err != nil && strerror.GetErrorCode(err) != strerror.CodeNotFound {
+				writeError(w, err)
+				return
+			}
+			detail.Rules = rules
+		}
+		writeData(w, http.StatusOK, detail)
+
+	case http.MethodPut:
+		policy, err := readPolicy(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(policy.Id) != 0 && policy.Id != id {
+			writeError(w, strerror.CreateError("policy id mismatch:"+policy.Id))
+			return
+		}
+		policy.Id = id
+
+		err = identifier.UpdatePolicy(policy)
+		if strerror.GetErrorCode(err) == strerror.CodeNotFound {
+			// 内核中没有规则，策略文件中有该策略时重新下发
+			if _, storeErr := s.Store.GetPolicy(id); storeErr != nil {
+				writeError(w, storeErr)
+				return
+			}
+			err = service.ApplyPolicys(s.Backend, []model.Policy{policy})
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if _, err := s.Store.SavePolicy(policy); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, policy)
+
+	case http.MethodDelete:
+		ruleErr := identifier.DeletePolicy(id)
+		if ruleErr != nil && strerror.GetErrorCode(ruleErr) != strerror.CodeNotFound {
+			writeError(w, ruleErr)
+			return
+		}
+
+		_, storeErr := s.Store.DeletePolicy(id)
+		if storeErr != nil && (ruleErr != nil || strerror.GetErrorCode(storeErr) != strerror.CodeNotFound) {
+			writeError(w, storeErr)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleRules GET 查看内核中的规则，DELETE 清空策略链
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.Backend.ListRules()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, rules)
+
+	case http.MethodDelete:
+		if err := s.Backend.FlushRules(); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handleRule /api/rules/{handle} DELETE 根据句柄删除规则
+func (s *Server) handleRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/rules/", 1)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	handle, err := strconv.ParseUint(params[0], 10, 64)
+	if err != nil {
+		writeError(w, strerror.CreateError("handle error:"+params[0]))
+		return
+	}
+
+	if err := s.Backend.DeleteRule(handle); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
+
+// handleRestore POST 将策略文件重新下发到内核
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	snapshot, err := s.Store.Load()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := service.SyncPolicys(s.Backend, snapshot.Policys); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeData(w, http.StatusOK, snapshot)
+}
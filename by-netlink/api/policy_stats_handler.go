@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"netvine.com/firewall/server/model"
+)
+
+// handlePolicyStats GET 查看每条策略的报文数、字节数和最近命中时间，DELETE 清零全部计数器
+func (s *Server) handlePolicyStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		stats, err := s.Stats.Stats()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if stats == nil {
+			stats = []model.PolicyStats{}
+		}
+		writeData(w, http.StatusOK, stats)
+
+	case http.MethodDelete:
+		if err := s.Stats.Reset(""); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// handlePolicyStatsEntry /api/policy-stats/{id} DELETE 清零策略的计数器
+func (s *Server) handlePolicyStatsEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := pathParams(r, "/api/policy-stats/", 1)
+	if params == nil {
+		writeStatus(w, http.StatusNotFound, "not found:"+r.URL.Path)
+		return
+	}
+	if err := s.Stats.Reset(params[0]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{})
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/service"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
+	tail_log "netvine.com/firewall/server/utils/log"
+)
+
+// Response 接口统一返回格式，Code为0表示成功，否则与HTTP状态码一致
+type Response struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Server 策略管理HTTP服务
+// nftables.Conn 不支持并发，所有修改内核规则和策略文件的请求串行执行
+type Server struct {
+	Backend   service.RuleBackend
+	Objects   *service.ObjectManagerService
+	Bindings  *service.IpMacBindingService
+	Blacklist *service.BlacklistService
+	Conntrack *service.ConntrackService
+	Stats     *service.PolicyStatsService
+	Events    *service.EventLogService
+	Drift     *service.DriftService
+	Store     *store.PolicyStore
+
+	// LogConsumer 不为空时读取NFLOG日志，告警和安全日志保存到Events
+	LogConsumer *service.LogConsumerService
+	// WatchDrift 为true时监视策略表的变化，与策略文件不一致时由Drift输出告警
+	WatchDrift bool
+	// Audit 不为空时每个修改请求输出一个审计事件
+	Audit tail_log.AuditSink
+
+	// SampleInterval 命中统计的采样间隔，为0时不采样
+	SampleInterval time.Duration
+
+	mu sync.Mutex
+}
+
+func NewServer(backend service.RuleBackend, policyStore *store.PolicyStore, statsStore *store.StatsStore) *Server {
+	return &Server{Backend: backend, Objects: &service.ObjectManagerService{}, Bindings: &service.IpMacBindingService{},
+		Blacklist: &service.BlacklistService{}, Conntrack: &service.ConntrackService{},
+		Stats: service.NewPolicyStatsService(statsStore), Events: service.NewEventLogService("", 0),
+		Drift: service.NewDriftService(backend, policyStore), Store: policyStore}
+}
+
+// Handler 注册所有接口
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/schema/policy", s.handlePolicySchema)
+	mux.HandleFunc("/api/policys", s.handlePolicys)
+	mux.HandleFunc("/api/policys/", s.handlePolicy)
+	mux.HandleFunc("/api/rules", s.handleRules)
+	mux.HandleFunc("/api/rules/", s.handleRule)
+	mux.HandleFunc("/api/restore", s.handleRestore)
+	mux.HandleFunc("/api/tables", s.handleTables)
+	mux.HandleFunc("/api/tables/", s.handleTable)
+	mux.HandleFunc("/api/chains", s.handleChains)
+	mux.HandleFunc("/api/chains/", s.handleChain)
+	mux.HandleFunc("/api/sets/", s.handleSets)
+	mux.HandleFunc("/api/bindings", s.handleBindings)
+	mux.HandleFunc("/api/bindings/", s.handleBinding)
+	mux.HandleFunc("/api/binding-action", s.handleBindingAction)
+	mux.HandleFunc("/api/blacklist", s.handleBlacklist)
+	mux.HandleFunc("/api/blacklist/", s.handleBlacklistEntry)
+	mux.HandleFunc("/api/conntrack", s.handleConntrack)
+	mux.HandleFunc("/api/policy-stats", s.handlePolicyStats)
+	mux.HandleFunc("/api/policy-stats/", s.handlePolicyStatsEntry)
+	mux.HandleFunc("/api/alarms", s.handleEvents(service.EventKindAlarm))
+	mux.HandleFunc("/api/security-logs", s.handleEvents(service.EventKindLog))
+	mux.HandleFunc("/api/drift", s.handleDrift)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return s.serialize(mux)
+}
+
+// serialize 请求串行执行，并记录请求日志，修改请求输出审计事件
+func (s *Server) serialize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		fmt.Printf("%s %s %v\n", r.Method, r.URL.Path, time.Since(start))
+
+		if s.Audit != nil && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if err := s.Audit.WriteAudit(auditEvent(r, recorder.status)); err != nil {
+				fmt.Printf("audit failed: %v\n", err)
+			}
+		}
+	})
+}
+
+// statusRecorder 记录响应的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// auditEvent 修改请求的审计事件，/api/policys/{id} 带有策略ID
+func auditEvent(r *http.Request, status int) model.AuditEvent {
+	audit := model.AuditEvent{Time: time.Now(), Source: "api", Remote: r.RemoteAddr, Method: r.Method, Path: r.URL.Path, Status: status}
+	if params := pathParams(r, "/api/policys/", 1); params != nil && strings.HasPrefix(r.URL.Path, "/api/policys/") {
+		audit.PolicyId = params[0]
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		audit.Remote = host
+	}
+	return audit
+}
+
+// ListenAndServe 启动服务、命中统计采样、日志读取和规则集监视，收到SIGINT、SIGTERM时等待请求处理完成后退出
+func (s *Server) ListenAndServe(addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	if s.LogConsumer != nil || s.WatchDrift {
+		defer s.Events.Close()
+	}
+	// 返回前通知后台任务退出并等待，之后才关闭事件存储和导出，避免向已关闭的导出写入
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	if s.SampleInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Stats.Run(s.SampleInterval, &s.mu, stop)
+		}()
+	}
+	if s.LogConsumer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.LogConsumer.Run(stop); err != nil {
+				fmt.Printf("nflog consumer stopped: %v\n", err)
+			}
+		}()
+	}
+	if s.WatchDrift {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Drift.Run(&s.mu, stop); err != nil {
+				fmt.Printf("drift watcher stopped: %v\n", err)
+			}
+		}()
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Printf("api server listen on %s\n", addr)
+		errCh <- server.ListenAndServe()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-sigCh:
+		fmt.Printf("api server receive %v, shutdown\n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Printf("write response failed: %v\n", err)
+	}
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, Response{Data: data})
+}
+
+// writeError 根据错误类型返回状态码，参数错误400，不存在404，已存在409，其他500
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch strerror.GetErrorCode(err) {
+	case strerror.CodeInvalid:
+		status = http.StatusBadRequest
+	case strerror.CodeNotFound:
+		status = http.StatusNotFound
+	case strerror.CodeConflict:
+		status = http.StatusConflict
+	}
+	writeStatus(w, status, err.Error())
+}
+
+func writeStatus(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, Response{Code: status, Message: message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusMethodNotAllowed, "method not allowed:"+r.Method)
+}
+
+// readJSON 解析请求体，不允许未知字段
+func readJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return strerror.CreateError("request body error: " + err.Error())
+	}
+	return nil
+}
+
+// pathParams 去掉前缀后按"/"拆分路径参数，参数个数不对时返回nil
+func pathParams(r *http.Request, prefix string, count int) []string {
+	value := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	params := strings.Split(value, "/")
+	if len(value) == 0 || len(params) != count {
+		return nil
+	}
+	return params
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/store"
+)
+
+// appCommand 自定义应用
+func appCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "app",
+		Usage: "应用，策略中 --app 展开为协议和目的端口",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "查看预定义应用和自定义应用",
+				Action: listApps,
+			},
+			{
+				Name:  "add",
+				Usage: "增加自定义应用: app add --name plc1 --protocol tcp --port 5020",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Required: true, Usage: "应用名称: --name plc1"},
+					&cli.StringFlag{Name: "protocol", Aliases: []string{"p"}, Value: "tcp", Usage: "协议: --protocol tcp / --protocol tcp,udp"},
+					&cli.IntFlag{Name: "port", Required: true, Usage: "端口: --port 5020"},
+					&cli.BoolFlag{Name: "inspect", Usage: "允许时交给suricata深度检测"},
+				},
+				Action: addApp,
+			},
+			{
+				Name:      "del",
+				Usage:     "删除自定义应用",
+				ArgsUsage: "<name>",
+				Action: func(cCtx *cli.Context) error {
+					return store.NewAppStore(cCtx.String("apps")).DeleteApp(cCtx.Args().First())
+				},
+			},
+		},
+	}
+}
+
+// listApps 查看预定义应用和自定义应用
+func listApps(cCtx *cli.Context) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPROTOCOL\tPORT\tINSPECT\tTYPE")
+	for _, signature := range nft.ListApps() {
+		appType := "custom"
+		if signature.Predefine {
+			appType = "predefine"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", signature.Name, signature.Protocol,
+			strings.Join(signature.Ports, ","), signature.Inspect, appType)
+	}
+	return w.Flush()
+}
+
+// addApp 增加或者替换自定义应用，校验通过后才保存
+func addApp(cCtx *cli.Context) error {
+	app := model.App{
+		Name:     cCtx.String("name"),
+		Port:     cCtx.Int("port"),
+		Protocol: cCtx.String("protocol"),
+		Inspect:  cCtx.Bool("inspect"),
+	}
+	return store.NewAppStore(cCtx.String("apps")).Update(func(current []model.App) ([]model.App, error) {
+		apps := store.SetApp(current, app)
+		if err := nft.RegisterApps(apps); err != nil {
+			return nil, err
+		}
+		return apps, nil
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/service"
+)
+
+// bindingCommand IP-MAC绑定
+func bindingCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "binding",
+		Usage: "IP-MAC绑定，绑定地址的源MAC不一致时告警或者阻断",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "查看绑定",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+				},
+				Action: listBindings,
+			},
+			{
+				Name:  "add",
+				Usage: "增加绑定，地址已经绑定时替换: binding add --ip 192.168.0.1 --mac 0c:73:eb:92:80:cf",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ip", Required: true, Usage: "IPv4/IPv6地址: --ip 192.168.0.1"},
+					&cli.StringFlag{Name: "mac", Required: true, Usage: "MAC地址: --mac 0c:73:eb:92:80:cf"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					bindingService := service.IpMacBindingService{}
+					binding := model.IpMacBinding{Ip: cCtx.String("ip"), Mac: cCtx.String("mac")}
+					return bindingService.AddBindings([]model.IpMacBinding{binding}, false)
+				},
+			},
+			{
+				Name:      "del",
+				Usage:     "删除绑定",
+				ArgsUsage: "<ip> [ip...]",
+				Action: func(cCtx *cli.Context) error {
+					bindingService := service.IpMacBindingService{}
+					return bindingService.DeleteBindings(cCtx.Args().Slice())
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "从CSV文件导入绑定，每行 地址,MAC",
+				ArgsUsage: "<file.csv>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "replace", Usage: "替换已有的绑定"},
+				},
+				Action: importBindings,
+			},
+			{
+				Name:  "flush",
+				Usage: "清空所有绑定",
+				Action: func(cCtx *cli.Context) error {
+					bindingService := service.IpMacBindingService{}
+					return bindingService.FlushBindings()
+				},
+			},
+			{
+				Name:      "action",
+				Usage:     "MAC不一致时的动作，默认阻断",
+				ArgsUsage: "<warn|drop>",
+				Action: func(cCtx *cli.Context) error {
+					action, err := nft.ParseAction(cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					bindingService := service.IpMacBindingService{}
+					return bindingService.SetAction(action)
+				},
+			},
+		},
+	}
+}
+
+// listBindings 查看IP-MAC绑定链的动作和所有绑定
+func listBindings(cCtx *cli.Context) error {
+	bindingService := service.IpMacBindingService{}
+	config, err := bindingService.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("action: %s\n", nft.ActionName(config.Action))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tMAC")
+	for _, binding := range config.Bindings {
+		fmt.Fprintf(w, "%s\t%s\n", binding.Ip, binding.Mac)
+	}
+	return w.Flush()
+}
+
+// importBindings 从CSV文件导入绑定，每行 地址,MAC
+func importBindings(cCtx *cli.Context) error {
+	file, err := os.Open(cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bindings, err := service.ParseBindingCSV(file)
+	if err != nil {
+		return err
+	}
+
+	bindingService := service.IpMacBindingService{}
+	if err := bindingService.AddBindings(bindings, cCtx.Bool("replace")); err != nil {
+		return err
+	}
+	fmt.Printf("import %d bindings\n", len(bindings))
+	return nil
+}
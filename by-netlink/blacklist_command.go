@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// blacklistCommand 黑名单，地址在prerouting丢弃
+func blacklistCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "blacklist",
+		Aliases: []string{"backlist", "bl"},
+		Usage:   "黑名单，IPv4/IPv6/MAC地址在prerouting丢弃",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "add",
+				Usage:     "增加黑名单: blacklist add 192.168.0.1 2001:db8::1 0c:73:eb:92:80:cf",
+				ArgsUsage: "<addr> [addr...]",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{Name: "ttl", Usage: "封禁时长，到期自动删除，默认永久: --ttl 30m"},
+				},
+				Action: addBlacklist,
+			},
+			{
+				Name:  "list",
+				Usage: "查看黑名单",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+				},
+				Action: listBlacklist,
+			},
+			{
+				Name:      "del",
+				Usage:     "删除黑名单中的地址，清空所有黑名单使用 blacklist flush",
+				ArgsUsage: "<addr> [addr...]",
+				Action: func(cCtx *cli.Context) error {
+					// 原来的 bl del 清空全部黑名单，不带地址时报错，避免旧脚本静默地什么都不做
+					if cCtx.NArg() == 0 {
+						return strerror.CreateError("blacklist address is required, use blacklist flush to delete all")
+					}
+					blacklistService := service.BlacklistService{}
+					return blacklistService.DeleteBlacklist(cCtx.Args().Slice())
+				},
+			},
+			{
+				Name:  "flush",
+				Usage: "清空所有黑名单",
+				Action: func(cCtx *cli.Context) error {
+					blacklistService := service.BlacklistService{}
+					return blacklistService.FlushBlacklist()
+				},
+			},
+		},
+	}
+}
+
+// banCommand 临时封禁地址，与 blacklist add --ttl 相同
+func banCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "ban",
+		Usage:     "临时封禁地址，到期自动删除: ban --ttl 30m 192.168.0.1",
+		ArgsUsage: "<addr> [addr...]",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{Name: "ttl", Value: 30 * time.Minute, Usage: "封禁时长: --ttl 30m"},
+		},
+		Action: addBlacklist,
+	}
+}
+
+// listBlacklist 查看黑名单
+func listBlacklist(cCtx *cli.Context) error {
+	blacklistService := service.BlacklistService{}
+	entries, err := blacklistService.ListBlacklist()
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tTYPE\tTTL\tEXPIRES")
+	for _, entry := range entries {
+		ttl, expires := "permanent", "-"
+		if entry.Timeout > 0 {
+			ttl = (time.Duration(entry.Timeout) * time.Second).String()
+			expires = (time.Duration(entry.Expires) * time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Value, entry.Type, ttl, expires)
+	}
+	return w.Flush()
+}
+
+// addBlacklist 增加黑名单，--ttl 临时封禁，到期后由内核删除
+func addBlacklist(cCtx *cli.Context) error {
+	if cCtx.NArg() == 0 {
+		return strerror.CreateError("blacklist address is required")
+	}
+	blacklistService := service.BlacklistService{}
+	return blacklistService.AddBlacklist(cCtx.Args().Slice(), cCtx.Duration("ttl"))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// conntrackCommand 连接跟踪规则
+func conntrackCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "conntrack",
+		Usage: "策略链最前面的连接跟踪规则，已建立连接直接放行，无效状态直接阻断",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "show",
+				Usage: "查看启用的连接跟踪规则",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "table", Usage: "表名，默认策略表: --table netvine-table"},
+					&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+				},
+				Action: showConntrack,
+			},
+			{
+				Name:      "enable",
+				Usage:     "开启连接跟踪规则: conntrack enable established invalid",
+				ArgsUsage: "<established|invalid> [...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "table", Usage: "表名，默认策略表: --table netvine-table"},
+				},
+				Action: setConntrack(true),
+			},
+			{
+				Name:      "disable",
+				Usage:     "关闭连接跟踪规则: conntrack disable established",
+				ArgsUsage: "<established|invalid> [...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "table", Usage: "表名，默认策略表: --table netvine-table"},
+				},
+				Action: setConntrack(false),
+			},
+		},
+	}
+}
+
+// showConntrack 查看表中启用的连接跟踪规则
+func showConntrack(cCtx *cli.Context) error {
+	conntrackService := service.ConntrackService{}
+	config, err := conntrackService.GetConfig(cCtx.String("table"))
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("table: %s\n", config.Table)
+	fmt.Printf("established: %s\n", onOff(config.Established))
+	fmt.Printf("invalid: %s\n", onOff(config.Invalid))
+	return nil
+}
+
+// setConntrack 开启或关闭连接跟踪规则 established / invalid，其他规则不变
+func setConntrack(enable bool) cli.ActionFunc {
+	return func(cCtx *cli.Context) error {
+		conntrackService := service.ConntrackService{}
+		config, err := conntrackService.GetConfig(cCtx.String("table"))
+		if err != nil {
+			return err
+		}
+
+		if cCtx.NArg() == 0 {
+			return strerror.CreateError("conntrack rule is required: established / invalid")
+		}
+		for _, name := range cCtx.Args().Slice() {
+			switch name {
+			case "established":
+				config.Established = enable
+			case "invalid":
+				config.Invalid = enable
+			default:
+				return strerror.CreateError("conntrack rule error:" + name)
+			}
+		}
+		return conntrackService.SetConfig(config)
+	}
+}
+
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
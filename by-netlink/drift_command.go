@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/service"
+	tail_log "netvine.com/firewall/server/utils/log"
+)
+
+// driftCommand 对比内核中的策略链和策略文件
+func driftCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "drift",
+		Usage: "对比内核中的策略链和策略文件",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "check",
+				Usage: "对比一次，列出缺少、多余的规则和顺序不同",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "heal", Usage: "不一致时重新下发策略文件"},
+					&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+				},
+				Action: checkDrift,
+			},
+			{
+				Name:  "watch",
+				Usage: "监视策略表的变化，不一致的规则保存为告警",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{Name: "heal", Usage: "不一致时重新下发策略文件"},
+					&cli.DurationFlag{Name: "settle", Value: service.DefaultDriftSettle, Usage: "最后一次变化之后等待多久再对比: --settle 2s"},
+				}, exportFlags...),
+				Action: watchDrift,
+			},
+		},
+	}
+}
+
+// newDriftService 对比策略链和策略文件
+func newDriftService(cCtx *cli.Context) (*service.DriftService, error) {
+	backend, err := newRuleBackend(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewDriftService(backend, newPolicyStore(cCtx)), nil
+}
+
+// checkDrift 对比一次策略链和策略文件，--heal 时不一致则重新下发策略文件
+func checkDrift(cCtx *cli.Context) error {
+	drift, err := newDriftService(cCtx)
+	if err != nil {
+		return err
+	}
+	report, err := drift.Check(cCtx.Bool("heal"))
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if !service.Drifted(report) {
+		fmt.Printf("no drift, store version %d\n", report.StoreVersion)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tID\tVERSION\tHANDLE\tCOMMENT")
+	for _, rule := range report.Missing {
+		fmt.Fprintf(w, "%s\t%s\t%s\t-\t%s\n", service.DriftMissing, listValue(rule.PolicyId), listValue(rule.Version), rule.Comment)
+	}
+	for _, rule := range report.Unexpected {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", service.DriftUnexpected, listValue(rule.PolicyId), listValue(rule.Version),
+			rule.Handle, listValue(rule.Comment))
+	}
+	if report.Reordered {
+		fmt.Fprintf(w, "%s\t-\t-\t-\t-\n", service.DriftReordered)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if report.Healed {
+		fmt.Printf("healed, restore store version %d\n", report.StoreVersion)
+	}
+	return nil
+}
+
+// watchDrift 监视策略表的变化，不一致的规则保存为告警并导出
+func watchDrift(cCtx *cli.Context) error {
+	drift, err := newDriftService(cCtx)
+	if err != nil {
+		return err
+	}
+	drift.Heal = cCtx.Bool("heal")
+	drift.Settle = cCtx.Duration("settle")
+
+	events := newEventLogService(cCtx)
+	defer events.Close()
+	var sink tail_log.Sink = events
+	exporters, err := newExporters(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(exporters) != 0 {
+		defer exporters.Close()
+		sink = tail_log.MultiSink{sink, exporters}
+	}
+	drift.Sink = sink
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		close(stop)
+	}()
+
+	var mu sync.Mutex
+	return drift.Run(&mu, stop)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/service"
+	"netvine.com/firewall/server/store"
+)
+
+// eventCommands 查询保存的告警和安全日志
+func eventCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:   "alarms",
+			Usage:  "查询告警: log alarms --since \"2022-11-22 18:00:00\" --ip 192.168.0.0/24 --port 502",
+			Flags:  eventQueryFlags,
+			Action: queryEvents(service.EventKindAlarm),
+		},
+		{
+			Name:   "security",
+			Usage:  "查询安全日志: log security --policy policy1 --action drop",
+			Flags:  eventQueryFlags,
+			Action: queryEvents(service.EventKindLog),
+		},
+	}
+}
+
+// eventQueryFlags 查询告警和安全日志的参数
+var eventQueryFlags = []cli.Flag{
+	&cli.StringFlag{Name: "since", Usage: "开始时间: --since \"2022-11-22 18:00:00\""},
+	&cli.StringFlag{Name: "until", Usage: "结束时间: --until 2022-11-23"},
+	&cli.StringFlag{Name: "policy", Usage: "策略ID: --policy policy1"},
+	&cli.StringFlag{Name: "ip", Usage: "源或目的地址、网段: --ip 192.168.0.0/24"},
+	&cli.IntFlag{Name: "port", Usage: "源或目的端口: --port 502"},
+	&cli.StringFlag{Name: "action", Aliases: []string{"a"}, Usage: "动作: --action allow/warn/drop"},
+	&cli.IntFlag{Name: "limit", Value: store.DefaultEventLimit, Usage: "最多返回的事件数: --limit 100"},
+	&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+}
+
+// queryEvents 按时间倒序查询告警或者安全日志
+func queryEvents(kind string) cli.ActionFunc {
+	return func(cCtx *cli.Context) error {
+		query := model.EventQuery{PolicyId: cCtx.String("policy"), Ip: cCtx.String("ip"), Port: cCtx.Int("port"),
+			Action: cCtx.String("action"), Limit: cCtx.Int("limit")}
+		var err error
+		if cCtx.IsSet("since") {
+			if query.Since, err = store.ParseEventTime(cCtx.String("since")); err != nil {
+				return err
+			}
+		}
+		if cCtx.IsSet("until") {
+			if query.Until, err = store.ParseEventTime(cCtx.String("until")); err != nil {
+				return err
+			}
+		}
+
+		events, err := newEventLogService(cCtx).Query(kind, query)
+		if err != nil {
+			return err
+		}
+
+		if cCtx.Bool("json") {
+			if events == nil {
+				events = []model.LogEvent{}
+			}
+			data, err := json.MarshalIndent(events, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tPOLICY\tACTION\tIIF\tOIF\tPROTO\tSRC\tDST\tSMAC\tLOGTAG")
+		for _, event := range events {
+			policy := event.PolicyId
+			if len(policy) == 0 {
+				policy = event.Source
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				event.Time.Local().Format("2006-01-02 15:04:05"), listValue(policy), listValue(event.Action),
+				listValue(event.IIfName), listValue(event.OIfName), listValue(event.Protocol),
+				listValue(eventAddr(event.SIp, event.SPort)), listValue(eventAddr(event.DIp, event.DPort)),
+				listValue(event.SMac), listValue(event.LogTag))
+		}
+		return w.Flush()
+	}
+}
+
+// eventAddr 地址和端口 192.168.0.1:502 / [2001:db8::1]:502
+func eventAddr(ip string, port int) string {
+	if port == 0 {
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+	tail_log "netvine.com/firewall/server/utils/log"
+)
+
+// exportFlags 导出日志事件和审计事件的参数
+var exportFlags = []cli.Flag{
+	&cli.StringFlag{Name: "syslog", EnvVars: []string{"FD_SYSLOG"}, Usage: "日志服务器: --syslog udp://10.0.0.1:514 / tcp://10.0.0.1:601 / tls://10.0.0.1:6514"},
+	&cli.StringFlag{Name: "syslog-format", Value: string(tail_log.FormatJSON), Usage: "发送到日志服务器的格式: --syslog-format json/cef/leef"},
+	&cli.StringFlag{Name: "syslog-ca", Usage: "tls方式校验日志服务器的CA证书: --syslog-ca /etc/fd/ca.pem"},
+	&cli.StringFlag{Name: "syslog-cert", Usage: "tls方式的客户端证书: --syslog-cert /etc/fd/client.pem"},
+	&cli.StringFlag{Name: "syslog-key", Usage: "tls方式的客户端私钥: --syslog-key /etc/fd/client.key"},
+	&cli.StringFlag{Name: "syslog-buffer", Value: "/var/lib/fd/syslog-buffer", Usage: "日志服务器不可用时的磁盘缓冲目录"},
+	&cli.Int64Flag{Name: "syslog-buffer-size", Value: 64, Usage: "磁盘缓冲的最大MB数，超过时丢弃最早的消息"},
+	&cli.StringFlag{Name: "export-file", Usage: "同时导出到文件，每行一个事件: --export-file /var/log/fd/export.log"},
+	&cli.StringFlag{Name: "export-format", Value: string(tail_log.FormatJSON), Usage: "导出到文件的格式: --export-format json/cef/leef"},
+}
+
+// testExportCommand 发送示例事件检查导出配置
+func testExportCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "test-export",
+		Usage:  "发送示例事件检查导出配置: log test-export --syslog tcp://127.0.0.1:601 --syslog-format cef",
+		Flags:  exportFlags,
+		Action: testExport,
+	}
+}
+
+// newExporters 根据参数创建syslog和文件导出，没有设置时返回空
+func newExporters(cCtx *cli.Context) (tail_log.Exporters, error) {
+	var exporters tail_log.Exporters
+
+	if value := cCtx.String("syslog"); len(value) != 0 {
+		format, err := tail_log.ParseFormat(cCtx.String("syslog-format"))
+		if err != nil {
+			return nil, err
+		}
+		network, addr, err := tail_log.ParseSyslogAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		config := tail_log.SyslogConfig{Network: network, Addr: addr,
+			Buffer: tail_log.NewDiskBuffer(cCtx.String("syslog-buffer"), cCtx.Int64("syslog-buffer-size")*1024*1024)}
+		if network == tail_log.NetworkTLS {
+			if config.TLS, err = syslogTLSConfig(cCtx, addr); err != nil {
+				return nil, err
+			}
+		}
+		exporters = append(exporters, &tail_log.Exporter{Format: format, Output: tail_log.NewSyslogOutput(config)})
+	}
+
+	if path := cCtx.String("export-file"); len(path) != 0 {
+		format, err := tail_log.ParseFormat(cCtx.String("export-format"))
+		if err != nil {
+			exporters.Close()
+			return nil, err
+		}
+		output, err := tail_log.OpenFileOutput(path)
+		if err != nil {
+			exporters.Close()
+			return nil, err
+		}
+		exporters = append(exporters, &tail_log.Exporter{Format: format, Output: output})
+	}
+	return exporters, nil
+}
+
+// syslogTLSConfig 指定CA时只信任该CA，否则使用系统证书，指定客户端证书时双向认证
+func syslogTLSConfig(cCtx *cli.Context, addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	if path := cCtx.String("syslog-ca"); len(path) != 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, strerror.CreateError("syslog ca error:" + path)
+		}
+		config.RootCAs = pool
+	}
+	if cCtx.IsSet("syslog-cert") || cCtx.IsSet("syslog-key") {
+		cert, err := tls.LoadX509KeyPair(cCtx.String("syslog-cert"), cCtx.String("syslog-key"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// testExport 发送一个示例日志事件和审计事件，用于检查日志服务器配置
+func testExport(cCtx *cli.Context) error {
+	exporters, err := newExporters(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(exporters) == 0 {
+		return strerror.CreateError("--syslog or --export-file is required")
+	}
+	defer exporters.Close()
+
+	now := time.Now()
+	event := model.LogEvent{Time: now, Prefix: "test#W@L", LogTag: "test", Warn: true, Log: true, Source: service.LogSourcePolicy,
+		PolicyId: "test", Action: "warn", IIfName: "eth0", OIfName: "eth1", SIp: "192.168.0.1", DIp: "10.0.0.1",
+		Protocol: "tcp", SPort: 40000, DPort: 502, Length: 60}
+	if err := exporters.Write(event); err != nil {
+		return err
+	}
+	return exporters.WriteAudit(model.AuditEvent{Time: now, Source: "cli", Method: "TEST", Path: "/api/policys/test", PolicyId: "test", Status: 200})
+}
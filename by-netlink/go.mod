@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/google/nftables v0.0.0-20221015190445-4f5cd5826fbd
+	github.com/mdlayher/netlink v1.4.2
 	github.com/progrium/go-shell v0.0.0-20181023041501-104b11941186
 	github.com/urfave/cli/v2 v2.20.2
 	github.com/vishvananda/netns v0.0.0-20220913150850-18c4f4234207
@@ -15,7 +16,6 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
 	github.com/google/go-cmp v0.5.6 // indirect
 	github.com/josharian/native v0.0.0-20200817173448-b6b71def0850 // indirect
-	github.com/mdlayher/netlink v1.4.2 // indirect
 	github.com/mdlayher/socket v0.0.0-20211102153432-57e3fa563ecb // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
@@ -0,0 +1,286 @@
+package libnft
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+)
+
+const (
+	family    = string(nft.NftFamily)
+	tableName = nft.NftTable
+	chainName = nft.BaseRuleChain
+
+	listChain  = "list chain %s %s %s"
+	listChains = "list chains %s"
+)
+
+// Nftables libnftables JSON 格式
+// {"nftables":[{"add":{"table":{...}}},{"add":{"rule":{...}}}]}
+type Nftables struct {
+	Nftables []map[string]interface{} `json:"nftables"`
+}
+
+// PolicyManagerLibNftService 通过libnftables JSON接口下发策略
+type PolicyManagerLibNftService struct {
+}
+
+func table() map[string]interface{} {
+	return map[string]interface{}{"family": family, "name": tableName}
+}
+
+func chain() map[string]interface{} {
+	return map[string]interface{}{"family": family, "table": tableName, "name": chainName}
+}
+
+func rule() map[string]interface{} {
+	return map[string]interface{}{"family": family, "table": tableName, "chain": chainName}
+}
+
+func baseChain() map[string]interface{} {
+	c := chain()
+	c["type"] = string(nft.TypeFilter)
+	c["hook"] = string(nft.HookForward)
+	c["prio"] = 0
+	c["policy"] = string(nft.PolicyDrop)
+	return c
+}
+
+// initCommands 创建策略表和链，已存在时add不会报错
+func initCommands() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"add": map[string]interface{}{"table": table()}},
+		{"add": map[string]interface{}{"chain": baseChain()}},
+	}
+}
+
+// apply 一次提交所有命令，libnftables保证同一批次的原子性
+func apply(commands []map[string]interface{}) error {
+	data, err := json.Marshal(Nftables{Nftables: commands})
+	if err != nil {
+		return err
+	}
+
+	_, err = runCmd(string(data))
+	return err
+}
+
+// ApplyPolicys 追加下发策略
+func (p *PolicyManagerLibNftService) ApplyPolicys(policys []model.Policy) error {
+	return p.applyPolicys(policys, false)
+}
+
+// ReplacePolicys 清空策略链并下发策略，在同一个批次中执行
+func (p *PolicyManagerLibNftService) ReplacePolicys(policys []model.Policy) error {
+	return p.applyPolicys(policys, true)
+}
+
+// conntrackCommands 重新添加清空前已启用的连接跟踪规则
+func conntrackCommands(rules []model.RuleInfo) []map[string]interface{} {
+	var commands []map[string]interface{}
+	for _, comment := range nft.GetConntrackComments(rules) {
+		if addRule := getConntrackRule(comment); addRule != nil {
+			commands = append(commands, map[string]interface{}{"add": map[string]interface{}{"rule": addRule}})
+		}
+	}
+	return commands
+}
+
+// ruleCounterName 规则JSON表达式引用的计数器 {"counter":"fd-counter-policy1"}
+func ruleCounterName(exprs string) string {
+	var statements []map[string]interface{}
+	if err := json.Unmarshal([]byte(exprs), &statements); err != nil {
+		return ""
+	}
+	for _, statement := range statements {
+		if name, ok := statement["counter"].(string); ok && nft.IsCounterName(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// deleteCounterCommands 删除已清空的规则引用、新策略不再使用的计数器
+func deleteCounterCommands(rules []model.RuleInfo, keep map[string]bool) []map[string]interface{} {
+	var commands []map[string]interface{}
+	for _, r := range rules {
+		name := ruleCounterName(r.Expr)
+		if len(name) == 0 || keep[name] {
+			continue
+		}
+		keep[name] = true
+		counter := map[string]interface{}{"family": family, "table": tableName, "name": name}
+		commands = append(commands, map[string]interface{}{"delete": map[string]interface{}{"counter": counter}})
+	}
+	return commands
+}
+
+// ruleMeterSetName 规则JSON表达式引用的动态集合 {"set":{"op":"update","set":"@fd-meter-policy1-ip4",...}}
+func ruleMeterSetName(exprs string) string {
+	var statements []map[string]interface{}
+	if err := json.Unmarshal([]byte(exprs), &statements); err != nil {
+		return ""
+	}
+	for _, statement := range statements {
+		set, ok := statement["set"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := set["set"].(string)
+		if name = strings.TrimPrefix(name, "@"); nft.IsMeterSetName(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// deleteMeterSetCommands 删除已清空的规则引用、新策略不再使用的动态集合
+func deleteMeterSetCommands(rules []model.RuleInfo, keep map[string]bool) []map[string]interface{} {
+	var commands []map[string]interface{}
+	for _, r := range rules {
+		name := ruleMeterSetName(r.Expr)
+		if len(name) == 0 || keep[name] {
+			continue
+		}
+		keep[name] = true
+		set := map[string]interface{}{"family": family, "table": tableName, "name": name}
+		commands = append(commands, map[string]interface{}{"delete": map[string]interface{}{"set": set}})
+	}
+	return commands
+}
+
+// applyPolicys 所有命令在一个批次中提交，任何一条失败时都不会修改规则
+// 清空策略链时保留连接跟踪规则，删除不再使用的计数器和动态集合
+func (p *PolicyManagerLibNftService) applyPolicys(policys []model.Policy, flush bool) error {
+	commands := initCommands()
+
+	var rules []model.RuleInfo
+	if flush {
+		var err error
+		rules, err = p.ListRules()
+		if err != nil {
+			return err
+		}
+		commands = append(commands, map[string]interface{}{"flush": map[string]interface{}{"chain": chain()}})
+		commands = append(commands, conntrackCommands(rules)...)
+	}
+
+	keep, keepSets := make(map[string]bool), make(map[string]bool)
+	for _, policy := range policys {
+		// 展开应用，同时包含IPv4和IPv6地址的策略按地址族拆分成多条规则
+		familyPolicys, err := nft.SplitPolicy(policy)
+		if err != nil {
+			return err
+		}
+
+		comment := nft.RuleComment(policy)
+		if counter := getCounter(comment); counter != nil {
+			keep[nft.CounterName(comment)] = true
+			commands = append(commands, map[string]interface{}{"add": map[string]interface{}{"counter": counter}})
+		}
+
+		for _, familyPolicy := range familyPolicys {
+			exprs, err := GetRuleExprs(familyPolicy, comment)
+			if err != nil {
+				return err
+			}
+
+			meterSet, err := getMeterSet(familyPolicy)
+			if err != nil {
+				return err
+			}
+			if meterSet != nil {
+				keepSets[meterSet["name"].(string)] = true
+				commands = append(commands, map[string]interface{}{"add": map[string]interface{}{"set": meterSet}})
+			}
+
+			addRule := rule()
+			addRule["expr"] = exprs
+			addRule["comment"] = comment
+			commands = append(commands, map[string]interface{}{"add": map[string]interface{}{"rule": addRule}})
+		}
+	}
+
+	commands = append(commands, deleteCounterCommands(rules, keep)...)
+	return apply(append(commands, deleteMeterSetCommands(rules, keepSets)...))
+}
+
+// chainExist 策略链是否存在，只读取不创建
+func chainExist() (bool, error) {
+	output, err := runCmd(fmt.Sprintf(listChains, family))
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		Nftables []struct {
+			Chain *struct {
+				Table string `json:"table"`
+				Name  string `json:"name"`
+			} `json:"chain"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return false, err
+	}
+	for _, item := range result.Nftables {
+		if item.Chain != nil && item.Chain.Table == tableName && item.Chain.Name == chainName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListRules 查看已下发的规则，策略链不存在时返回空，不创建表和链
+func (p *PolicyManagerLibNftService) ListRules() ([]model.RuleInfo, error) {
+	exist, err := chainExist()
+	if err != nil || !exist {
+		return nil, err
+	}
+
+	output, err := runCmd(fmt.Sprintf(listChain, family, tableName, chainName))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Nftables []struct {
+			Rule *struct {
+				Handle  uint64          `json:"handle"`
+				Expr    json.RawMessage `json:"expr"`
+				Comment string          `json:"comment"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, err
+	}
+
+	var rules []model.RuleInfo
+	for _, item := range result.Nftables {
+		if item.Rule != nil {
+			rule := model.RuleInfo{Handle: item.Rule.Handle, Expr: string(item.Rule.Expr)}
+			nft.SetRuleComment(&rule, item.Rule.Comment)
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// DeleteRule 根据句柄删除规则
+func (p *PolicyManagerLibNftService) DeleteRule(handle uint64) error {
+	delRule := rule()
+	delRule["handle"] = handle
+
+	return apply([]map[string]interface{}{
+		{"delete": map[string]interface{}{"rule": delRule}},
+	})
+}
+
+// FlushRules 清空策略链，保留连接跟踪规则
+func (p *PolicyManagerLibNftService) FlushRules() error {
+	return p.applyPolicys(nil, true)
+}
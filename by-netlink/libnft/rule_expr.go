@@ -0,0 +1,513 @@
+package libnft
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	iptools "netvine.com/firewall/server/utils"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// {"match":{"op":"==","left":{"meta":{"key":"iifname"}},"right":"enp2s0"}}
+func match(left interface{}, right interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"match": map[string]interface{}{"op": "==", "left": left, "right": right},
+	}
+}
+
+func meta(key string) map[string]interface{} {
+	return map[string]interface{}{"meta": map[string]interface{}{"key": key}}
+}
+
+func payload(protocol string, field string) map[string]interface{} {
+	return map[string]interface{}{"payload": map[string]interface{}{"protocol": protocol, "field": field}}
+}
+
+func valueRange(start interface{}, end interface{}) map[string]interface{} {
+	return map[string]interface{}{"range": []interface{}{start, end}}
+}
+
+func set(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return map[string]interface{}{"set": values}
+}
+
+// ipValue 192.168.0.1 / 192.168.1.1-192.168.1.100 / 192.168.2.0/24
+func ipValue(ip string) (interface{}, error) {
+	if strings.Contains(ip, "-") {
+		ipRange := strings.Split(ip, "-")
+		if len(ipRange) != 2 {
+			return nil, strerror.CreateError("ip range error:" + ip)
+		}
+		return valueRange(ipRange[0], ipRange[1]), nil
+	}
+
+	if strings.Contains(ip, "/") {
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, err
+		}
+		ones, _ := ipNet.Mask.Size()
+		return map[string]interface{}{"prefix": map[string]interface{}{"addr": ipNet.IP.String(), "len": ones}}, nil
+	}
+
+	if net.ParseIP(ip) == nil {
+		return nil, strerror.CreateError("ip error:" + ip)
+	}
+	return ip, nil
+}
+
+func stringValues(values []string) []interface{} {
+	var result []interface{}
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
+
+// getProtocolExprs 协议、ICMP类型和TCP标志
+// {"match":{"op":"==","left":{"&":[{"payload":{"protocol":"tcp","field":"flags"}},{"|":["syn","ack"]}]},"right":"syn"}}
+func getProtocolExprs(policy model.Policy) ([]interface{}, error) {
+	protocolMatch, err := nft.GetProtocolMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var protocols []interface{}
+	for _, protocol := range protocolMatch.Protocols {
+		protocols = append(protocols, int(protocol))
+	}
+	exprs := []interface{}{match(meta("l4proto"), set(protocols))}
+
+	icmpProtocol := "icmp"
+	if protocolMatch.Icmpv6() {
+		icmpProtocol = "icmpv6"
+	}
+	if protocolMatch.IcmpType >= 0 {
+		exprs = append(exprs, match(payload(icmpProtocol, "type"), protocolMatch.IcmpType))
+	}
+	if protocolMatch.IcmpCode >= 0 {
+		exprs = append(exprs, match(payload(icmpProtocol, "code"), protocolMatch.IcmpCode))
+	}
+
+	if protocolMatch.TcpMask != 0 {
+		var flags interface{} = 0
+		if protocolMatch.TcpFlags != 0 {
+			flags = tcpFlagValue(protocolMatch.TcpFlags)
+		}
+		exprs = append(exprs, match(map[string]interface{}{
+			"&": []interface{}{payload("tcp", "flags"), tcpFlagValue(protocolMatch.TcpMask)},
+		}, flags))
+	}
+	return exprs, nil
+}
+
+// tcpFlagValue "syn" / {"|":["syn","ack"]}，多个标志嵌套成两个操作数的表达式
+func tcpFlagValue(flags uint8) interface{} {
+	names := nft.TcpFlagNames(flags)
+	var value interface{} = names[0]
+	for _, name := range names[1:] {
+		value = map[string]interface{}{"|": []interface{}{value, name}}
+	}
+	return value
+}
+
+// portValues 22 / {"range":[8000,8100]}
+func portValues(ports model.Ports) ([]interface{}, error) {
+	ranges, err := nft.GetPortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []interface{}
+	for _, r := range ranges {
+		if r.Start == r.End {
+			result = append(result, int(r.Start))
+		} else {
+			result = append(result, valueRange(int(r.Start), int(r.End)))
+		}
+	}
+	return result, nil
+}
+
+func ipValues(values []string) ([]interface{}, error) {
+	var result []interface{}
+	for _, v := range values {
+		ip, err := ipValue(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ip)
+	}
+	return result, nil
+}
+
+// weekValues 0,1,2 / 1,3-5 转换为 Sunday、Monday...
+func weekValues(week string) ([]interface{}, error) {
+	var result []interface{}
+	for _, day := range strings.Split(week, ",") {
+		if strings.Contains(day, "-") {
+			dayRange := strings.Split(day, "-")
+			if len(dayRange) != 2 {
+				return nil, strerror.CreateError("week range error:" + day)
+			}
+			start, err := weekday(dayRange[0])
+			if err != nil {
+				return nil, err
+			}
+			end, err := weekday(dayRange[1])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, valueRange(start, end))
+		} else {
+			value, err := weekday(day)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+func weekday(day string) (string, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(day))
+	if err != nil || value < 0 || value > 6 {
+		return "", strerror.CreateError("week error:" + day)
+	}
+	return time.Weekday(value).String(), nil
+}
+
+// timeRangeValue 18:00:00-19:00:00 / 2022-11-22 18:00:00-2022-11-22 19:00:00
+func timeRangeValue(value string) (interface{}, error) {
+	if !strings.Contains(value, "-") {
+		return value, nil
+	}
+
+	start, end, err := nft.SplitTimeRange(value)
+	if err != nil {
+		return nil, err
+	}
+	return valueRange(start, end), nil
+}
+
+// timeRangeValues 多个时间段生成集合
+func timeRangeValues(values []string) (interface{}, error) {
+	var result []interface{}
+	for _, value := range values {
+		timeValue, err := timeRangeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, timeValue)
+	}
+	return set(result), nil
+}
+
+// getTimeExprs 与nft命令生成的时间规则保持一致
+func getTimeExprs(times []model.PolicyTime) ([]interface{}, error) {
+	var exprs []interface{}
+
+	values, err := nft.GetPolicyTimeValues(times)
+	if err != nil {
+		return nil, err
+	}
+
+	// 日期时间，时间戳
+	if len(values.TimeStamps) != 0 {
+		value, err := timeRangeValues(values.TimeStamps)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(meta("time"), value))
+	}
+
+	// 小时
+	if len(values.Hours) != 0 {
+		value, err := timeRangeValues(values.Hours)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(meta("hour"), value))
+	}
+
+	// 周
+	if len(values.Weeks) != 0 {
+		weeks, err := weekValues(strings.Join(values.Weeks, ","))
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(meta("day"), set(weeks)))
+	}
+
+	return exprs, nil
+}
+
+func ct(key string) map[string]interface{} {
+	return map[string]interface{}{"ct": map[string]interface{}{"key": key}}
+}
+
+// ctStateMatch 连接状态按位匹配
+// {"match":{"op":"in","left":{"ct":{"key":"state"}},"right":["established","related"]}}
+func ctStateMatch(state uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"match": map[string]interface{}{"op": "in", "left": ct("state"), "right": nft.CtStateNames(state)},
+	}
+}
+
+// getCtExprs 连接状态、方向和标记
+func getCtExprs(policy model.Policy) ([]interface{}, error) {
+	ctMatch, err := nft.GetCtMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var exprs []interface{}
+	if ctMatch.State != 0 {
+		exprs = append(exprs, ctStateMatch(ctMatch.State))
+	}
+	if len(ctMatch.Direction) != 0 {
+		exprs = append(exprs, match(ct("direction"), ctMatch.Direction))
+	}
+	if ctMatch.HasMark {
+		exprs = append(exprs, match(ct("mark"), ctMatch.Mark))
+	}
+	return exprs, nil
+}
+
+// getConntrackRule 连接跟踪规则 ct state established,related accept
+func getConntrackRule(comment string) map[string]interface{} {
+	state, action := nft.ConntrackRuleState(comment)
+	if state == 0 {
+		return nil
+	}
+	addRule := rule()
+	addRule["expr"] = []interface{}{ctStateMatch(state), map[string]interface{}{string(action): nil}}
+	addRule["comment"] = comment
+	return addRule
+}
+
+// getLimitExprs 限速和连接数限制，按地址计算时使用动态集合
+// {"limit":{"rate":100,"per":"second","burst":5,"burst_unit":"packets","inv":true}} {"ct count":{"val":10,"inv":true}}
+// {"set":{"op":"update","elem":{"payload":{"protocol":"ip","field":"saddr"}},"set":"@fd-meter-policy1-ip4","stmt":[...]}}
+func getLimitExprs(policy model.Policy) ([]interface{}, error) {
+	limitMatch, err := nft.GetLimitMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []interface{}
+	if limitMatch.Rate != 0 {
+		limit := map[string]interface{}{"rate": limitMatch.Rate, "per": limitUnit(limitMatch.Unit), "inv": true}
+		if limitMatch.Bytes {
+			limit["rate_unit"] = "bytes"
+			if limitMatch.Burst != 0 {
+				limit["burst"], limit["burst_unit"] = limitMatch.Burst, "bytes"
+			}
+		} else {
+			limit["burst"], limit["burst_unit"] = limitMatch.Burst, "packets"
+		}
+		statements = append(statements, map[string]interface{}{"limit": limit})
+	}
+	if limitMatch.ConnCount != 0 {
+		statements = append(statements, map[string]interface{}{"ct count": map[string]interface{}{"val": limitMatch.ConnCount, "inv": true}})
+	}
+	if len(limitMatch.Per) == 0 {
+		return statements, nil
+	}
+
+	ipv6, err := nft.MeterIPv6(policy)
+	if err != nil {
+		return nil, err
+	}
+	protocol := "ip"
+	if ipv6 {
+		protocol = "ip6"
+	}
+	return []interface{}{map[string]interface{}{"set": map[string]interface{}{
+		"op":   "update",
+		"elem": payload(protocol, limitMatch.Per),
+		"set":  "@" + nft.MeterSetName(policy, ipv6),
+		"stmt": statements,
+	}}}, nil
+}
+
+// limitUnit 秒数转换为 second / minute / hour / day / week
+func limitUnit(seconds uint64) string {
+	rate := nft.FormatLimitRate(1, seconds, false)
+	return rate[strings.Index(rate, "/")+1:]
+}
+
+// getMeterSet 按地址计算时需要先创建的动态集合，已存在时add不会报错
+func getMeterSet(policy model.Policy) (map[string]interface{}, error) {
+	if len(policy.LimitPer) == 0 {
+		return nil, nil
+	}
+	ipv6, err := nft.MeterIPv6(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]interface{}{
+		"family":  family,
+		"table":   tableName,
+		"name":    nft.MeterSetName(policy, ipv6),
+		"type":    "ipv4_addr",
+		"flags":   []string{"dynamic", "timeout"},
+		"timeout": int(nft.MeterTimeout.Seconds()),
+	}
+	if ipv6 {
+		set["type"] = "ipv6_addr"
+	}
+	return set, nil
+}
+
+// getCounter 策略的命名计数器，需要先于规则创建，已存在时add不会报错
+func getCounter(comment string) map[string]interface{} {
+	name := nft.CounterName(comment)
+	if len(name) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"family": family, "table": tableName, "name": name}
+}
+
+// ipProtocol 按地址族选择 ip 或者 ip6
+func ipProtocol(values []string) string {
+	if isIPv6, _ := iptools.IsIPv6(values[0]); isIPv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+// GetRuleExprs 生成规则的JSON表达式，策略中的地址必须属于同一个地址族
+// comment 为规则注释，用于引用策略的命名计数器 {"counter":"fd-counter-policy1"}
+func GetRuleExprs(policy model.Policy, comment string) ([]interface{}, error) {
+	var exprs []interface{}
+
+	// 出入接口
+	if len(policy.SRegion) != 0 {
+		exprs = append(exprs, match(meta("iifname"), set(stringValues(policy.SRegion))))
+	}
+
+	if len(policy.DRegion) != 0 {
+		exprs = append(exprs, match(meta("oifname"), set(stringValues(policy.DRegion))))
+	}
+
+	// 地址族，没有地址的策略按地址族拆分时设置
+	if len(policy.Family) != 0 {
+		exprs = append(exprs, match(meta("nfproto"), policy.Family))
+	}
+
+	// 源IP
+	if len(policy.SIp) != 0 {
+		values, err := ipValues(policy.SIp)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(payload(ipProtocol(policy.SIp), "saddr"), set(values)))
+	}
+
+	// 目的IP
+	if len(policy.DIp) != 0 {
+		values, err := ipValues(policy.DIp)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(payload(ipProtocol(policy.DIp), "daddr"), set(values)))
+	}
+
+	// 协议
+	if len(policy.Protocol) != 0 {
+		protocolExprs, err := getProtocolExprs(policy)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, protocolExprs...)
+	}
+
+	// source mac
+	if len(policy.SMac) != 0 {
+		exprs = append(exprs, match(payload("ether", "saddr"), policy.SMac))
+	}
+
+	// dest mac
+	if len(policy.DMac) != 0 {
+		exprs = append(exprs, match(payload("ether", "daddr"), policy.DMac))
+	}
+
+	// source port
+	if len(policy.SPort) != 0 {
+		values, err := portValues(policy.SPort)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(payload("th", "sport"), set(values)))
+	}
+
+	// dest port
+	if len(policy.DPort) != 0 {
+		values, err := portValues(policy.DPort)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, match(payload("th", "dport"), set(values)))
+	}
+
+	// 时间
+	if len(policy.Time) != 0 {
+		timeExprs, err := getTimeExprs(policy.Time)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, timeExprs...)
+	}
+
+	// 连接状态、方向和标记
+	if nft.HasCt(policy) {
+		ctExprs, err := getCtExprs(policy)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, ctExprs...)
+	}
+
+	// 限速、连接数限制
+	if nft.HasLimit(policy) {
+		limitExprs, err := getLimitExprs(policy)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, limitExprs...)
+	}
+
+	// 命中计数
+	if name := nft.CounterName(comment); len(name) != 0 {
+		exprs = append(exprs, map[string]interface{}{"counter": name})
+	}
+
+	// 日志
+	if len(policy.LogTag) != 0 {
+		exprs = append(exprs, map[string]interface{}{
+			"log": map[string]interface{}{"prefix": nft.LogPrefix(policy), "group": nft.LogGroup},
+		})
+	}
+
+	// 动作
+	switch nft.PolicyRuleAction(policy) {
+	case nft.ActionDrop:
+		exprs = append(exprs, map[string]interface{}{"drop": nil})
+	case nft.ActionAccept:
+		exprs = append(exprs, map[string]interface{}{"accept": nil})
+	default:
+		exprs = append(exprs, map[string]interface{}{"queue": map[string]interface{}{"num": 0}})
+	}
+
+	return exprs, nil
+}
@@ -0,0 +1,28 @@
+//go:build !libnftables
+// +build !libnftables
+
+package libnft
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// runCmd 没有libnftables开发库时，通过nft -j -f - 把命令交给libnftables解析
+// 编译时加上 -tags libnftables 则直接调用libnftables
+func runCmd(command string) ([]byte, error) {
+	cmd := exec.Command("nft", "-j", "-a", "-f", "-")
+	cmd.Stdin = strings.NewReader(command)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, strerror.CreateError("nft -j failed: " + err.Error() + " " + stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
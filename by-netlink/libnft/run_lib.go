@@ -0,0 +1,32 @@
+//go:build libnftables
+// +build libnftables
+
+package libnft
+
+// #cgo LDFLAGS: -lnftables
+// #include <nftables/libnftables.h>
+// #include <stdlib.h>
+import "C"
+import (
+	"unsafe"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// runCmd 调用libnftables，需要安装 libnftables-dev
+func runCmd(command string) ([]byte, error) {
+	ctx := C.nft_ctx_new(C.NFT_CTX_DEFAULT)
+	defer C.nft_ctx_free(ctx)
+
+	C.nft_ctx_output_set_flags(ctx, C.NFT_CTX_OUTPUT_JSON|C.NFT_CTX_OUTPUT_HANDLE)
+	C.nft_ctx_buffer_output(ctx)
+	C.nft_ctx_buffer_error(ctx)
+
+	buf := C.CString(command)
+	defer C.free(unsafe.Pointer(buf))
+
+	if rc := C.nft_run_cmd_from_buffer(ctx, buf); rc != 0 {
+		return nil, strerror.CreateError("libnftables failed: " + C.GoString(C.nft_ctx_get_error_buffer(ctx)))
+	}
+	return []byte(C.GoString(C.nft_ctx_get_output_buffer(ctx))), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/service"
+)
+
+// lintCommand 检查被覆盖、冗余和冲突的策略
+func lintCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "lint",
+		Usage:  "检查被覆盖、冗余和冲突的策略",
+		Flags:  policySourceFlags,
+		Action: lintPolicys,
+	}
+}
+
+// lintPolicys 检查策略之间的覆盖、冗余和冲突
+func lintPolicys(cCtx *cli.Context) error {
+	policys, err := loadPolicys(cCtx)
+	if err != nil {
+		return err
+	}
+	issues := service.LintPolicys(policys)
+
+	if cCtx.Bool("json") {
+		if issues == nil {
+			issues = []model.LintIssue{}
+		}
+		data, err := json.MarshalIndent(issues, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(issues) == 0 {
+		fmt.Printf("%d policys, no issue found\n", len(policys))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tPOLICY\tRELATED\tMESSAGE")
+	for _, issue := range issues {
+		related := "-"
+		if issue.Related >= 0 {
+			related = fmt.Sprintf("#%d %s", issue.Related, issue.RelatedId)
+		}
+		fmt.Fprintf(w, "%s\t#%d %s\t%s\t%s\n", issue.Type, issue.Index, issue.Id, related, issue.Message)
+	}
+	return w.Flush()
+}
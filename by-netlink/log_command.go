@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/service"
+	tail_log "netvine.com/firewall/server/utils/log"
+)
+
+// logCommand 策略日志、日志导出和日志查询
+func logCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "log",
+		Usage: "策略日志",
+		Subcommands: append([]*cli.Command{
+			{
+				Name:  "tail",
+				Usage: "读取NFLOG日志，按行输出JSON格式的日志事件",
+				Flags: append([]cli.Flag{
+					&cli.UintFlag{Name: "group", Value: tail_log.DefaultGroup, Usage: "NFLOG组: --group 1"},
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "追加写入文件，默认标准输出: --output /var/log/fd/events.json"},
+					&cli.BoolFlag{Name: "store", Usage: "同时保存告警和安全日志"},
+				}, exportFlags...),
+				Action: tailLog,
+			},
+			testExportCommand(),
+		}, eventCommands()...),
+	}
+}
+
+// tailLog 读取NFLOG组，每个日志事件输出一行JSON，收到SIGINT、SIGTERM时退出
+func tailLog(cCtx *cli.Context) error {
+	var sink tail_log.Sink = tail_log.NewJSONLineSink(os.Stdout)
+	if output := cCtx.String("output"); len(output) != 0 && output != "-" {
+		fileSink, file, err := tail_log.OpenFileSink(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sink = fileSink
+	}
+	if cCtx.Bool("store") {
+		events := newEventLogService(cCtx)
+		defer events.Close()
+		sink = tail_log.MultiSink{sink, events}
+	}
+	exporters, err := newExporters(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(exporters) != 0 {
+		defer exporters.Close()
+		sink = tail_log.MultiSink{sink, exporters}
+	}
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		close(stop)
+	}()
+
+	consumer := service.NewLogConsumerService(uint16(cCtx.Uint("group")), sink)
+	return consumer.Run(stop)
+}
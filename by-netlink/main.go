@@ -8,13 +8,220 @@ import (
 	"os"
 	"strings"
 
-	suricatarules "netvine.com/firewall/server/utils/suricata_rules"
-
 	"github.com/urfave/cli/v2"
 	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
 	"netvine.com/firewall/server/service"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
 )
 
+// parsePolicyTime 解析命令行时间
+// hour@16:00:00-18:00:00,20:00:00-21:00:00 ; day@1,3-5 ; month@1,20-25 ; time@2022-11-22 18:00:00-2022-11-22 19:00:00
+func parsePolicyTime(values []string) ([]model.PolicyTime, error) {
+	var times []model.PolicyTime
+	for _, value := range values {
+		timeSplit := strings.SplitN(value, "@", 2)
+		if len(timeSplit) < 2 || len(timeSplit[1]) == 0 {
+			return nil, strerror.CreateError("time error:" + value)
+		}
+
+		switch strings.ToLower(timeSplit[0]) {
+		case "hour":
+			for _, hour := range strings.Split(timeSplit[1], ",") {
+				times = append(times, model.PolicyTime{Hour: hour})
+			}
+		case "day":
+			times = append(times, model.PolicyTime{Week: timeSplit[1]})
+		case "month":
+			times = append(times, model.PolicyTime{Month: timeSplit[1]})
+		case "time":
+			for _, day := range strings.Split(timeSplit[1], ",") {
+				times = append(times, model.PolicyTime{Day: day})
+			}
+		default:
+			return nil, strerror.CreateError("time type error:" + value)
+		}
+	}
+	return times, nil
+}
+
+// policyFlags 创建策略的参数
+var policyFlags = []cli.Flag{
+	&cli.StringFlag{Name: "id", Usage: "策略ID: --id policy1"},
+	&cli.StringFlag{Name: "sregion", Aliases: []string{"sr"}, Usage: "源区域: --sregion eth0,eth1"},
+	&cli.StringFlag{Name: "dregion", Aliases: []string{"dr"}, Usage: "目的区域: --dregion eth0,eth1"},
+	&cli.StringFlag{Name: "sip", Usage: "源IP: --sip 192.168.0.1/24"},
+	&cli.StringFlag{Name: "dip", Usage: "目的IP: --dip 192.168.0.1/24"},
+	&cli.StringFlag{Name: "smac", Usage: "源MAC: --smac 0c:73:eb:92:80:cf"},
+	&cli.StringFlag{Name: "dmac", Usage: "目的MAC: --dmac 0c:73:eb:92:80:cf"},
+	&cli.StringFlag{Name: "protocol", Aliases: []string{"p"}, Usage: "协议: --protocol tcp / --protocol tcp,udp / --protocol 47"},
+	&cli.StringFlag{Name: "icmp-type", Usage: "ICMP类型: --icmp-type echo-request"},
+	&cli.StringFlag{Name: "icmp-code", Usage: "ICMP代码: --icmp-code 0"},
+	&cli.StringFlag{Name: "tcp-flags", Usage: "TCP标志: --tcp-flags syn,!ack"},
+	&cli.StringFlag{Name: "sport", Usage: "源端口: --sport 22 / --sport 1024-65535"},
+	&cli.StringFlag{Name: "dport", Usage: "目的端口: --dport 80,443,8000-8100"},
+	&cli.StringFlag{Name: "app", Usage: "应用，展开为协议和目的端口: --app modbus，app list 查看"},
+	&cli.StringSliceFlag{Name: "time", Aliases: []string{"t"}, Usage: "时间:--t hour/day/month@16:00:00-18:00:00"},
+	&cli.StringFlag{Name: "action", Aliases: []string{"a"}, Usage: "动作: --action accept/drop/log/queue"},
+	&cli.StringFlag{Name: "limit", Usage: "限速，超过限速的报文执行动作: --limit 100/second / --limit \"10 mbytes/second\""},
+	&cli.IntFlag{Name: "limit-burst", Usage: "突发的报文数或者字节数: --limit-burst 10"},
+	&cli.StringFlag{Name: "limit-per", Usage: "按地址限速、限制连接数: --limit-per saddr/daddr"},
+	&cli.IntFlag{Name: "conn-limit", Usage: "最大并发连接数，超过后的连接执行动作: --conn-limit 20"},
+	&cli.StringFlag{Name: "ct-state", Usage: "连接状态: --ct-state new / --ct-state established,related"},
+	&cli.StringFlag{Name: "ct-direction", Usage: "连接方向: --ct-direction original/reply"},
+	&cli.StringFlag{Name: "ct-mark", Usage: "连接标记: --ct-mark 0x10"},
+	&cli.StringFlag{Name: "logtag", Aliases: []string{"log"}, Usage: "动作: --logtag log1122"},
+	&cli.StringFlag{Name: "policy", Usage: "动作: --policy init"},
+}
+
+// parsePolicy 根据命令行参数创建策略
+func parsePolicy(cCtx *cli.Context) (model.Policy, error) {
+	var policy model.Policy
+	id := cCtx.String("id")
+	if len(id) != 0 {
+		if err := nft.CheckPolicyId(id); err != nil {
+			return policy, err
+		}
+		policy.Id = id
+	}
+
+	sRegion := cCtx.String("sregion")
+	if len(sRegion) != 0 {
+		regions := strings.Split(sRegion, ",")
+		policy.SRegion = regions
+	}
+
+	dRegion := cCtx.String("dregion")
+	if len(dRegion) != 0 {
+		regions := strings.Split(dRegion, ",")
+		policy.DRegion = regions
+	}
+
+	sIp := cCtx.String("sip")
+	if len(sIp) != 0 {
+		values := strings.Split(sIp, ",")
+		policy.SIp = values
+	}
+
+	dIp := cCtx.String("dip")
+	if len(dIp) != 0 {
+		values := strings.Split(dIp, ",")
+		policy.DIp = values
+	}
+
+	sMac := cCtx.String("smac")
+	if len(sMac) != 0 {
+		policy.SMac = sMac
+	}
+
+	dMac := cCtx.String("dmac")
+	if len(dMac) != 0 {
+		policy.DMac = dMac
+	}
+
+	protocol := cCtx.String("protocol")
+	if len(protocol) != 0 {
+		policy.Protocol = protocol
+	}
+	policy.IcmpType = cCtx.String("icmp-type")
+	policy.IcmpCode = cCtx.String("icmp-code")
+	policy.TcpFlags = cCtx.String("tcp-flags")
+
+	sport := cCtx.String("sport")
+	if len(sport) != 0 {
+		policy.SPort = model.ParsePorts(sport)
+	}
+
+	dport := cCtx.String("dport")
+	if len(dport) != 0 {
+		policy.DPort = model.ParsePorts(dport)
+	}
+
+	if err := nft.CheckPorts(policy); err != nil {
+		return policy, err
+	}
+
+	app := cCtx.String("app")
+	if len(app) != 0 {
+		policy.App = model.App{Name: app, Predefine: nft.IsPredefinedApp(app)}
+	}
+
+	// --time day@0-6-9
+	timeArray := cCtx.StringSlice("time")
+	if len(timeArray) != 0 {
+		times, err := parsePolicyTime(timeArray)
+		if err != nil {
+			return policy, err
+		}
+		policy.Time = times
+	}
+
+	logTag := cCtx.String("logtag")
+	if len(logTag) != 0 {
+		policy.LogTag = logTag
+	}
+
+	action := cCtx.String("action")
+	if len(action) != 0 {
+		actionValue, err := nft.ParseAction(action)
+		if err != nil {
+			return policy, err
+		}
+		policy.Action = actionValue
+	}
+
+	policy.Limit = cCtx.String("limit")
+	policy.LimitBurst = cCtx.Int("limit-burst")
+	policy.LimitPer = cCtx.String("limit-per")
+	policy.ConnLimit = cCtx.Int("conn-limit")
+	if _, err := nft.GetLimitMatch(policy); err != nil {
+		return policy, err
+	}
+
+	policy.CtState = cCtx.String("ct-state")
+	policy.CtDirection = cCtx.String("ct-direction")
+	policy.CtMark = cCtx.String("ct-mark")
+	if _, err := nft.GetCtMatch(policy); err != nil {
+		return policy, err
+	}
+
+	policyAction := cCtx.String("policy")
+	if len(policyAction) != 0 {
+		policy.Manager = policyAction
+	}
+
+	return policy, nil
+}
+
+func newRuleBackend(cCtx *cli.Context) (service.RuleBackend, error) {
+	return service.NewRuleBackend(service.BackendConfig{Type: service.BackendType(cCtx.String("backend"))})
+}
+
+func newPolicyStore(cCtx *cli.Context) *store.PolicyStore {
+	return store.NewPolicyStore(cCtx.String("store"))
+}
+
+func newEventLogService(cCtx *cli.Context) *service.EventLogService {
+	return service.NewEventLogService(cCtx.String("events"), cCtx.Duration("event-retention"))
+}
+
+func newPolicyStatsService(cCtx *cli.Context) *service.PolicyStatsService {
+	return service.NewPolicyStatsService(store.NewStatsStore(cCtx.String("stats")))
+}
+
+// registerApps 读取自定义应用，文件有错误时只提示，不影响其他命令
+func registerApps(cCtx *cli.Context) error {
+	apps, err := store.NewAppStore(cCtx.String("apps")).Load()
+	if err == nil {
+		err = nft.RegisterApps(apps)
+	}
+	if err != nil {
+		fmt.Printf("app store: %v\n", err)
+	}
+	return nil
+}
+
 // main
 // --sregion eth0,eth1 --dregion eth2,eth3 --sip 192.168.0.1/24 -dip 192.168.0.1/24 -smac 0c:73:eb:92:80:cf -dmac 0c:73:eb:92:80:cf --protocol tcp --sport 22 --app modbus --time-type day --time-value 0-6 --action drop
 func main() {
@@ -29,198 +236,66 @@ func main() {
 					return nil
 				},
 			},
-			{
-				Name:    "whitelist",
-				Aliases: []string{"wl"},
-				Usage:   "白名单模块",
-				Subcommands: []*cli.Command{
-					{
-						Name:  "add",
-						Usage: "增加白名单",
-						Action: func(cCtx *cli.Context) error {
-							rule := cCtx.Args().First()
-							fmt.Println("新增白名单规则:", rule)
-							suricatarules.AddWhiteList(rule)
-							return nil
-						},
-					},
-					{
-						Name:  "list",
-						Usage: "查看白名单",
-						Action: func(cCtx *cli.Context) error {
-							fmt.Println("白名单列表为:", cCtx.Args().First())
-							return nil
-						},
-					},
-					{
-						Name:  "del",
-						Usage: "清空所有白名单",
-						Action: func(cCtx *cli.Context) error {
-							fmt.Println("白名单已清空")
-							suricatarules.DelWhiteList()
-							return nil
-						},
-					},
-				},
-			},
-			{
-				Name:    "backlist",
-				Aliases: []string{"bl"},
-				Usage:   "黑名单",
-				Subcommands: []*cli.Command{
-					{
-						Name:  "add",
-						Usage: "增加黑名单",
-						Action: func(cCtx *cli.Context) error {
-							rule := cCtx.Args().First()
-							fmt.Println("新增黑名单规则:", rule)
-							suricatarules.AddBlackList(rule)
-							return nil
-						},
-					},
-					{
-						Name:  "list",
-						Usage: "查看黑名单",
-						Action: func(cCtx *cli.Context) error {
-							fmt.Println("黑名单列表为:", cCtx.Args().First())
-							return nil
-						},
-					},
-					{
-						Name:  "del",
-						Usage: "清空所有黑名单",
-						Action: func(cCtx *cli.Context) error {
-							fmt.Println("黑名单已清空")
-							suricatarules.DelBlackList()
-							return nil
-						},
-					},
-				},
-			},
-			{
-				Name:    "suricata",
-				Aliases: []string{"sc"},
-				Usage:   "suricata",
-				Subcommands: []*cli.Command{
-					{
-						Name:  "reload",
-						Usage: "规则重载",
-						Action: func(cCtx *cli.Context) error {
-							fmt.Println("suricata 规则重载")
-							suricatarules.ReloadRules()
-							return nil
-						},
-					},
-				},
-			},
-		},
-		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "sregion", Aliases: []string{"sr"}, Usage: "源区域: --sregion eth0,eth1"},
-			&cli.StringFlag{Name: "dregion", Aliases: []string{"dr"}, Usage: "目的区域: --dregion eth0,eth1"},
-			&cli.StringFlag{Name: "sip", Usage: "源IP: --sip 192.168.0.1/24"},
-			&cli.StringFlag{Name: "dip", Usage: "目的IP: --dip 192.168.0.1/24"},
-			&cli.StringFlag{Name: "smac", Usage: "源MAC: --smac 0c:73:eb:92:80:cf"},
-			&cli.StringFlag{Name: "dmac", Usage: "目的MAC: --dmac 0c:73:eb:92:80:cf"},
-			&cli.StringFlag{Name: "protocol", Aliases: []string{"p"}, Usage: "协议: --protocol tcp"},
-			&cli.IntFlag{Name: "sport", Usage: "源端口: --sport 22"},
-			&cli.IntFlag{Name: "dport", Usage: "目的端口: --dport 22"},
-			&cli.StringFlag{Name: "app", Usage: "应用: --app modbus"},
-			&cli.StringSliceFlag{Name: "time", Aliases: []string{"t"}, Usage: "时间:--t hour/day/month@16:00:00-18:00:00"},
-			&cli.StringFlag{Name: "action", Aliases: []string{"a"}, Usage: "动作: --action accept/drop/log/queue"},
-			&cli.StringFlag{Name: "logtag", Aliases: []string{"log"}, Usage: "动作: --logtag log1122"},
-			&cli.StringFlag{Name: "policy", Usage: "动作: --policy init"},
+			whitelistCommand(),
+			blacklistCommand(),
+			banCommand(),
+			ruleCommand(),
+			policyCommand(),
+			bindingCommand(),
+			conntrackCommand(),
+			appCommand(),
+			logCommand(),
+			driftCommand(),
+			serveCommand(),
+			restoreCommand(),
+			storeCommand(),
+			suricataCommand(),
 		},
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{Name: "backend", Value: string(service.BackendNetlink), EnvVars: []string{"FD_BACKEND"}, Usage: "下发方式: --backend netlink/nft/libnft"},
+			&cli.StringFlag{Name: "store", Value: store.DefaultPath, EnvVars: []string{"FD_STORE"}, Usage: "策略文件: --store /var/lib/fd/policys.json"},
+			&cli.StringFlag{Name: "apps", Value: store.DefaultAppPath, EnvVars: []string{"FD_APPS"}, Usage: "自定义应用文件: --apps /var/lib/fd/apps.json"},
+			&cli.StringFlag{Name: "stats", Value: store.DefaultStatsPath, EnvVars: []string{"FD_STATS"}, Usage: "命中统计采样文件: --stats /var/lib/fd/stats.json"},
+			&cli.StringFlag{Name: "events", Value: store.DefaultEventDir, EnvVars: []string{"FD_EVENTS"}, Usage: "告警和安全日志目录: --events /var/lib/fd/events"},
+			&cli.DurationFlag{Name: "event-retention", Value: store.DefaultRetention, Usage: "告警和安全日志的保留时间: --event-retention 720h"},
+		}, policyFlags...),
+		Before: registerApps,
 		Action: func(cCtx *cli.Context) error {
-			// 创建规则
-			var policy model.Policy
-			sRegion := cCtx.String("sregion")
-			if len(sRegion) != 0 {
-				regions := strings.Split(sRegion, ",")
-				policy.SRegion = regions
-			}
-
-			dRegion := cCtx.String("dregion")
-			if len(dRegion) != 0 {
-				regions := strings.Split(dRegion, ",")
-				policy.DRegion = regions
-			}
-
-			sIp := cCtx.String("sip")
-			if len(sIp) != 0 {
-				values := strings.Split(sIp, ",")
-				policy.SIp = values
-			}
-
-			dIp := cCtx.String("dip")
-			if len(dIp) != 0 {
-				values := strings.Split(dIp, ",")
-				policy.DIp = values
-			}
-
-			sMac := cCtx.String("smac")
-			if len(sMac) != 0 {
-				policy.SMac = sMac
-			}
-
-			dMac := cCtx.String("dmac")
-			if len(dMac) != 0 {
-				policy.DMac = dMac
-			}
-
-			protocol := cCtx.String("protocol")
-			if len(protocol) != 0 {
-				policy.Protocol = protocol
-			}
-
-			sport := cCtx.Int("sport")
-			if sport != 0 {
-				policy.SPort = sport
-			}
-
-			dport := cCtx.Int("dport")
-			if sport != 0 {
-				policy.DPort = dport
+			policy, err := parsePolicy(cCtx)
+			if err != nil {
+				return err
 			}
 
-			app := cCtx.String("app")
-			if len(app) != 0 {
-				policy.App = model.App{Name: app}
-			}
+			bs, _ := json.Marshal(policy)
+			var out bytes.Buffer
+			json.Indent(&out, bs, "", "\t")
+			fmt.Printf("policy=%+v\n", out.String())
 
-			// --time day@0-6-9
-			timeArray := cCtx.StringSlice("time")
-			if len(timeArray) != 0 {
-				policy.Time = timeArray
+			backend, err := newRuleBackend(cCtx)
+			if err != nil {
+				return err
 			}
 
-			logTag := cCtx.String("logtag")
-			if len(logTag) != 0 {
-				policy.LogTag = logTag
-			}
+			policyStore := newPolicyStore(cCtx)
 
-			action := cCtx.String("action")
-			if len(action) != 0 {
-				policy.Action = action
-			}
+			// 初始化时只下发与当前规则的差异，不支持差异下发时清空策略链重新下发
+			if policy.Manager == "init" {
+				if err := service.SyncPolicys(backend, []model.Policy{policy}); err != nil {
+					return err
+				}
 
-			policyAction := cCtx.String("policy")
-			if len(policyAction) != 0 {
-				policy.Manager = policyAction
+				policy.Manager = ""
+				_, err = policyStore.Save([]model.Policy{policy})
+				return err
 			}
 
-			bs, _ := json.Marshal(policy)
-			var out bytes.Buffer
-			json.Indent(&out, bs, "", "\t")
-			fmt.Printf("policy=%+v\n", out.String())
-
-			managerService := service.PolicyManagerService{}
-			err := managerService.GeneratePolicyRule(policy)
-
+			err = service.ApplyPolicys(backend, []model.Policy{policy})
 			if err != nil {
 				return err
 			}
 
-			return nil
+			_, err = policyStore.SavePolicy(policy)
+			return err
 		},
 	}
 
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// AuditEvent 策略变更的审计事件，每个修改请求一个事件
+type AuditEvent struct {
+	Time     time.Time // 请求完成的时间
+	Source   string    // 变更来源 api
+	Remote   string    // 请求方地址
+	Method   string    // 请求方法
+	Path     string    // 请求路径
+	PolicyId string    // 按策略ID修改时的策略ID
+	Status   int       // HTTP状态码
+}
@@ -0,0 +1,9 @@
+package model
+
+// BlacklistEntry 黑名单中的地址，匹配时直接丢弃
+type BlacklistEntry struct {
+	Value   string // IPv4/IPv6/MAC地址
+	Type    string // ipv4_addr ipv6_addr ether_addr
+	Timeout int64  // 封禁时长，秒，0表示永久封禁
+	Expires int64  // 剩余封禁时间，秒
+}
@@ -0,0 +1,8 @@
+package model
+
+// ConntrackConfig 策略链最前面的连接跟踪规则，每个表单独设置
+type ConntrackConfig struct {
+	Table       string // 表名
+	Established bool   // 已建立连接和相关连接的报文直接放行，不再匹配策略
+	Invalid     bool   // 无效状态的报文直接阻断
+}
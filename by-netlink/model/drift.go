@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// DriftRule 与策略文件不一致的规则
+type DriftRule struct {
+	Comment  string // 规则注释
+	PolicyId string // 策略ID
+	Version  string // 策略版本
+	Handle   uint64 // 内核中的规则句柄，缺少的规则为0
+}
+
+// DriftReport 内核中的策略链与策略文件的对比结果
+type DriftReport struct {
+	Time         time.Time
+	StoreVersion uint64      // 对比的策略文件版本
+	Missing      []DriftRule // 策略文件中有、策略链中没有的规则
+	Unexpected   []DriftRule // 策略链中有、策略文件中没有的规则
+	Reordered    bool        // 规则一致但顺序不同
+	Changes      []string    // 触发检查的变化通知，启动时和手动检查为空
+	ProcName     string      // 最后一次修改规则集的进程
+	Pid          uint32      // 最后一次修改规则集的线程ID
+	Healed       bool        // 已重新下发策略文件
+}
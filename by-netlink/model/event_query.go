@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// EventQuery 查询告警和安全日志的条件，空字段、0表示不限
+type EventQuery struct {
+	Since    time.Time // 开始时间，包含
+	Until    time.Time // 结束时间，包含
+	PolicyId string    // 策略ID
+	Ip       string    // 源或目的地址，可以是网段 192.168.0.0/24
+	Port     int       // 源或目的端口
+	Action   string    // 动作 allow / warn / drop
+	Limit    int       // 最多返回的事件数，按时间倒序
+}
@@ -0,0 +1,13 @@
+package model
+
+// IpMacBinding IP与MAC绑定，源IP是绑定地址时源MAC必须一致
+type IpMacBinding struct {
+	Ip  string // IPv4/IPv6地址
+	Mac string // 期望的源MAC地址
+}
+
+// IpMacBindingConfig 绑定链的动作和所有绑定
+type IpMacBindingConfig struct {
+	Action   int            // MAC不一致时的动作 1 告警 2 阻断
+	Bindings []IpMacBinding // 绑定，按IP排序
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// LogEvent NFLOG日志事件，由日志前缀和报文头解析得到，一行JSON输出一个事件
+type LogEvent struct {
+	Time     time.Time // 报文时间，内核没有时间戳时使用接收时间
+	Prefix   string    // 原始日志前缀
+	LogTag   string    // 策略的log自定义，去掉告警和日志开关标识
+	Warn     bool      // 告警，记录到告警表中
+	Log      bool      // 日志开关，存储到系统安全日志
+	Source   string    // 事件来源 policy / blacklist / binding / drift，无法识别时为空
+	PolicyId string    // 策略ID，根据日志前缀在策略链中查找
+	Version  string    // 策略版本
+	Action   string    // 动作 allow / warn / drop
+	IIfName  string    // 入接口
+	OIfName  string    // 出接口
+	SMac     string    // 源mac地址
+	DMac     string    // 目的mac地址
+	SIp      string    // 源IP
+	DIp      string    // 目的IP
+	Protocol string    // 协议 tcp udp icmp icmpv6 或者协议号
+	SPort    int       // 源端口，没有端口的协议为0
+	DPort    int       // 目的端口
+	Length   int       // 报文长度
+}
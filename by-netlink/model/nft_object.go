@@ -0,0 +1,25 @@
+package model
+
+type TableInfo struct {
+	Family string // 地址族 ip ip6 inet arp bridge netdev
+	Name   string // 表名
+}
+
+type ChainInfo struct {
+	Family   string // 地址族
+	Table    string // 表名
+	Name     string // 链名
+	Type     string // 类型 filter nat route，为空时是普通链
+	Hook     string // 挂载点 prerouting input forward output postrouting ingress
+	Priority int    // 优先级
+	Policy   string // 默认动作 accept drop
+}
+
+type SetInfo struct {
+	Family   string   // 地址族
+	Table    string   // 表名
+	Name     string   // 集合名
+	KeyType  string   // 元素类型 ipv4_addr ipv6_addr ether_addr inet_service ifname
+	Interval bool     // 是否是区间集合
+	Elements []string // 元素
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// Packet 模拟匹配的报文，空字段、0端口表示报文没有该字段
+type Packet struct {
+	IIfName     string    // 入接口
+	OIfName     string    // 出接口
+	SMac        string    // 源mac地址
+	DMac        string    // 目的mac地址
+	SIp         string    // 源IP
+	DIp         string    // 目的IP
+	Protocol    string    // 协议 tcp udp icmp icmpv6 或者协议号
+	IcmpType    string    // ICMP/ICMPv6类型 echo-request / 8
+	IcmpCode    string    // ICMP代码
+	TcpFlags    string    // 报文中置位的TCP标志 syn,ack
+	SPort       int       // 源端口
+	DPort       int       // 目的端口
+	CtState     string    // 连接状态 new established related invalid untracked
+	CtDirection string    // 连接方向 original reply
+	CtMark      string    // 连接标记
+	Time        time.Time // 报文时间，为空时使用当前时间
+}
+
+// SimulateResult 报文匹配策略的结果
+type SimulateResult struct {
+	Matched   bool   // 是否命中策略，没有命中时按链的默认动作丢弃
+	Index     int    // 命中的策略序号，没有命中为-1
+	Policy    Policy // 命中的策略
+	Verdict   string // 规则动作 drop queue accept
+	LogPrefix string // 日志前缀，为空表示不记录日志
+	Limited   []int  // 之前命中但带有限速、连接数限制的策略序号，超过限制时由这些策略处理
+	Conntrack string // 命中的连接跟踪规则注释，命中时不再匹配策略
+}
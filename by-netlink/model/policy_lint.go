@@ -0,0 +1,20 @@
+package model
+
+// 策略检查问题类型
+const (
+	LintShadowed   = "shadowed"   // 被前面动作不同的策略完全覆盖，永远不会命中
+	LintRedundant  = "redundant"  // 与覆盖它的策略动作相同，可以删除
+	LintOverlap    = "overlap"    // 被后面动作不同的策略完全覆盖，是后面策略的例外
+	LintCorrelated = "correlated" // 与其他策略部分重叠且动作不同，顺序决定结果
+	LintInvalid    = "invalid"    // 策略内容错误，无法生成规则
+)
+
+// LintIssue 策略检查结果，序号是策略在链中的顺序
+type LintIssue struct {
+	Type      string // 问题类型
+	Index     int    // 有问题的策略序号
+	Id        string // 有问题的策略ID
+	Related   int    // 相关策略序号，没有为-1
+	RelatedId string // 相关策略ID
+	Message   string // 说明
+}
@@ -1,30 +1,44 @@
 package model
 
 type Policy struct {
-	Name      string       // 策略名称
-	SRegion   []string     // 源区域
-	DRegion   []string     // 目的区域
-	SIp       []string     // 源IP
-	DIp       []string     // 目的ip
-	SMac      string       // 源mac地址
-	DMac      string       // 目的mac地址
-	Protocol  string       // 协议类型 TCP UDP ICMP
-	SPort     int          // 源端口
-	DPort     int          // 目的端口
-	App       App          // 应用
-	Action    int          // 动作 0 允许 1 告警 2 阻断
-	LogTag    string       // log自定义
-	Manager   string       // 策略管理
-	Time      []PolicyTime // 时间
-	TableName string       // 表明
-	ChainName string       // 链名
-	LogSwitch int          // 0 关 1 开
+	Id          string       // 策略ID，写入规则注释，用于查找、更新、删除策略
+	Name        string       // 策略名称
+	SRegion     []string     // 源区域
+	DRegion     []string     // 目的区域
+	SIp         []string     // 源IP IPv4/IPv6 地址、范围、网段
+	DIp         []string     // 目的ip IPv4/IPv6 地址、范围、网段
+	Family      string       // 地址族 ipv4 / ipv6，为空时不限制，按地址计算且没有地址的策略拆分后设置
+	SMac        string       // 源mac地址
+	DMac        string       // 目的mac地址
+	Protocol    string       // 协议 tcp / tcp,udp / 47，名称与/etc/protocols一致
+	IcmpType    string       // ICMP/ICMPv6类型 echo-request / 8，协议必须是icmp或者icmpv6
+	IcmpCode    string       // ICMP代码 0-255，必须同时设置类型
+	TcpFlags    string       // TCP标志 syn / syn,!ack，协议必须是tcp
+	SPort       Ports        // 源端口 22 / 1024-65535，多个端口取并集
+	DPort       Ports        // 目的端口 22 / 1024-65535，多个端口取并集
+	App         App          // 应用，展开为协议和目的端口
+	Action      int          // 动作 0 允许 1 告警 2 阻断
+	Limit       string       // 限速 100/second / 10 mbytes/second，只有超过限速的报文执行动作
+	LimitBurst  int          // 突发的报文数或者字节数，0使用默认值
+	LimitPer    string       // 按地址限速、限制连接数 saddr / daddr，为空时整条策略共用
+	ConnLimit   int          // 最大并发连接数，超过后的连接执行动作
+	CtState     string       // 连接状态 new / established,related / invalid / untracked
+	CtDirection string       // 连接方向 original / reply
+	CtMark      string       // 连接标记 0x10 / 16
+	LogTag      string       // log自定义
+	Manager     string       // 策略管理
+	Time        []PolicyTime // 时间
+	TableName   string       // 表明
+	ChainName   string       // 链名
+	LogSwitch   int          // 0 关 1 开
 }
 
 type App struct {
 	Predefine bool   // 是否是预定义的
-	Port      int    // 端口号
+	Port      int    // 端口号，自定义应用使用
 	Name      string // 协议名
+	Protocol  string // 传输层协议 tcp / udp / tcp,udp，自定义应用使用，默认tcp
+	Inspect   bool   // 允许时是否交给suricata深度检测，自定义应用使用
 }
 
 type PolicyTime struct {
@@ -33,3 +47,27 @@ type PolicyTime struct {
 	Week  string // 周
 	Month string // 月份
 }
+
+type RuleInfo struct {
+	Handle   uint64 // 规则句柄
+	Expr     string // 规则内容
+	Comment  string // 规则注释
+	PolicyId string // 所属策略ID
+	Version  string // 策略版本
+}
+
+// ReconcileResult 策略同步的结果统计
+type ReconcileResult struct {
+	Added    int // 新增规则数
+	Deleted  int // 删除规则数
+	Replaced int // 原位替换规则数
+	Kept     int // 未变化的规则数
+}
+
+// DecodedPolicy 由内核规则还原的策略
+type DecodedPolicy struct {
+	Handles []uint64 // 对应的规则句柄，按地址族拆分的策略有多条规则
+	Version string   // 策略版本
+	Policy  Policy   // 还原的策略
+	Unknown []string // 无法还原的表达式
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// PolicyStats 策略的命中统计，按地址族拆分的规则共用一个计数器
+type PolicyStats struct {
+	PolicyId string    // 策略ID
+	Version  string    // 策略版本
+	Counter  string    // 计数器名称，为空表示规则没有计数器
+	Packets  uint64    // 命中的报文数
+	Bytes    uint64    // 命中的字节数
+	LastHit  time.Time // 最近一次采样发现计数增加的时间，为零表示还没有采样到命中
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// Ports 端口列表，元素是单个端口或者端口范围 22 / 1024-65535
+type Ports []string
+
+// ParsePorts 解析逗号分隔的端口 80,443,8000-8100
+func ParsePorts(value string) Ports {
+	var ports Ports
+	for _, port := range strings.Split(value, ",") {
+		port = strings.TrimSpace(port)
+		if len(port) != 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// UnmarshalJSON 兼容旧策略文件中的整数端口，0表示不限端口，也支持逗号分隔的字符串
+func (p *Ports) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*p = nil
+		return nil
+
+	case len(data) != 0 && data[0] == '"':
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*p = ParsePorts(value)
+		return nil
+
+	case len(data) != 0 && data[0] == '[':
+		var values []json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return err
+		}
+		var ports Ports
+		for _, value := range values {
+			var port Ports
+			if err := port.UnmarshalJSON(value); err != nil {
+				return err
+			}
+			ports = append(ports, port...)
+		}
+		*p = ports
+		return nil
+	}
+
+	port, err := strconv.Atoi(string(data))
+	if err != nil {
+		return strerror.CreateError("port error:" + string(data))
+	}
+	*p = nil
+	if port != 0 {
+		*p = Ports{strconv.Itoa(port)}
+	}
+	return nil
+}
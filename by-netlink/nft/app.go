@@ -0,0 +1,234 @@
+package nft
+
+import (
+	"sort"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// AppSignature 应用对应的协议和端口
+type AppSignature struct {
+	Name      string      // 应用名称
+	Protocol  string      // 传输层协议 tcp / udp / tcp,udp
+	Ports     model.Ports // 服务端口，匹配目的端口
+	Inspect   bool        // 允许时交给suricata深度检测，suricata能解析的协议才需要
+	Predefine bool        // 是否是预定义的
+}
+
+// predefinedApps 预定义的工控应用，使用标准端口
+var predefinedApps = map[string]AppSignature{
+	"modbus":      {Protocol: "tcp", Ports: model.Ports{"502"}, Inspect: true},
+	"s7comm":      {Protocol: "tcp", Ports: model.Ports{"102"}},
+	"dnp3":        {Protocol: "tcp", Ports: model.Ports{"20000"}, Inspect: true},
+	"iec-104":     {Protocol: "tcp", Ports: model.Ports{"2404"}},
+	"opcua":       {Protocol: "tcp", Ports: model.Ports{"4840"}},
+	"ethernet-ip": {Protocol: "tcp,udp", Ports: model.Ports{"44818"}, Inspect: true},
+	"bacnet":      {Protocol: "udp", Ports: model.Ports{"47808"}},
+	"profinet":    {Protocol: "udp", Ports: model.Ports{"34962-34964"}},
+	"fins":        {Protocol: "tcp,udp", Ports: model.Ports{"9600"}},
+	"hart-ip":     {Protocol: "tcp,udp", Ports: model.Ports{"5094"}},
+	"ge-srtp":     {Protocol: "tcp", Ports: model.Ports{"18245"}},
+	"codesys":     {Protocol: "tcp", Ports: model.Ports{"2455"}},
+	"mqtt":        {Protocol: "tcp", Ports: model.Ports{"1883"}, Inspect: true},
+}
+
+// customApps 自定义应用，由RegisterApps设置
+var customApps = map[string]AppSignature{}
+
+func init() {
+	for name, signature := range predefinedApps {
+		signature.Name = name
+		signature.Predefine = true
+		predefinedApps[name] = signature
+	}
+}
+
+// hasApp 策略是否设置了应用
+func hasApp(app model.App) bool {
+	return len(app.Name) != 0 || app.Port != 0
+}
+
+// IsPredefinedApp 是否是预定义的应用
+func IsPredefinedApp(name string) bool {
+	_, ok := predefinedApps[strings.ToLower(strings.TrimSpace(name))]
+	return ok
+}
+
+// getCustomSignature 校验自定义应用，协议默认tcp
+func getCustomSignature(app model.App) (AppSignature, error) {
+	if app.Port <= 0 || app.Port > 65535 {
+		return AppSignature{}, strerror.CreateError("app port error:" + app.Name)
+	}
+
+	protocol := app.Protocol
+	if len(strings.TrimSpace(protocol)) == 0 {
+		protocol = "tcp"
+	}
+	protocols, err := ParseProtocols(protocol)
+	if err != nil {
+		return AppSignature{}, err
+	}
+	if len(protocols) == 0 {
+		return AppSignature{}, strerror.CreateError("app protocol error:" + app.Name)
+	}
+
+	return AppSignature{
+		Name:     strings.ToLower(strings.TrimSpace(app.Name)),
+		Protocol: protocol,
+		Ports:    model.Ports{PortRange{Start: uint16(app.Port), End: uint16(app.Port)}.String()},
+		Inspect:  app.Inspect,
+	}, nil
+}
+
+// RegisterApps 设置自定义应用，替换之前的自定义应用，名称不能与预定义应用重复
+func RegisterApps(apps []model.App) error {
+	registered := make(map[string]AppSignature)
+	for _, app := range apps {
+		signature, err := getCustomSignature(app)
+		if err != nil {
+			return err
+		}
+		if len(signature.Name) == 0 {
+			return strerror.CreateError("app name is required")
+		}
+		if IsPredefinedApp(signature.Name) {
+			return strerror.CreateError("app name conflicts with predefined app:" + signature.Name)
+		}
+		if _, ok := registered[signature.Name]; ok {
+			return strerror.CreateError("app name duplicated:" + signature.Name)
+		}
+		registered[signature.Name] = signature
+	}
+
+	customApps = registered
+	return nil
+}
+
+// ListApps 预定义应用和自定义应用，按名称排序
+func ListApps() []AppSignature {
+	var signatures []AppSignature
+	for _, signature := range predefinedApps {
+		signatures = append(signatures, signature)
+	}
+	for _, signature := range customApps {
+		signatures = append(signatures, signature)
+	}
+	sort.Slice(signatures, func(i, j int) bool { return signatures[i].Name < signatures[j].Name })
+	return signatures
+}
+
+// ResolveApp 查找应用的协议和端口，依次查找预定义应用、自定义应用
+// 名称都不存在时按策略中的端口和协议作为自定义应用
+func ResolveApp(app model.App) (AppSignature, error) {
+	name := strings.ToLower(strings.TrimSpace(app.Name))
+	if signature, ok := predefinedApps[name]; ok {
+		return signature, nil
+	}
+	if signature, ok := customApps[name]; ok {
+		return signature, nil
+	}
+	if app.Port != 0 {
+		return getCustomSignature(app)
+	}
+	return AppSignature{}, strerror.CreateError("app error:" + app.Name)
+}
+
+// ExpandApp 应用展开为协议和目的端口，策略中已经设置的协议、目的端口优先，用于非标准端口
+func ExpandApp(policy model.Policy) (model.Policy, error) {
+	if !hasApp(policy.App) {
+		return policy, nil
+	}
+
+	signature, err := ResolveApp(policy.App)
+	if err != nil {
+		return policy, err
+	}
+	if len(policy.Protocol) == 0 {
+		policy.Protocol = signature.Protocol
+	}
+	if len(policy.DPort) == 0 {
+		policy.DPort = signature.Ports
+	}
+	return policy, nil
+}
+
+// SplitPolicy 展开应用后按地址族拆分策略，各种下发方式生成规则前调用
+// 按地址计算且没有地址的策略拆分成IPv4和IPv6两条规则
+func SplitPolicy(policy model.Policy) ([]model.Policy, error) {
+	policy, err := ExpandApp(policy)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckFamily(policy); err != nil {
+		return nil, err
+	}
+	if len(policy.SIp) == 0 && len(policy.DIp) == 0 {
+		return splitMeterFamily(policy), nil
+	}
+	return splitIpFamily(policy)
+}
+
+// PolicyRuleAction 策略的规则动作，允许的应用不需要深度检测时直接放行，其他与GetRuleAction一致
+func PolicyRuleAction(policy model.Policy) RuleAction {
+	if policy.Action == ALLOW && hasApp(policy.App) {
+		if signature, err := ResolveApp(policy.App); err == nil && !signature.Inspect {
+			return ActionAccept
+		}
+	}
+	return GetRuleAction(policy.Action)
+}
+
+// FindApp 由规则还原的协议、目的端口和规则动作查找对应的应用
+func FindApp(policy model.Policy, verdict RuleAction) (model.App, bool) {
+	if len(policy.Protocol) == 0 || len(policy.DPort) == 0 {
+		return model.App{}, false
+	}
+	protocols, err := ParseProtocols(policy.Protocol)
+	if err != nil {
+		return model.App{}, false
+	}
+	ports, err := GetPortRanges(policy.DPort)
+	if err != nil {
+		return model.App{}, false
+	}
+
+	for _, signature := range ListApps() {
+		appProtocols, _ := ParseProtocols(signature.Protocol)
+		appPorts, _ := GetPortRanges(signature.Ports)
+		if !equalProtocols(protocols, appProtocols) || !equalPortRanges(ports, appPorts) {
+			continue
+		}
+
+		policy.App = model.App{Name: signature.Name, Predefine: signature.Predefine}
+		if PolicyRuleAction(policy) == verdict {
+			return policy.App, true
+		}
+	}
+	return model.App{}, false
+}
+
+func equalProtocols(a []uint8, b []uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPortRanges(a []PortRange, b []PortRange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
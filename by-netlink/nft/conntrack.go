@@ -0,0 +1,235 @@
+package nft
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 连接跟踪规则放在策略链最前面，注释带有该前缀，清空、同步策略链时保留
+const conntrackCommentPrefix = "fd-ct:"
+
+// 连接跟踪规则的注释
+const (
+	CtEstablishedComment = conntrackCommentPrefix + "established" // ct state established,related accept
+	CtInvalidComment     = conntrackCommentPrefix + "invalid"     // ct state invalid drop
+)
+
+// 连接状态位，与内核 NF_CT_STATE_* 一致
+const (
+	CtStateInvalid     uint32 = 1
+	CtStateEstablished uint32 = 2
+	CtStateRelated     uint32 = 4
+	CtStateNew         uint32 = 8
+	CtStateUntracked   uint32 = 64
+)
+
+var ctStates = []struct {
+	name string
+	bit  uint32
+}{
+	{"invalid", CtStateInvalid},
+	{"established", CtStateEstablished},
+	{"related", CtStateRelated},
+	{"new", CtStateNew},
+	{"untracked", CtStateUntracked},
+}
+
+// 连接方向
+const (
+	CtDirectionOriginal = "original" // 发起连接的方向
+	CtDirectionReply    = "reply"    // 应答方向
+)
+
+// IsConntrackComment 是否是连接跟踪规则
+func IsConntrackComment(comment string) bool {
+	return strings.HasPrefix(comment, conntrackCommentPrefix)
+}
+
+// ConntrackComments 按配置生成连接跟踪规则的注释，顺序与规则在链中的顺序一致
+func ConntrackComments(config model.ConntrackConfig) []string {
+	var comments []string
+	if config.Established {
+		comments = append(comments, CtEstablishedComment)
+	}
+	if config.Invalid {
+		comments = append(comments, CtInvalidComment)
+	}
+	return comments
+}
+
+// GetConntrackConfig 根据链中规则的注释判断启用了哪些连接跟踪规则
+func GetConntrackConfig(comments []string) model.ConntrackConfig {
+	var config model.ConntrackConfig
+	for _, comment := range comments {
+		switch comment {
+		case CtEstablishedComment:
+			config.Established = true
+		case CtInvalidComment:
+			config.Invalid = true
+		}
+	}
+	return config
+}
+
+// GetConntrackComments 已下发规则中的连接跟踪规则注释
+func GetConntrackComments(rules []model.RuleInfo) []string {
+	var comments []string
+	for _, rule := range rules {
+		if IsConntrackComment(rule.Comment) {
+			comments = append(comments, rule.Comment)
+		}
+	}
+	return comments
+}
+
+// ConntrackRuleState 连接跟踪规则匹配的连接状态和动作
+func ConntrackRuleState(comment string) (state uint32, action RuleAction) {
+	switch comment {
+	case CtEstablishedComment:
+		return CtStateEstablished | CtStateRelated, ActionAccept
+	case CtInvalidComment:
+		return CtStateInvalid, ActionDrop
+	}
+	return 0, ""
+}
+
+// ParseCtState 解析连接状态 new / established,related
+func ParseCtState(value string) (uint32, error) {
+	var bits uint32
+	for _, name := range strings.Split(value, comma) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for _, state := range ctStates {
+			if state.name == name {
+				bits |= state.bit
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, strerror.CreateError("ct state error:" + value)
+		}
+	}
+	return bits, nil
+}
+
+// CtStateNames 连接状态位对应的名称
+func CtStateNames(bits uint32) []string {
+	var names []string
+	for _, state := range ctStates {
+		if bits&state.bit != 0 {
+			names = append(names, state.name)
+		}
+	}
+	return names
+}
+
+// FormatCtState 与ParseCtState相反
+func FormatCtState(bits uint32) string {
+	return strings.Join(CtStateNames(bits), comma)
+}
+
+// ParseCtMark 解析连接标记 0x10 / 16
+func ParseCtMark(value string) (uint32, error) {
+	mark, err := strconv.ParseUint(strings.TrimSpace(value), 0, 32)
+	if err != nil {
+		return 0, strerror.CreateError("ct mark error:" + value)
+	}
+	return uint32(mark), nil
+}
+
+// FormatCtMark 连接标记使用十六进制，与nft命令输出一致
+func FormatCtMark(mark uint32) string {
+	return fmt.Sprintf("0x%08x", mark)
+}
+
+// CtMatch 策略中的连接跟踪条件
+type CtMatch struct {
+	State     uint32 // 连接状态位，0表示不匹配状态
+	Direction string // 连接方向 original / reply，为空时不匹配方向
+	Mark      uint32 // 连接标记
+	HasMark   bool   // 是否匹配连接标记
+}
+
+// GetCtMatch 校验并解析策略中的连接状态、方向和标记
+func GetCtMatch(policy model.Policy) (CtMatch, error) {
+	var match CtMatch
+	var err error
+
+	if len(policy.CtState) != 0 {
+		match.State, err = ParseCtState(policy.CtState)
+		if err != nil {
+			return match, err
+		}
+	}
+
+	switch policy.CtDirection {
+	case "", CtDirectionOriginal, CtDirectionReply:
+		match.Direction = policy.CtDirection
+	default:
+		return match, strerror.CreateError("ct direction error:" + policy.CtDirection)
+	}
+
+	if len(policy.CtMark) != 0 {
+		match.Mark, err = ParseCtMark(policy.CtMark)
+		if err != nil {
+			return match, err
+		}
+		match.HasMark = true
+	}
+	return match, nil
+}
+
+// HasCt 策略是否匹配连接跟踪信息
+func HasCt(policy model.Policy) bool {
+	return len(policy.CtState) != 0 || len(policy.CtDirection) != 0 || len(policy.CtMark) != 0
+}
+
+// AddCtExpr 生成连接跟踪表达式 ct state established,related ct direction reply ct mark 0x00000010
+func AddCtExpr(policy model.Policy) (string, error) {
+	match, err := GetCtMatch(policy)
+	if err != nil {
+		return "", err
+	}
+
+	var exprs string
+	if match.State != 0 {
+		exprs += "ct state " + FormatCtState(match.State) + gap
+	}
+	if len(match.Direction) != 0 {
+		exprs += "ct direction " + match.Direction + gap
+	}
+	if match.HasMark {
+		exprs += "ct mark " + FormatCtMark(match.Mark) + gap
+	}
+	return exprs, nil
+}
+
+// ConntrackRuleExpr 连接跟踪规则的表达式，带有注释
+func ConntrackRuleExpr(comment string) string {
+	state, action := ConntrackRuleState(comment)
+	if state == 0 {
+		return ""
+	}
+	return "ct state " + FormatCtState(state) + gap + string(action) + gap +
+		string(MetaComment) + gap + mark_str + comment + mark_str
+}
+
+// AddConntrackRules 在链的末尾添加连接跟踪规则，清空链之后调用，规则位于所有策略规则前面
+func (c *Nft) AddConntrackRules(comments []string) error {
+	for _, comment := range comments {
+		ruleExpr := ConntrackRuleExpr(comment)
+		if len(ruleExpr) == 0 {
+			continue
+		}
+		command := fmt.Sprintf(string(AddRule), c.Table.AddressFamily, c.Table.Name, c.Chain.Name, ruleExpr)
+		if err := c.Exec(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 package nft
 
 import (
+	"bytes"
 	"fmt"
-	"log"
 	"netvine.com/firewall/server/model"
+	iptools "netvine.com/firewall/server/utils"
 	strerror "netvine.com/firewall/server/utils/error"
 	"os/exec"
 	"strconv"
@@ -21,10 +22,17 @@ const (
 type NFTCommand string
 
 const (
-	AddTable     NFTCommand = "nft add table %s %s"
-	AddChain     NFTCommand = "nft add chain %s %s %s {type %s hook %s priority filter\\; policy %s\\; }" // nft add chain ip {tableName} {chainName}
-	FlushRuleSet NFTCommand = "nft flush ruleset"
-	AddRule      NFTCommand = "nft add rule %s %s %s %s"
+	AddTable      NFTCommand = "nft add table %s %s"
+	AddChain      NFTCommand = "nft add chain %s %s %s {type %s hook %s priority filter\\; policy %s\\; }" // nft add chain ip {tableName} {chainName}
+	FlushRuleSet  NFTCommand = "nft flush ruleset"
+	AddRule       NFTCommand = "nft add rule %s %s %s %s"
+	ListChain     NFTCommand = "nft -a list chain %s %s %s"
+	ListChains    NFTCommand = "nft list chains %s"
+	DeleteRule    NFTCommand = "nft delete rule %s %s %s handle %d"
+	FlushChain    NFTCommand = "nft flush chain %s %s %s"
+	AddCounter    NFTCommand = "nft add counter %s %s %s" // 命名计数器，已存在时不报错
+	DeleteCounter NFTCommand = "nft delete counter %s %s %s"
+	DeleteSet     NFTCommand = "nft delete set %s %s %s"
 )
 
 type ChainType string
@@ -62,6 +70,9 @@ const (
 	DROP  int = 2
 )
 
+// LogGroup 日志发送到的NFLOG组
+const LogGroup = 1
+
 // Rule Action
 const (
 	ActionAccept RuleAction = "accept"
@@ -81,6 +92,9 @@ const (
 	FamilyNETDEV AddressFamily = "netdev"
 )
 
+// NftFamily 策略表使用inet，同时处理IPv4和IPv6
+const NftFamily = FamilyINET
+
 type Table struct {
 	Name          string
 	AddressFamily AddressFamily
@@ -101,28 +115,42 @@ const (
 	gap         = " "
 	comma       = ","
 	value_range = "-"
+	handleMark  = "# handle "
 )
 
 const (
 	MetaIIfName    MetaType = "iifname"      // 入接口
+	MetaNfproto    MetaType = "meta nfproto" // 地址族
 	MetaOfName     MetaType = "oifname"      // 出接口
 	MetaIPSAddr    MetaType = "ip saddr"     // 源IP
 	MetaIPDAddr    MetaType = "ip daddr"     // 目的IP
+	MetaIP6SAddr   MetaType = "ip6 saddr"    // 源IPv6
+	MetaIP6DAddr   MetaType = "ip6 daddr"    // 目的IPv6
 	MetaEtherSAddr MetaType = "ether saddr"  // 源MAC
 	MetaEtherDAddr MetaType = "ether daddr"  // 目的MAC
 	MetaIPProtocol MetaType = "meta l4proto" // 协议
+	MetaIcmpType   MetaType = "icmp type"    // ICMP类型
+	MetaIcmpCode   MetaType = "icmp code"    // ICMP代码
+	MetaIcmp6Type  MetaType = "icmpv6 type"  // ICMPv6类型
+	MetaIcmp6Code  MetaType = "icmpv6 code"  // ICMPv6代码
+	MetaTcpFlags   MetaType = "tcp flags"    // TCP标志
 	MetaIpSPort    MetaType = "th sport"     // 源端口
 	MetaIpDPort    MetaType = "th dport"     // 目的端口
 	MetaTimeHour   MetaType = "meta hour"    // 小时 meta hour "09:00:00"-"10:00:00"
 	MetaTimeDay    MetaType = "meta day"     // 星期 meta day [0-6]
 	MetaTimeStamp  MetaType = "meta time"    //meta time "2022-06-06 00:00:00"-"2022-06-06 23:00:00"
 	MetaLogPrefix  MetaType = "log prefix"
+	MetaLogGroup   MetaType = "group"
+	MetaComment    MetaType = "comment"
 	MetaEmpty      MetaType = ""
 )
 
 type Nft struct {
 	Table Table
 	Chain Chain
+
+	batch    []string // 事务中的命令，Begin之后不为nil
+	snapshot string   // 事务开始前策略表的内容
 }
 
 func (c *Nft) AddTable(table Table) error {
@@ -150,7 +178,24 @@ func (c *Nft) AddChain(chain Chain) error {
 	return err
 }
 
+// AddRule 展开应用，同时包含IPv4和IPv6地址的策略按地址族拆分成多条规则
 func (c *Nft) AddRule(policy model.Policy) error {
+	policys, err := SplitPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	comment := RuleComment(policy)
+	for _, familyPolicy := range policys {
+		err := c.addFamilyRule(familyPolicy, comment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Nft) addFamilyRule(policy model.Policy, comment string) error {
 	var exprs string
 	var err error
 	// 出入接口
@@ -171,9 +216,18 @@ func (c *Nft) AddRule(policy model.Policy) error {
 		exprs += expr
 	}
 
+	// 地址族，没有地址的策略按地址族拆分时设置
+	if len(policy.Family) != 0 {
+		expr, err := AddSingleExpr(MetaNfproto, policy.Family)
+		if err != nil {
+			return err
+		}
+		exprs += expr
+	}
+
 	// 源IP
 	if len(policy.SIp) != 0 {
-		expr, err := AddExpr(MetaIPSAddr, policy.SIp)
+		expr, err := AddExpr(ipMetaType(policy.SIp, MetaIPSAddr, MetaIP6SAddr), policy.SIp)
 		if err != nil {
 			return err
 		}
@@ -182,7 +236,7 @@ func (c *Nft) AddRule(policy model.Policy) error {
 
 	// 目的IP
 	if len(policy.DIp) != 0 {
-		expr, err := AddExpr(MetaIPDAddr, policy.DIp)
+		expr, err := AddExpr(ipMetaType(policy.DIp, MetaIPDAddr, MetaIP6DAddr), policy.DIp)
 		if err != nil {
 			return err
 		}
@@ -191,7 +245,7 @@ func (c *Nft) AddRule(policy model.Policy) error {
 
 	// 协议
 	if len(policy.Protocol) != 0 {
-		expr, err := AddSingleExpr(MetaIPProtocol, policy.Protocol)
+		expr, err := AddProtocolExpr(policy)
 		if err != nil {
 			return err
 		}
@@ -217,8 +271,8 @@ func (c *Nft) AddRule(policy model.Policy) error {
 	}
 
 	// source prot
-	if policy.SPort != 0 {
-		expr, err := AddSingleExpr(MetaIpSPort, strconv.FormatInt(int64(policy.SPort), 10))
+	if len(policy.SPort) != 0 {
+		expr, err := AddPortExpr(MetaIpSPort, policy.SPort)
 		if err != nil {
 			return err
 		}
@@ -226,8 +280,8 @@ func (c *Nft) AddRule(policy model.Policy) error {
 	}
 
 	//dest port
-	if policy.DPort != 0 {
-		expr, err := AddSingleExpr(MetaIpDPort, strconv.FormatInt(int64(policy.DPort), 10))
+	if len(policy.DPort) != 0 {
+		expr, err := AddPortExpr(MetaIpDPort, policy.DPort)
 		if err != nil {
 			return err
 		}
@@ -243,18 +297,46 @@ func (c *Nft) AddRule(policy model.Policy) error {
 		exprs += expr
 	}
 
-	// 日志
-	// 特征值^#W@L   warn字段表示告警，也就是命中后告警
-	if len(policy.LogTag) != 0 {
-		if policy.Action == WARN { // 告警动作，命中规则需要告警，记录到告警表中
-			policy.LogTag += "#W"
+	// 连接状态、方向和标记
+	if HasCt(policy) {
+		expr, err := AddCtExpr(policy)
+		if err != nil {
+			return err
 		}
+		exprs += expr
+	}
 
-		if policy.LogSwitch == 1 { // 日志开关，命中规则需要展示详细信息，存储到系统安全日志
-			policy.LogTag += "@L"
+	// 限速、连接数限制，按地址计算时先创建动态集合
+	if HasLimit(policy) {
+		expr, addSet, err := AddLimitExpr(c.Table, policy)
+		if err != nil {
+			return err
+		}
+		if len(addSet) != 0 {
+			if err := c.Exec(addSet); err != nil {
+				return err
+			}
+		}
+		exprs += expr
+	}
+
+	// 命中计数，先创建策略的命名计数器
+	if expr, addCounter := AddCounterExpr(c.Table, comment); len(expr) != 0 {
+		if err := c.Exec(addCounter); err != nil {
+			return err
+		}
+		exprs += expr
+	}
+
+	// 日志
+	if len(policy.LogTag) != 0 {
+		expr, err := AddSingleExpr(MetaLogPrefix, LogPrefix(policy))
+		if err != nil {
+			return err
 		}
+		exprs += expr
 
-		expr, err := AddSingleExpr(MetaLogPrefix, policy.LogTag)
+		expr, err = AddSingleExpr(MetaLogGroup, strconv.Itoa(LogGroup))
 		if err != nil {
 			return err
 		}
@@ -262,17 +344,14 @@ func (c *Nft) AddRule(policy model.Policy) error {
 	}
 
 	// 动作
-	var action RuleAction
-	switch policy.Action {
-	case ALLOW:
-		fallthrough
-	case WARN:
-		action = ActionQueue
-	case DROP:
-		action = ActionDrop
+	expr, err := AddSingleExpr(MetaEmpty, string(PolicyRuleAction(policy)))
+	if err != nil {
+		return err
 	}
+	exprs += expr
 
-	expr, err := AddSingleExpr(MetaEmpty, string(action))
+	// 注释，标识规则对应的策略
+	expr, err = AddSingleExpr(MetaComment, mark_str+comment+mark_str)
 	if err != nil {
 		return err
 	}
@@ -284,6 +363,87 @@ func (c *Nft) AddRule(policy model.Policy) error {
 	return err
 }
 
+// ipMetaType 按地址族选择 ip saddr 或者 ip6 saddr
+func ipMetaType(values []string, ipv4 MetaType, ipv6 MetaType) MetaType {
+	if isIPv6, _ := iptools.IsIPv6(values[0]); isIPv6 {
+		return ipv6
+	}
+	return ipv4
+}
+
+// LogPrefix 日志前缀
+// 特征值^#W@L   warn字段表示告警，也就是命中后告警
+func LogPrefix(policy model.Policy) string {
+	logTag := policy.LogTag
+	if len(logTag) == 0 {
+		return ""
+	}
+
+	if policy.Action == WARN { // 告警动作，命中规则需要告警，记录到告警表中
+		logTag += "#W"
+	}
+
+	if policy.LogSwitch == 1 { // 日志开关，命中规则需要展示详细信息，存储到系统安全日志
+		logTag += "@L"
+	}
+	return logTag
+}
+
+// ParseLogPrefix 从日志前缀中解析log自定义、告警和日志开关，与LogPrefix相反
+func ParseLogPrefix(prefix string) (logTag string, warn bool, logSwitch bool) {
+	logTag = prefix
+	if strings.HasSuffix(logTag, "@L") {
+		logSwitch = true
+		logTag = strings.TrimSuffix(logTag, "@L")
+	}
+	if strings.HasSuffix(logTag, "#W") {
+		warn = true
+		logTag = strings.TrimSuffix(logTag, "#W")
+	}
+	return logTag, warn, logSwitch
+}
+
+// GetRuleAction 策略动作转换为规则动作，允许和告警都交给suricata队列
+func GetRuleAction(action int) RuleAction {
+	switch action {
+	case DROP:
+		return ActionDrop
+	default:
+		return ActionQueue
+	}
+}
+
+// ParseAction 解析命令行动作 accept/log/drop/queue 或者 0/1/2
+func ParseAction(action string) (int, error) {
+	switch strings.ToLower(action) {
+	case "accept", "allow", "queue":
+		return ALLOW, nil
+	case "log", "warn":
+		return WARN, nil
+	case "drop":
+		return DROP, nil
+	}
+
+	value, err := strconv.Atoi(action)
+	if err != nil || value < ALLOW || value > DROP {
+		return 0, strerror.CreateError("action error:" + action)
+	}
+	return value, nil
+}
+
+// ActionName 策略动作的名称，与ParseAction相反
+func ActionName(action int) string {
+	switch action {
+	case ALLOW:
+		return "allow"
+	case WARN:
+		return "warn"
+	case DROP:
+		return "drop"
+	}
+	return strconv.Itoa(action)
+}
+
 func AddSingleExpr(metaType MetaType, value string) (string, error) {
 	length := len(value)
 	var expr string
@@ -323,33 +483,11 @@ func AddStrExpr(metaType MetaType, values []string) (string, error) {
 	if length == 1 {
 		value := values[0]
 		if strings.Contains(value, value_range) {
-
-			rangeArr := strings.Split(value, value_range)
-			if len(rangeArr) != 2 && len(rangeArr) != 6 {
-				return "", strerror.CreateError("AddStrExpr error:" + value)
-			}
-			if len(rangeArr) == 2 {
-				expr += mark_str + rangeArr[0] + mark_str + value_range + mark_str + rangeArr[1] + mark_str
-			} else {
-				// 2022-11-22 18:00:00-2022-11-22 19:00:00
-				thirdRange := 3
-				count := 0
-				rangeIndex := -1
-				for i := 0; i < len(value); i++ {
-					if value[i] == '-' {
-						count += 1
-						if count == thirdRange {
-							rangeIndex = i
-							break
-						}
-					}
-				}
-				if rangeIndex != -1 {
-					expr += mark_str + value[:rangeIndex] + mark_str + value_range + mark_str + value[rangeIndex+1:] + mark_str
-				} else {
-					return "", strerror.CreateError("AddStrExpr parse error:" + value)
-				}
+			start, end, err := SplitTimeRange(value)
+			if err != nil {
+				return "", err
 			}
+			expr += mark_str + start + mark_str + value_range + mark_str + end + mark_str
 		} else {
 			expr += mark_str + values[0] + mark_str
 		}
@@ -361,6 +499,35 @@ func AddStrExpr(metaType MetaType, values []string) (string, error) {
 	return expr, nil
 }
 
+// SplitTimeRange 拆分时间范围 18:00:00-19:00:00 或者 2022-11-22 18:00:00-2022-11-22 19:00:00
+func SplitTimeRange(value string) (string, string, error) {
+	rangeArr := strings.Split(value, value_range)
+	if len(rangeArr) != 2 && len(rangeArr) != 6 {
+		return "", "", strerror.CreateError("AddStrExpr error:" + value)
+	}
+	if len(rangeArr) == 2 {
+		return rangeArr[0], rangeArr[1], nil
+	}
+
+	// 2022-11-22 18:00:00-2022-11-22 19:00:00
+	thirdRange := 3
+	count := 0
+	rangeIndex := -1
+	for i := 0; i < len(value); i++ {
+		if value[i] == '-' {
+			count += 1
+			if count == thirdRange {
+				rangeIndex = i
+				break
+			}
+		}
+	}
+	if rangeIndex == -1 {
+		return "", "", strerror.CreateError("AddStrExpr parse error:" + value)
+	}
+	return value[:rangeIndex], value[rangeIndex+1:], nil
+}
+
 func AddSet(elements []string) string {
 	var expr string
 	if len(elements) != 0 {
@@ -389,69 +556,280 @@ func (c *Nft) FlushRuleset() {
 	c.Exec(string(FlushRuleSet))
 }
 
+// ListRules 查看链上的规则，每条规则带有句柄
+func (c *Nft) ListRules() ([]model.RuleInfo, error) {
+	command := fmt.Sprintf(string(ListChain), c.Table.AddressFamily, c.Table.Name, c.Chain.Name)
+	output, err := c.Output(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []model.RuleInfo
+	for _, line := range strings.Split(output, "\n") {
+		// iifname "enp2s0" ... accept # handle 4
+		line = strings.TrimSpace(line)
+		index := strings.LastIndex(line, handleMark)
+		if index == -1 || strings.HasPrefix(line, "table ") || strings.HasPrefix(line, "chain ") {
+			continue
+		}
+
+		handle, err := strconv.ParseUint(strings.TrimSpace(line[index+len(handleMark):]), 10, 64)
+		if err != nil {
+			continue
+		}
+		rule := model.RuleInfo{Handle: handle, Expr: strings.TrimSpace(line[:index])}
+
+		// ... accept comment "fd:policy1:0123456789abcdef"
+		if commentIndex := strings.LastIndex(rule.Expr, string(MetaComment)+gap+mark); commentIndex != -1 {
+			comment := rule.Expr[commentIndex+len(MetaComment)+len(gap):]
+			SetRuleComment(&rule, strings.Trim(comment, mark))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ChainExist 链是否存在，只读取不创建，在 nft list chains 输出的 table、chain 行中查找
+func (c *Nft) ChainExist(table Table, chain string) (bool, error) {
+	output, err := c.Output(fmt.Sprintf(string(ListChains), table.AddressFamily))
+	if err != nil {
+		return false, err
+	}
+
+	inTable := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "table ") {
+			inTable = line == "table "+string(table.AddressFamily)+gap+table.Name+" {"
+			continue
+		}
+		if inTable && line == "chain "+chain+" {" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteRule 根据句柄删除规则
+func (c *Nft) DeleteRule(handle uint64) error {
+	command := fmt.Sprintf(string(DeleteRule), c.Table.AddressFamily, c.Table.Name, c.Chain.Name, handle)
+	return c.Exec(command)
+}
+
+// FlushChain 清空链上的规则
+func (c *Nft) FlushChain() error {
+	command := fmt.Sprintf(string(FlushChain), c.Table.AddressFamily, c.Table.Name, c.Chain.Name)
+	return c.Exec(command)
+}
+
+// Output 执行命令并返回标准输出
+func (c *Nft) Output(command string) (string, error) {
+	cmd := exec.Command("/bin/bash", "-c", command)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// Exec 执行命令，事务中只记录命令，Commit时一起执行
 func (c *Nft) Exec(command string) error {
+	if c.batch != nil {
+		c.batch = append(c.batch, scriptLine(command))
+		return nil
+	}
+
 	cmd := exec.Command("/bin/bash", "-c", command)
-	err := cmd.Run()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return strerror.CreateCodeError(strerror.CodeInternal, fmt.Sprintf("%s failed: %v: %s", command, err, strings.TrimSpace(string(output))))
+	}
+	return nil
+}
 
-	fmt.Println(cmd.String())
+// scriptLine 命令转换为 nft -f 脚本中的一行，去掉 nft 前缀和bash转义
+func scriptLine(command string) string {
+	line := strings.TrimPrefix(command, "nft ")
+	line = strings.ReplaceAll(line, mark_str, mark)
+	return strings.ReplaceAll(line, "\\;", ";")
+}
 
+// listTable 策略表的内容，表不存在时返回空
+func (c *Nft) listTable(table Table) string {
+	output, err := exec.Command("nft", "list", "table", string(table.AddressFamily), table.Name).Output()
 	if err != nil {
-		log.Fatalf("cmd.Run() failed with %s\n", err)
-		return err
+		return ""
+	}
+	return string(output)
+}
+
+// Begin 开始事务，之后的命令在Commit时通过一个 nft -f 脚本原子执行
+func (c *Nft) Begin(table Table) {
+	c.snapshot = c.listTable(table)
+	c.batch = []string{}
+}
+
+// Rollback 放弃事务中的命令
+func (c *Nft) Rollback() {
+	c.batch = nil
+	c.snapshot = ""
+}
+
+// Commit 执行事务，nft -f 失败时内核规则不变
+// 执行失败后策略表与事务开始前不一致时，用事务开始前的内容恢复
+func (c *Nft) Commit(table Table) error {
+	script := strings.Join(c.batch, "\n") + "\n"
+	snapshot := c.snapshot
+	c.Rollback()
+
+	err := c.runScript(script)
+	if err == nil {
+		return nil
+	}
+
+	if c.listTable(table) != snapshot {
+		restore := fmt.Sprintf("delete table %s %s\n", table.AddressFamily, table.Name) + snapshot
+		if restoreErr := c.runScript(restore); restoreErr != nil {
+			return strerror.CreateCodeError(strerror.CodeInternal, fmt.Sprintf("%v, restore failed: %v", err, restoreErr))
+		}
+		fmt.Printf("nft commit failed, table %s restored\n", table.Name)
+	}
+	return err
+}
+
+// runScript 通过标准输入执行 nft -f 脚本
+func (c *Nft) runScript(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return strerror.CreateCodeError(strerror.CodeInternal, fmt.Sprintf("nft -f failed: %v: %s", err, strings.TrimSpace(stderr.String())))
 	}
 	return nil
 }
 
-func getTimePolicyExpr(times []model.PolicyTime) (string, error) {
-	var exprs string
-	var err error
+// PolicyTimeValues 策略时间按匹配类型分组，同一类型的多个时间段取并集，不同类型之间取交集
+type PolicyTimeValues struct {
+	TimeStamps []string // meta time 2022-11-22 18:00:00-2022-11-22 19:00:00
+	Hours      []string // meta hour 18:00:00-19:00:00
+	Weeks      []string // meta day 0 / 1-5
+}
+
+// GetPolicyTimeValues 月份展开为一年内的日期时间段，设置了月份时小时只作用于月份
+func GetPolicyTimeValues(times []model.PolicyTime) (PolicyTimeValues, error) {
+	var values PolicyTimeValues
 
 	for _, policyTime := range times {
 		// 日期时间，时间戳
 		if len(policyTime.Day) != 0 {
-			expr, err := AddStrExpr(MetaTimeStamp, []string{policyTime.Day})
-			if err != nil {
-				return "", err
-			}
-			exprs += expr
+			values.TimeStamps = append(values.TimeStamps, policyTime.Day)
 		}
 
 		// 小时
 		if len(policyTime.Hour) != 0 && len(policyTime.Month) == 0 {
-			expr, err := AddStrExpr(MetaTimeHour, []string{policyTime.Hour})
-			if err != nil {
-				return "", err
-			}
-			exprs += expr
+			values.Hours = append(values.Hours, policyTime.Hour)
 		}
 
 		// 周
 		if len(policyTime.Week) != 0 {
-			expr, err := AddSingleExpr(MetaTimeDay, "{ "+policyTime.Week+" }")
-			if err != nil {
-				return "", err
+			for _, week := range strings.Split(policyTime.Week, comma) {
+				values.Weeks = append(values.Weeks, strings.TrimSpace(week))
 			}
-			exprs += expr
 		}
 
 		// 月
 		if len(policyTime.Month) != 0 {
-			monthExprs, err := GetMonthExprs(policyTime.Month, policyTime.Hour)
+			dateRanges, err := GetMonthDateRanges(policyTime.Month, policyTime.Hour)
 			if err != nil {
-				return "", err
+				return values, err
+			}
+			for _, dateRange := range dateRanges {
+				values.TimeStamps = append(values.TimeStamps, dateRange[0]+value_range+dateRange[1])
 			}
-			exprs += monthExprs
 		}
 	}
 
-	return exprs, err
+	return values, nil
+}
+
+func getTimePolicyExpr(times []model.PolicyTime) (string, error) {
+	var exprs string
+
+	values, err := GetPolicyTimeValues(times)
+	if err != nil {
+		return "", err
+	}
+
+	// 日期时间，时间戳
+	expr, err := AddTimeExpr(MetaTimeStamp, values.TimeStamps)
+	if err != nil {
+		return "", err
+	}
+	exprs += expr
+
+	// 小时
+	expr, err = AddTimeExpr(MetaTimeHour, values.Hours)
+	if err != nil {
+		return "", err
+	}
+	exprs += expr
+
+	// 周
+	if len(values.Weeks) != 0 {
+		expr, err := AddSingleExpr(MetaTimeDay, "{ "+strings.Join(values.Weeks, comma)+" }")
+		if err != nil {
+			return "", err
+		}
+		exprs += expr
+	}
+
+	return exprs, nil
+}
+
+// AddTimeExpr 多个时间段生成集合 meta hour { "16:00:00"-"18:00:00", "20:00:00"-"21:00:00" }
+func AddTimeExpr(metaType MetaType, values []string) (string, error) {
+	if len(values) <= 1 {
+		return AddStrExpr(metaType, values)
+	}
+
+	var elements []string
+	for _, value := range values {
+		if !strings.Contains(value, value_range) {
+			elements = append(elements, value)
+			continue
+		}
+
+		start, end, err := SplitTimeRange(value)
+		if err != nil {
+			return "", err
+		}
+		elements = append(elements, start+mark_str+value_range+mark_str+end)
+	}
+	return AddStrExpr(metaType, elements)
 }
 
 // GetMonthExprs 解析月份 1,2,3,4-23
 func GetMonthExprs(monthDaysStr string, hour string) (string, error) {
+	dateRanges, err := GetMonthDateRanges(monthDaysStr, hour)
+	if err != nil {
+		return "", err
+	}
+
+	var timeRange []string
+	for _, dateRange := range dateRanges {
+		timeRange = append(timeRange, dateRange[0]+mark_str+value_range+mark_str+dateRange[1])
+	}
+
+	expr, err := AddStrExpr(MetaTimeStamp, timeRange)
+	return expr, err
+}
+
+// GetMonthDateRanges 解析月份 1,2,3,4-23，返回一年内每个日期的起止时间
+func GetMonthDateRanges(monthDaysStr string, hour string) ([][2]string, error) {
 	monthDays := strings.Split(monthDaysStr, comma)
 	var startHour, endHour string
-	var timeRange []string
+	var timeRange [][2]string
 
 	if len(hour) != 0 {
 		hourRange := strings.Split(hour, value_range)
@@ -466,46 +844,45 @@ func GetMonthExprs(monthDaysStr string, hour string) (string, error) {
 		if strings.Contains(days, value_range) { // 持续时间
 			daysRange := strings.Split(days, value_range)
 			if len(daysRange) != 2 {
-				return "", strerror.CreateError("day range error:" + days)
+				return nil, strerror.CreateError("day range error:" + days)
 			}
 			startDay, err := strconv.Atoi(daysRange[0])
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 			endDay, err := strconv.Atoi(daysRange[1])
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 
 			for day := startDay; day <= endDay; day++ {
 				month, err := GetTimeDayFromMonth(day, startHour, endHour)
 				if err != nil {
-					return "", err
+					return nil, err
 				}
 				timeRange = append(timeRange, month...)
 			}
 		} else { // 单个时间
 			dayInt, err := strconv.Atoi(days)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 
 			month, err := GetTimeDayFromMonth(dayInt, startHour, endHour)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 			timeRange = append(timeRange, month...)
 		}
 	}
 
-	expr, err := AddStrExpr(MetaTimeStamp, timeRange)
-	return expr, err
+	return timeRange, nil
 }
 
-func GetTimeDayFromMonth(targetDay int, startHour string, endHour string) ([]string, error) {
+func GetTimeDayFromMonth(targetDay int, startHour string, endHour string) ([][2]string, error) {
 	now := time.Now()
 	day := now.Day()
-	var timeRange []string
+	var timeRange [][2]string
 	var startDate, endDate time.Time
 	var startDateStr, endDateStr string
 
@@ -535,7 +912,7 @@ func GetTimeDayFromMonth(targetDay int, startHour string, endHour string) ([]str
 		startDateStr = startDate.AddDate(0, i, 0).Format("2006-01-02 15:04:05")
 		endDateStr = endDate.AddDate(0, i, 0).Format("2006-01-02 15:04:05")
 
-		timeRange = append(timeRange, startDateStr+mark_str+value_range+mark_str+endDateStr)
+		timeRange = append(timeRange, [2]string{startDateStr, endDateStr})
 	}
 	return timeRange, err
 }
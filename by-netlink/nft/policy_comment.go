@@ -0,0 +1,68 @@
+package nft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 规则注释前缀，带有该前缀的规则由本程序管理
+const commentPrefix = "fd:"
+
+// 策略ID会写入nft命令和规则注释，只允许字母、数字和 _ . -
+var policyIdRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// CheckPolicyId 校验策略ID
+func CheckPolicyId(id string) error {
+	if !policyIdRegexp.MatchString(id) {
+		return strerror.CreateError("policy id error:" + id)
+	}
+	return nil
+}
+
+// PolicyHash 策略内容的摘要，作为策略版本，策略ID和管理字段不参与计算
+func PolicyHash(policy model.Policy) string {
+	policy.Id = ""
+	policy.Manager = ""
+	bs, _ := json.Marshal(policy)
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RuleComment 规则注释 fd:<策略ID>:<策略版本>，没有策略ID时为 fd:<策略版本>
+func RuleComment(policy model.Policy) string {
+	if len(policy.Id) == 0 {
+		return commentPrefix + PolicyHash(policy)
+	}
+	return commentPrefix + policy.Id + ":" + PolicyHash(policy)
+}
+
+// IsRuleComment 是否是本程序生成的规则注释
+func IsRuleComment(comment string) bool {
+	return strings.HasPrefix(comment, commentPrefix)
+}
+
+// ParseRuleComment 从规则注释中解析策略ID和版本
+func ParseRuleComment(comment string) (id string, version string) {
+	if !IsRuleComment(comment) {
+		return "", ""
+	}
+
+	value := strings.TrimPrefix(comment, commentPrefix)
+	index := strings.LastIndex(value, ":")
+	if index == -1 {
+		return "", value
+	}
+	return value[:index], value[index+1:]
+}
+
+// SetRuleComment 根据注释填充规则的策略ID和版本
+func SetRuleComment(rule *model.RuleInfo, comment string) {
+	rule.Comment = comment
+	rule.PolicyId, rule.Version = ParseRuleComment(comment)
+}
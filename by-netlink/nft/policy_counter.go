@@ -0,0 +1,78 @@
+package nft
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+)
+
+// 每条策略使用一个命名计数器，按地址族拆分的规则共用，策略更新时计数继续有效
+const counterPrefix = "fd-counter-"
+
+// 规则文本中引用的计数器 counter name "fd-counter-policy1"
+var counterNameRegexp = regexp.MustCompile(`counter name "?(` + counterPrefix + `[A-Za-z0-9_.-]+)"?`)
+
+// CounterName 规则注释对应的计数器 fd-counter-<策略ID>，没有策略ID时使用策略版本
+func CounterName(comment string) string {
+	id, version := ParseRuleComment(comment)
+	if len(id) == 0 {
+		id = version
+	}
+	if len(id) == 0 {
+		return ""
+	}
+	return PolicyCounterName(id)
+}
+
+// PolicyCounterName 策略ID对应的计数器
+func PolicyCounterName(id string) string {
+	return counterPrefix + id
+}
+
+// IsCounterName 是否是本程序创建的计数器
+func IsCounterName(name string) bool {
+	return strings.HasPrefix(name, counterPrefix)
+}
+
+// AddCounterExpr 引用命名计数器，返回需要先创建计数器的命令，已存在时nft add不会报错
+// counter name fd-counter-policy1
+func AddCounterExpr(table Table, comment string) (expr string, addCounter string) {
+	name := CounterName(comment)
+	if len(name) == 0 {
+		return "", ""
+	}
+	addCounter = fmt.Sprintf(string(AddCounter), table.AddressFamily, table.Name, name)
+	return "counter name " + name + gap, addCounter
+}
+
+// RuleCounterName nft命令输出的规则文本引用的计数器，没有引用时返回空
+func RuleCounterName(expr string) string {
+	match := counterNameRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// DeleteCounters 删除已清空的规则引用、新策略不再使用的计数器，在清空策略链之后执行
+func (c *Nft) DeleteCounters(rules []model.RuleInfo, policys []model.Policy) error {
+	keep := make(map[string]bool)
+	for _, policy := range policys {
+		keep[CounterName(RuleComment(policy))] = true
+	}
+
+	for _, rule := range rules {
+		name := RuleCounterName(rule.Expr)
+		if len(name) == 0 || keep[name] {
+			continue
+		}
+		keep[name] = true
+		command := fmt.Sprintf(string(DeleteCounter), c.Table.AddressFamily, c.Table.Name, name)
+		if err := c.Exec(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
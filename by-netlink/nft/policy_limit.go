@@ -0,0 +1,373 @@
+package nft
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	iptools "netvine.com/firewall/server/utils"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 按地址限速、限制连接数
+const (
+	LimitPerSource = "saddr" // 每个源地址单独计算
+	LimitPerDest   = "daddr" // 每个目的地址单独计算
+)
+
+// 按地址限速时使用的动态集合，地址超过MeterTimeout没有报文后从集合中删除
+const (
+	meterSetPrefix = "fd-meter-"
+	MeterTimeout   = time.Minute
+)
+
+// 规则文本中引用的动态集合 update @fd-meter-policy1-ip4 { ... }
+var meterSetRegexp = regexp.MustCompile(`@(` + meterSetPrefix + `[A-Za-z0-9_.-]+)`)
+
+// 规则匹配的地址族，与 meta nfproto 一致
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// 限速的时间单位，与nft limit rate一致
+var limitUnits = []struct {
+	name    string
+	seconds uint64
+}{
+	{"week", 7 * 24 * 3600},
+	{"day", 24 * 3600},
+	{"hour", 3600},
+	{"minute", 60},
+	{"second", 1},
+}
+
+// 按字节限速的单位
+var limitByteUnits = []struct {
+	name  string
+	bytes uint64
+}{
+	{"mbytes", 1024 * 1024},
+	{"kbytes", 1024},
+	{"bytes", 1},
+}
+
+// DefaultLimitBurst 没有设置突发时按报文限速的突发，与nft命令一致
+const DefaultLimitBurst = 5
+
+// LimitMatch 策略中的限速和连接数限制，超过限制的报文才执行策略动作
+type LimitMatch struct {
+	Rate      uint64 // 每个时间单位的报文数或者字节数，0表示不限速
+	Unit      uint64 // 时间单位，秒
+	Bytes     bool   // 按字节限速
+	Burst     uint32 // 突发的报文数或者字节数
+	ConnCount uint32 // 最大并发连接数，0表示不限
+	Per       string // 按地址计算 saddr / daddr，为空时整条策略共用
+}
+
+// Limited 是否有限速或者连接数限制
+func (m LimitMatch) Limited() bool {
+	return m.Rate != 0 || m.ConnCount != 0
+}
+
+// HasLimit 策略只对超过限制的报文执行动作
+func HasLimit(policy model.Policy) bool {
+	return len(policy.Limit) != 0 || policy.ConnLimit != 0
+}
+
+// ParseLimitRate 解析限速 100/second / 10 mbytes/second / 512kbytes/minute
+func ParseLimitRate(value string) (rate uint64, unit uint64, bytes bool, err error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(value)), "/")
+	if len(parts) != 2 {
+		return 0, 0, false, strerror.CreateError("limit rate error:" + value)
+	}
+
+	for _, u := range limitUnits {
+		if strings.TrimSpace(parts[1]) == u.name {
+			unit = u.seconds
+		}
+	}
+	if unit == 0 {
+		return 0, 0, false, strerror.CreateError("limit unit error:" + value)
+	}
+
+	number, multiple := strings.TrimSpace(parts[0]), uint64(1)
+	for _, u := range limitByteUnits {
+		if strings.HasSuffix(number, u.name) {
+			number, multiple, bytes = strings.TrimSpace(strings.TrimSuffix(number, u.name)), u.bytes, true
+			break
+		}
+	}
+	rate, err = strconv.ParseUint(number, 10, 32)
+	if err != nil || rate == 0 {
+		return 0, 0, false, strerror.CreateError("limit rate error:" + value)
+	}
+	return rate * multiple, unit, bytes, nil
+}
+
+// FormatLimitRate 与ParseLimitRate相反，字节数使用能整除的最大单位
+func FormatLimitRate(rate uint64, unit uint64, bytes bool) string {
+	unitName := strconv.FormatUint(unit, 10)
+	for _, u := range limitUnits {
+		if unit == u.seconds {
+			unitName = u.name
+		}
+	}
+	if !bytes {
+		return strconv.FormatUint(rate, 10) + "/" + unitName
+	}
+	for _, u := range limitByteUnits {
+		if rate%u.bytes == 0 {
+			return strconv.FormatUint(rate/u.bytes, 10) + " " + u.name + "/" + unitName
+		}
+	}
+	return ""
+}
+
+// GetLimitMatch 校验并解析策略中的限速、突发、连接数限制和按地址计算
+func GetLimitMatch(policy model.Policy) (LimitMatch, error) {
+	var match LimitMatch
+
+	if len(policy.Limit) != 0 {
+		var err error
+		match.Rate, match.Unit, match.Bytes, err = ParseLimitRate(policy.Limit)
+		if err != nil {
+			return match, err
+		}
+		match.Burst = DefaultLimitBurst
+		if match.Bytes {
+			match.Burst = 0
+		}
+	}
+
+	if policy.LimitBurst != 0 {
+		if match.Rate == 0 || policy.LimitBurst < 0 {
+			return match, strerror.CreateError("limit burst requires limit rate:" + strconv.Itoa(policy.LimitBurst))
+		}
+		match.Burst = uint32(policy.LimitBurst)
+	}
+
+	if policy.ConnLimit < 0 {
+		return match, strerror.CreateError("connection limit error:" + strconv.Itoa(policy.ConnLimit))
+	}
+	match.ConnCount = uint32(policy.ConnLimit)
+
+	switch policy.LimitPer {
+	case "":
+	case LimitPerSource, LimitPerDest:
+		if !match.Limited() {
+			return match, strerror.CreateError("limit per address requires limit rate or connection limit")
+		}
+		match.Per = policy.LimitPer
+	default:
+		return match, strerror.CreateError("limit per error:" + policy.LimitPer)
+	}
+	return match, nil
+}
+
+// CheckFamily 校验策略匹配的地址族
+func CheckFamily(policy model.Policy) error {
+	switch policy.Family {
+	case "", FamilyIPv4, FamilyIPv6:
+		return nil
+	}
+	return strerror.CreateError("family error:" + policy.Family)
+}
+
+// splitMeterFamily 按地址计算且没有地址时无法确定动态集合的地址族，拆分成IPv4和IPv6两条规则
+// 每条规则用 meta nfproto 只匹配一个地址族，使用各自的动态集合
+func splitMeterFamily(policy model.Policy) []model.Policy {
+	if len(policy.LimitPer) == 0 || len(policy.Family) != 0 || len(policy.SIp) != 0 || len(policy.DIp) != 0 {
+		return []model.Policy{policy}
+	}
+	ipv4, ipv6 := policy, policy
+	ipv4.Family, ipv6.Family = FamilyIPv4, FamilyIPv6
+	return []model.Policy{ipv4, ipv6}
+}
+
+// splitIpFamily inet表中 ip saddr 只匹配IPv4报文，ip6 saddr 只匹配IPv6报文，
+// 同时包含IPv4和IPv6地址的策略拆分成两条规则
+func splitIpFamily(policy model.Policy) ([]model.Policy, error) {
+	if len(policy.SIp) == 0 && len(policy.DIp) == 0 {
+		return []model.Policy{policy}, nil
+	}
+
+	sIpv4, sIpv6, err := iptools.SplitIpFamily(policy.SIp)
+	if err != nil {
+		return nil, err
+	}
+	dIpv4, dIpv6, err := iptools.SplitIpFamily(policy.DIp)
+	if err != nil {
+		return nil, err
+	}
+
+	var policys []model.Policy
+	familys := [][2][]string{{sIpv4, dIpv4}, {sIpv6, dIpv6}}
+	for _, family := range familys {
+		sIp, dIp := family[0], family[1]
+		// 源和目的只要有一个在该地址族下没有地址，就不会有报文命中
+		if (len(policy.SIp) != 0 && len(sIp) == 0) || (len(policy.DIp) != 0 && len(dIp) == 0) {
+			continue
+		}
+
+		familyPolicy := policy
+		familyPolicy.SIp = sIp
+		familyPolicy.DIp = dIp
+		policys = append(policys, familyPolicy)
+	}
+
+	if len(policys) == 0 {
+		return nil, strerror.CreateError("source ip and destination ip are not in the same family")
+	}
+	return policys, nil
+}
+
+// MeterIPv6 按地址计算时集合的地址族，由策略的地址族或者地址决定，地址族拆分后的策略只有一个地址族
+func MeterIPv6(policy model.Policy) (bool, error) {
+	if len(policy.Family) != 0 {
+		return policy.Family == FamilyIPv6, nil
+	}
+	values := policy.SIp
+	if len(values) == 0 {
+		values = policy.DIp
+	}
+	if len(values) == 0 {
+		return false, strerror.CreateError("limit per address requires source or destination ip")
+	}
+	return iptools.IsIPv6(values[0])
+}
+
+// MeterSetName 按地址计算使用的动态集合 fd-meter-<策略ID>-ip4，没有策略ID时使用策略版本
+func MeterSetName(policy model.Policy, ipv6 bool) string {
+	name := policy.Id
+	if len(name) == 0 {
+		name = PolicyHash(policy)
+	}
+	if ipv6 {
+		return meterSetPrefix + name + "-ip6"
+	}
+	return meterSetPrefix + name + "-ip4"
+}
+
+// IsMeterSetName 是否是本程序创建的按地址计算的动态集合
+func IsMeterSetName(name string) bool {
+	return strings.HasPrefix(name, meterSetPrefix)
+}
+
+// FamilyMeterSetName 按地址族拆分后的策略使用的动态集合，不按地址计算时返回空
+func FamilyMeterSetName(policy model.Policy) (string, error) {
+	match, err := GetLimitMatch(policy)
+	if err != nil || len(match.Per) == 0 {
+		return "", err
+	}
+	ipv6, err := MeterIPv6(policy)
+	if err != nil {
+		return "", err
+	}
+	return MeterSetName(policy, ipv6), nil
+}
+
+// PolicyMeterSetNames 策略按地址族拆分后使用的动态集合
+func PolicyMeterSetNames(policy model.Policy) ([]string, error) {
+	policys, err := SplitPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, familyPolicy := range policys {
+		name, err := FamilyMeterSetName(familyPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// RuleMeterSetName nft命令输出的规则文本引用的动态集合，没有引用时返回空
+func RuleMeterSetName(expr string) string {
+	match := meterSetRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// DeleteMeterSets 删除已清空的规则引用、新策略不再使用的动态集合，在清空策略链之后执行
+// 集合被删除规则引用时在同一个事务中删除，否则集合一直留在策略表中
+func (c *Nft) DeleteMeterSets(rules []model.RuleInfo, policys []model.Policy) error {
+	keep := make(map[string]bool)
+	for _, policy := range policys {
+		names, err := PolicyMeterSetNames(policy)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			keep[name] = true
+		}
+	}
+
+	for _, rule := range rules {
+		name := RuleMeterSetName(rule.Expr)
+		if len(name) == 0 || keep[name] {
+			continue
+		}
+		keep[name] = true
+		command := fmt.Sprintf(string(DeleteSet), c.Table.AddressFamily, c.Table.Name, name)
+		if err := c.Exec(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitStatement limit rate over 100/second burst 5 packets
+func limitStatement(match LimitMatch) string {
+	statement := "limit rate over " + FormatLimitRate(match.Rate, match.Unit, match.Bytes)
+	if match.Bytes {
+		if match.Burst != 0 {
+			statement += " burst " + strconv.FormatUint(uint64(match.Burst), 10) + " bytes"
+		}
+		return statement
+	}
+	return statement + " burst " + strconv.FormatUint(uint64(match.Burst), 10) + " packets"
+}
+
+// AddLimitExpr 生成限速和连接数限制表达式，按地址计算时返回需要先创建的动态集合
+// limit rate over 100/second burst 5 packets ct count over 10
+// update @fd-meter-policy1-ip4 { ip saddr limit rate over 100/second burst 5 packets }
+func AddLimitExpr(table Table, policy model.Policy) (expr string, addSet string, err error) {
+	match, err := GetLimitMatch(policy)
+	if err != nil || !match.Limited() {
+		return "", "", err
+	}
+
+	var statements []string
+	if match.Rate != 0 {
+		statements = append(statements, limitStatement(match))
+	}
+	if match.ConnCount != 0 {
+		statements = append(statements, "ct count over "+strconv.FormatUint(uint64(match.ConnCount), 10))
+	}
+	if len(match.Per) == 0 {
+		return strings.Join(statements, gap) + gap, "", nil
+	}
+
+	ipv6, err := MeterIPv6(policy)
+	if err != nil {
+		return "", "", err
+	}
+	keyType, key := "ipv4_addr", "ip "+match.Per
+	if ipv6 {
+		keyType, key = "ipv6_addr", "ip6 "+match.Per
+	}
+	setName := MeterSetName(policy, ipv6)
+	addSet = "nft add set " + string(table.AddressFamily) + gap + table.Name + gap + setName +
+		" { type " + keyType + "\\; flags dynamic,timeout\\; timeout " + strconv.Itoa(int(MeterTimeout.Seconds())) + "s\\; }"
+	return "update @" + setName + " { " + key + gap + strings.Join(statements, gap) + " }" + gap, addSet, nil
+}
@@ -5,26 +5,98 @@ import "netvine.com/firewall/server/model"
 type PolicyManagerCommandService struct {
 }
 
+// 策略表
+var policyTable = Table{Name: NftTable, AddressFamily: NftFamily}
+
+// GeneratePolicyRule 清空策略链后重新下发，不影响其他表的规则
 func (p *PolicyManagerCommandService) GeneratePolicyRule(policys []model.Policy) error {
-	nft := Nft{}
-	nft.FlushRuleset()
+	return p.applyPolicys(policys, true)
+}
 
-	err := nft.AddTable(Table{Name: NftTable, AddressFamily: FamilyIP})
-	if err != nil {
-		return err
-	}
+// initNft 创建策略表和链，已存在时nft add不会报错
+func (p *PolicyManagerCommandService) initNft() (*Nft, error) {
+	nft := &Nft{}
+	return nft, p.addTableChain(nft)
+}
 
-	err = nft.AddChain(Chain{Name: BaseRuleChain, Type: TypeFilter, Hook: HookForward, Policy: PolicyAccept})
+func (p *PolicyManagerCommandService) addTableChain(nft *Nft) error {
+	err := nft.AddTable(policyTable)
 	if err != nil {
 		return err
 	}
 
-	for _, p := range policys {
-		err := nft.AddRule(p)
+	return nft.AddChain(Chain{Name: BaseRuleChain, Type: TypeFilter, Hook: HookForward, Policy: PolicyDrop})
+}
+
+// applyPolicys 所有命令在一个 nft -f 脚本中执行，任何一条失败时都不会修改规则
+// 清空策略链时保留连接跟踪规则，删除不再使用的计数器和动态集合
+func (p *PolicyManagerCommandService) applyPolicys(policys []model.Policy, flush bool) error {
+	var rules []model.RuleInfo
+	if flush {
+		var err error
+		rules, err = p.ListRules()
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	nft := &Nft{}
+	nft.Begin(policyTable)
+
+	err := p.addTableChain(nft)
+	if err == nil && flush {
+		err = nft.FlushChain()
+	}
+	if err == nil {
+		err = nft.AddConntrackRules(GetConntrackComments(rules))
+	}
+	for i := 0; err == nil && i < len(policys); i++ {
+		err = nft.AddRule(policys[i])
+	}
+	if err == nil {
+		err = nft.DeleteCounters(rules, policys)
+	}
+	if err == nil {
+		err = nft.DeleteMeterSets(rules, policys)
+	}
+	if err != nil {
+		nft.Rollback()
+		return err
+	}
+
+	return nft.Commit(policyTable)
+}
+
+// ApplyPolicys 追加下发策略
+func (p *PolicyManagerCommandService) ApplyPolicys(policys []model.Policy) error {
+	return p.applyPolicys(policys, false)
+}
+
+// ReplacePolicys 清空策略链并下发策略，在同一个事务中执行
+func (p *PolicyManagerCommandService) ReplacePolicys(policys []model.Policy) error {
+	return p.applyPolicys(policys, true)
+}
+
+// ListRules 查看已下发的规则，策略链不存在时返回空，不创建表和链
+func (p *PolicyManagerCommandService) ListRules() ([]model.RuleInfo, error) {
+	nft := &Nft{Table: policyTable, Chain: Chain{Name: BaseRuleChain}}
+	exist, err := nft.ChainExist(policyTable, BaseRuleChain)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return nft.ListRules()
+}
+
+// DeleteRule 根据句柄删除规则
+func (p *PolicyManagerCommandService) DeleteRule(handle uint64) error {
+	nft, err := p.initNft()
+	if err != nil {
+		return err
+	}
+	return nft.DeleteRule(handle)
+}
+
+// FlushRules 清空策略链，保留连接跟踪规则
+func (p *PolicyManagerCommandService) FlushRules() error {
+	return p.applyPolicys(nil, true)
 }
@@ -0,0 +1,160 @@
+package nft
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// PortRange 端口范围，单个端口起止相同
+type PortRange struct {
+	Start uint16
+	End   uint16
+}
+
+// serviceNames 常用服务名称，与/etc/services一致，工控协议使用标准端口
+var serviceNames = map[string]uint16{
+	"ftp-data":    20,
+	"ftp":         21,
+	"ssh":         22,
+	"telnet":      23,
+	"smtp":        25,
+	"domain":      53,
+	"dns":         53,
+	"bootps":      67,
+	"bootpc":      68,
+	"tftp":        69,
+	"http":        80,
+	"iso-tsap":    102,
+	"s7":          102,
+	"pop3":        110,
+	"ntp":         123,
+	"imap":        143,
+	"snmp":        161,
+	"snmp-trap":   162,
+	"ldap":        389,
+	"https":       443,
+	"modbus":      502,
+	"syslog":      514,
+	"ldaps":       636,
+	"iec-104":     2404,
+	"mysql":       3306,
+	"rdp":         3389,
+	"opcua":       4840,
+	"postgresql":  5432,
+	"redis":       6379,
+	"http-alt":    8080,
+	"dnp3":        20000,
+	"ethernet-ip": 44818,
+	"bacnet":      47808,
+}
+
+// parsePort 端口号1-65535或者serviceNames中的服务名称
+// 不查询/etc/services，同一个策略在不同主机上解析结果一致
+func parsePort(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if port, err := strconv.ParseUint(value, 10, 16); err == nil {
+		if port == 0 {
+			return 0, strerror.CreateError("port error:" + value)
+		}
+		return port, nil
+	}
+
+	if port, ok := serviceNames[strings.ToLower(value)]; ok {
+		return uint64(port), nil
+	}
+	return 0, strerror.CreateError("port error:" + value)
+}
+
+// ParsePortRange 解析端口 22 / 1024-65535 / http / ftp-data-ssh
+func ParsePortRange(value string) (PortRange, error) {
+	// 服务名称中可能有"-"，整体是一个端口时不按范围拆分
+	if port, err := parsePort(value); err == nil {
+		return PortRange{Start: uint16(port), End: uint16(port)}, nil
+	}
+
+	// 按第一个能够解析的"-"拆分范围
+	for i := strings.Index(value, value_range); i >= 0; {
+		startPort, startErr := parsePort(value[:i])
+		endPort, endErr := parsePort(value[i+1:])
+		if startErr == nil && endErr == nil && startPort <= endPort {
+			return PortRange{Start: uint16(startPort), End: uint16(endPort)}, nil
+		}
+
+		next := strings.Index(value[i+1:], value_range)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return PortRange{}, strerror.CreateError("port error:" + value)
+}
+
+// CheckPorts 校验策略的源端口和目的端口
+func CheckPorts(policy model.Policy) error {
+	for _, ports := range []model.Ports{policy.SPort, policy.DPort} {
+		if _, err := GetPortRanges(ports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPortRanges 解析端口列表，排序并合并重叠、相邻的范围
+func GetPortRanges(ports model.Ports) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, port := range ports {
+		r, err := ParsePortRange(port)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	var merged []PortRange
+	for _, r := range ranges {
+		if len(merged) != 0 {
+			last := &merged[len(merged)-1]
+			if last.End == 65535 || r.Start <= last.End+1 {
+				if r.End > last.End {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// String 22 / 1024-65535
+func (r PortRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(int(r.Start))
+	}
+	return strconv.Itoa(int(r.Start)) + value_range + strconv.Itoa(int(r.End))
+}
+
+// AddPortExpr 生成端口表达式 th dport 22 / th dport 8000-8100 / th dport { 80, 443, 8000-8100 }
+func AddPortExpr(metaType MetaType, ports model.Ports) (string, error) {
+	ranges, err := GetPortRanges(ports)
+	if err != nil {
+		return "", err
+	}
+	if len(ranges) == 0 {
+		return "", nil
+	}
+
+	var values []string
+	for _, r := range ranges {
+		values = append(values, r.String())
+	}
+	if len(values) == 1 {
+		return AddSingleExpr(metaType, values[0])
+	}
+	return AddSingleExpr(metaType, "{ "+strings.Join(values, comma+gap)+" }")
+}
@@ -0,0 +1,110 @@
+package nft
+
+import (
+	"reflect"
+	"testing"
+
+	"netvine.com/firewall/server/model"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    PortRange
+		wantErr bool
+	}{
+		{value: "22", want: PortRange{22, 22}},
+		{value: "1", want: PortRange{1, 1}},
+		{value: "65535", want: PortRange{65535, 65535}},
+		{value: "1024-65535", want: PortRange{1024, 65535}},
+		{value: "http", want: PortRange{80, 80}},
+		{value: "HTTPS", want: PortRange{443, 443}},
+		{value: " modbus ", want: PortRange{502, 502}},
+		// 服务名称中的"-"不作为范围
+		{value: "ftp-data", want: PortRange{20, 20}},
+		{value: "ftp-data-ssh", want: PortRange{20, 22}},
+		{value: "ssh-http-alt", want: PortRange{22, 8080}},
+		{value: "50-50", want: PortRange{50, 50}},
+		{value: "100-50", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "0-100", wantErr: true},
+		{value: "65536", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "1-", wantErr: true},
+		{value: "", wantErr: true},
+		// 不查询/etc/services，不在内置表中的名称报错
+		{value: "echo", wantErr: true},
+		{value: "unknown-service", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParsePortRange(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePortRange(%q) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePortRange(%q) error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParsePortRange(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPortRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   string
+		want    []PortRange
+		wantErr bool
+	}{
+		{name: "empty", ports: "", want: nil},
+		{name: "sorted", ports: "443,80", want: []PortRange{{80, 80}, {443, 443}}},
+		{name: "adjacent merged", ports: "80,81,82-90", want: []PortRange{{80, 90}}},
+		{name: "overlap merged", ports: "8000-8100,8050-8200", want: []PortRange{{8000, 8200}}},
+		{name: "contained", ports: "1-100,20-30", want: []PortRange{{1, 100}}},
+		{name: "gap kept", ports: "80,82", want: []PortRange{{80, 80}, {82, 82}}},
+		{name: "max port and full range", ports: "65535,1-65535", want: []PortRange{{1, 65535}}},
+		{name: "max port twice", ports: "65535,65535", want: []PortRange{{65535, 65535}}},
+		{name: "after max port", ports: "1-65535,22", want: []PortRange{{1, 65535}}},
+		{name: "service names", ports: "ssh,ftp-data-ftp", want: []PortRange{{20, 22}}},
+		{name: "reversed range", ports: "80,100-50", wantErr: true},
+		{name: "zero port", ports: "0", wantErr: true},
+		{name: "unknown name", ports: "http,echo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetPortRanges(model.ParsePorts(tt.ports))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetPortRanges(%q) = %v, want error", tt.ports, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetPortRanges(%q) error: %v", tt.ports, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("GetPortRanges(%q) = %v, want %v", tt.ports, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	if err := CheckPorts(model.Policy{SPort: model.Ports{"1024-65535"}, DPort: model.Ports{"http", "8000-8100"}}); err != nil {
+		t.Fatalf("CheckPorts() error: %v", err)
+	}
+	if err := CheckPorts(model.Policy{SPort: model.Ports{"0"}}); err == nil {
+		t.Fatal("CheckPorts() source port 0, want error")
+	}
+	if err := CheckPorts(model.Policy{DPort: model.Ports{"100-50"}}); err == nil {
+		t.Fatal("CheckPorts() reversed destination range, want error")
+	}
+}
@@ -0,0 +1,387 @@
+package nft
+
+import (
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+const (
+	ProtocolICMP   uint8 = 1
+	ProtocolTCP    uint8 = 6
+	ProtocolUDP    uint8 = 17
+	ProtocolICMPv6 uint8 = 58
+)
+
+// protocolNumbers 协议名称，与/etc/protocols一致，别名指向同一个协议号
+var protocolNumbers = map[string]uint8{
+	"hopopt":          0,
+	"icmp":            ProtocolICMP,
+	"igmp":            2,
+	"ggp":             3,
+	"ipencap":         4,
+	"ipip":            4,
+	"st":              5,
+	"tcp":             ProtocolTCP,
+	"egp":             8,
+	"igp":             9,
+	"pup":             12,
+	"udp":             ProtocolUDP,
+	"hmp":             20,
+	"xns-idp":         22,
+	"rdp":             27,
+	"iso-tp4":         29,
+	"dccp":            33,
+	"xtp":             36,
+	"ddp":             37,
+	"idpr-cmtp":       38,
+	"ipv6":            41,
+	"ipv6-route":      43,
+	"ipv6-frag":       44,
+	"idrp":            45,
+	"rsvp":            46,
+	"gre":             47,
+	"esp":             50,
+	"ah":              51,
+	"skip":            57,
+	"icmpv6":          ProtocolICMPv6,
+	"ipv6-icmp":       ProtocolICMPv6,
+	"ipv6-nonxt":      59,
+	"ipv6-opts":       60,
+	"rspf":            73,
+	"vmtp":            81,
+	"eigrp":           88,
+	"ospf":            89,
+	"ax.25":           93,
+	"ipip-nos":        94,
+	"etherip":         97,
+	"encap":           98,
+	"pim":             103,
+	"ipcomp":          108,
+	"vrrp":            112,
+	"l2tp":            115,
+	"isis":            124,
+	"sctp":            132,
+	"fc":              133,
+	"mobility-header": 135,
+	"udplite":         136,
+	"mpls-in-ip":      137,
+	"manet":           138,
+	"hip":             139,
+	"shim6":           140,
+	"wesp":            141,
+	"rohc":            142,
+	"ethernet":        143,
+}
+
+// protocolNames 协议号对应的规范名称
+var protocolNames = map[uint8]string{}
+
+// icmpTypeNames icmpv6TypeNames 类型号对应的名称
+var (
+	icmpTypeNames   = map[uint8]string{}
+	icmpv6TypeNames = map[uint8]string{}
+)
+
+// tcpFlagNames 按标志位从低到高排列的名称
+var tcpFlagNames [8]string
+
+func init() {
+	for name, number := range protocolNumbers {
+		if current, ok := protocolNames[number]; !ok || len(name) < len(current) || (len(name) == len(current) && name < current) {
+			protocolNames[number] = name
+		}
+	}
+	// 与nft输出保持一致
+	protocolNames[ProtocolICMPv6] = "icmpv6"
+
+	for name, icmpType := range icmpTypes {
+		icmpTypeNames[icmpType] = name
+	}
+	for name, icmpType := range icmpv6Types {
+		icmpv6TypeNames[icmpType] = name
+	}
+	for name, bit := range tcpFlags {
+		tcpFlagNames[bits.TrailingZeros8(bit)] = name
+	}
+}
+
+// icmpTypes ICMP类型名称，与nft icmp type一致
+var icmpTypes = map[string]uint8{
+	"echo-reply":              0,
+	"destination-unreachable": 3,
+	"source-quench":           4,
+	"redirect":                5,
+	"echo-request":            8,
+	"router-advertisement":    9,
+	"router-solicitation":     10,
+	"time-exceeded":           11,
+	"parameter-problem":       12,
+	"timestamp-request":       13,
+	"timestamp-reply":         14,
+	"info-request":            15,
+	"info-reply":              16,
+	"address-mask-request":    17,
+	"address-mask-reply":      18,
+}
+
+// icmpv6Types ICMPv6类型名称，与nft icmpv6 type一致
+var icmpv6Types = map[string]uint8{
+	"destination-unreachable": 1,
+	"packet-too-big":          2,
+	"time-exceeded":           3,
+	"parameter-problem":       4,
+	"echo-request":            128,
+	"echo-reply":              129,
+	"mld-listener-query":      130,
+	"mld-listener-report":     131,
+	"mld-listener-done":       132,
+	"nd-router-solicit":       133,
+	"nd-router-advert":        134,
+	"nd-neighbor-solicit":     135,
+	"nd-neighbor-advert":      136,
+	"nd-redirect":             137,
+	"router-renumbering":      138,
+	"ind-neighbor-solicit":    141,
+	"ind-neighbor-advert":     142,
+	"mld2-listener-report":    143,
+}
+
+// TCP标志位，与nft tcp flags一致
+var tcpFlags = map[string]uint8{
+	"fin": 0x01,
+	"syn": 0x02,
+	"rst": 0x04,
+	"psh": 0x08,
+	"ack": 0x10,
+	"urg": 0x20,
+	"ecn": 0x40,
+	"cwr": 0x80,
+}
+
+// ParseProtocol 协议名称或者协议号 tcp / 6
+func ParseProtocol(value string) (uint8, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if number, ok := protocolNumbers[value]; ok {
+		return number, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, strerror.CreateError("protocol error:" + value)
+	}
+	return uint8(number), nil
+}
+
+// ProtocolName 协议号对应的名称，没有名称时返回协议号
+func ProtocolName(number uint8) string {
+	if name, ok := protocolNames[number]; ok {
+		return name
+	}
+	return strconv.Itoa(int(number))
+}
+
+// ParseProtocols 解析逗号分隔的协议列表 tcp,udp / 47，去重并排序
+func ParseProtocols(value string) ([]uint8, error) {
+	var numbers []uint8
+	exist := make(map[uint8]bool)
+	for _, protocol := range strings.Split(value, comma) {
+		if len(strings.TrimSpace(protocol)) == 0 {
+			continue
+		}
+		number, err := ParseProtocol(protocol)
+		if err != nil {
+			return nil, err
+		}
+		if !exist[number] {
+			exist[number] = true
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// ParseIcmpType ICMP/ICMPv6类型名称或者类型号
+func ParseIcmpType(protocol uint8, value string) (uint8, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	types := icmpTypes
+	if protocol == ProtocolICMPv6 {
+		types = icmpv6Types
+	}
+	if icmpType, ok := types[value]; ok {
+		return icmpType, nil
+	}
+	icmpType, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, strerror.CreateError("icmp type error:" + value)
+	}
+	return uint8(icmpType), nil
+}
+
+// IcmpTypeName ICMP/ICMPv6类型号对应的名称，没有名称时返回类型号
+func IcmpTypeName(protocol uint8, icmpType uint8) string {
+	names := icmpTypeNames
+	if protocol == ProtocolICMPv6 {
+		names = icmpv6TypeNames
+	}
+	if name, ok := names[icmpType]; ok {
+		return name
+	}
+	return strconv.Itoa(int(icmpType))
+}
+
+// ParseTcpFlags 解析TCP标志 syn / syn,!ack，返回参与比较的标志位和期望的值
+// syn,!ack 表示 tcp flags & (syn|ack) == syn
+func ParseTcpFlags(value string) (mask uint8, flags uint8, err error) {
+	for _, flag := range strings.Split(value, comma) {
+		flag = strings.ToLower(strings.TrimSpace(flag))
+		if len(flag) == 0 {
+			continue
+		}
+		unset := strings.HasPrefix(flag, "!")
+		bit, ok := tcpFlags[strings.TrimPrefix(flag, "!")]
+		if !ok {
+			return 0, 0, strerror.CreateError("tcp flag error:" + flag)
+		}
+		mask |= bit
+		if !unset {
+			flags |= bit
+		}
+	}
+	if mask == 0 {
+		return 0, 0, strerror.CreateError("tcp flag error:" + value)
+	}
+	return mask, flags, nil
+}
+
+// TcpFlagNames 标志位转换为名称列表
+func TcpFlagNames(flags uint8) []string {
+	var names []string
+	for i, name := range tcpFlagNames {
+		if flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FormatTcpFlags 与ParseTcpFlags相反 syn,!ack
+func FormatTcpFlags(mask uint8, flags uint8) string {
+	var names []string
+	for _, name := range TcpFlagNames(mask) {
+		if flags&tcpFlags[name] == 0 {
+			name = "!" + name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, comma)
+}
+
+// ProtocolMatch 策略中协议相关的匹配条件
+type ProtocolMatch struct {
+	Protocols []uint8 // 协议号，为空表示不限协议
+	IcmpType  int     // ICMP/ICMPv6类型，-1表示不限
+	IcmpCode  int     // ICMP代码，-1表示不限
+	TcpMask   uint8   // 参与比较的TCP标志位，0表示不限
+	TcpFlags  uint8   // 期望的TCP标志
+}
+
+// Icmpv6 ICMP类型属于ICMPv6
+func (m ProtocolMatch) Icmpv6() bool {
+	return len(m.Protocols) == 1 && m.Protocols[0] == ProtocolICMPv6
+}
+
+// GetProtocolMatch 校验并解析策略中的协议、ICMP类型和TCP标志，未知协议返回错误
+// ICMP类型只能用于单个icmp或者icmpv6协议，TCP标志只能用于单个tcp协议
+func GetProtocolMatch(policy model.Policy) (ProtocolMatch, error) {
+	match := ProtocolMatch{IcmpType: -1, IcmpCode: -1}
+
+	protocols, err := ParseProtocols(policy.Protocol)
+	if err != nil {
+		return match, err
+	}
+	match.Protocols = protocols
+	single := len(protocols) == 1
+
+	if len(policy.IcmpType) != 0 {
+		if !single || (protocols[0] != ProtocolICMP && protocols[0] != ProtocolICMPv6) {
+			return match, strerror.CreateError("icmp type requires protocol icmp or icmpv6:" + policy.Protocol)
+		}
+		icmpType, err := ParseIcmpType(protocols[0], policy.IcmpType)
+		if err != nil {
+			return match, err
+		}
+		match.IcmpType = int(icmpType)
+	}
+
+	if len(policy.IcmpCode) != 0 {
+		if match.IcmpType < 0 {
+			return match, strerror.CreateError("icmp code requires icmp type:" + policy.IcmpCode)
+		}
+		icmpCode, err := strconv.ParseUint(strings.TrimSpace(policy.IcmpCode), 10, 8)
+		if err != nil {
+			return match, strerror.CreateError("icmp code error:" + policy.IcmpCode)
+		}
+		match.IcmpCode = int(icmpCode)
+	}
+
+	if len(policy.TcpFlags) != 0 {
+		if !single || protocols[0] != ProtocolTCP {
+			return match, strerror.CreateError("tcp flags requires protocol tcp:" + policy.Protocol)
+		}
+		match.TcpMask, match.TcpFlags, err = ParseTcpFlags(policy.TcpFlags)
+		if err != nil {
+			return match, err
+		}
+	}
+	return match, nil
+}
+
+// AddProtocolExpr 生成协议表达式，使用协议号，不依赖系统中的/etc/protocols
+// meta l4proto { 6, 17 } / meta l4proto 1 icmp type 8 icmp code 0 / meta l4proto 6 tcp flags & (syn|ack) == syn
+func AddProtocolExpr(policy model.Policy) (string, error) {
+	match, err := GetProtocolMatch(policy)
+	if err != nil {
+		return "", err
+	}
+	if len(match.Protocols) == 0 {
+		return "", nil
+	}
+
+	var protocols []string
+	for _, protocol := range match.Protocols {
+		protocols = append(protocols, strconv.Itoa(int(protocol)))
+	}
+	value := protocols[0]
+	if len(protocols) > 1 {
+		value = "{ " + strings.Join(protocols, comma+gap) + " }"
+	}
+	exprs, _ := AddSingleExpr(MetaIPProtocol, value)
+
+	typeMeta, codeMeta := MetaIcmpType, MetaIcmpCode
+	if match.Icmpv6() {
+		typeMeta, codeMeta = MetaIcmp6Type, MetaIcmp6Code
+	}
+	if match.IcmpType >= 0 {
+		expr, _ := AddSingleExpr(typeMeta, strconv.Itoa(match.IcmpType))
+		exprs += expr
+	}
+	if match.IcmpCode >= 0 {
+		expr, _ := AddSingleExpr(codeMeta, strconv.Itoa(match.IcmpCode))
+		exprs += expr
+	}
+
+	if match.TcpMask != 0 {
+		flags := "0"
+		if match.TcpFlags != 0 {
+			flags = strings.Join(TcpFlagNames(match.TcpFlags), "|")
+		}
+		expr, _ := AddSingleExpr(MetaTcpFlags, "& ("+strings.Join(TcpFlagNames(match.TcpMask), "|")+") == "+flags)
+		exprs += expr
+	}
+	return exprs, nil
+}
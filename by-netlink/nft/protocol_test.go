@@ -0,0 +1,54 @@
+package nft
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIcmpTypeName(t *testing.T) {
+	tests := []struct {
+		protocol uint8
+		icmpType uint8
+		want     string
+	}{
+		{ProtocolICMP, 8, "echo-request"},
+		{ProtocolICMP, 3, "destination-unreachable"},
+		{ProtocolICMP, 200, "200"},
+		{ProtocolICMPv6, 128, "echo-request"},
+		{ProtocolICMPv6, 1, "destination-unreachable"},
+		{ProtocolICMPv6, 8, "8"},
+	}
+
+	for _, tt := range tests {
+		// 名称与类型号一一对应，解析名称得到原来的类型号
+		for i := 0; i < 10; i++ {
+			if got := IcmpTypeName(tt.protocol, tt.icmpType); got != tt.want {
+				t.Fatalf("IcmpTypeName(%d, %d) = %s, want %s", tt.protocol, tt.icmpType, got, tt.want)
+			}
+		}
+		if parsed, err := ParseIcmpType(tt.protocol, tt.want); err != nil || parsed != tt.icmpType {
+			t.Fatalf("ParseIcmpType(%d, %s) = %d, %v, want %d", tt.protocol, tt.want, parsed, err, tt.icmpType)
+		}
+	}
+}
+
+func TestTcpFlagNames(t *testing.T) {
+	if got := TcpFlagNames(0); got != nil {
+		t.Fatalf("TcpFlagNames(0) = %v, want nil", got)
+	}
+	want := []string{"fin", "syn", "rst", "psh", "ack", "urg", "ecn", "cwr"}
+	if got := TcpFlagNames(0xff); !reflect.DeepEqual(got, want) {
+		t.Fatalf("TcpFlagNames(0xff) = %v, want %v", got, want)
+	}
+
+	tests := []string{"syn", "syn,!ack", "fin,syn,rst,ack", "!syn,!rst"}
+	for _, value := range tests {
+		mask, flags, err := ParseTcpFlags(value)
+		if err != nil {
+			t.Fatalf("ParseTcpFlags(%q) error: %v", value, err)
+		}
+		if got := FormatTcpFlags(mask, flags); got != value {
+			t.Fatalf("FormatTcpFlags(ParseTcpFlags(%q)) = %q", value, got)
+		}
+	}
+}
@@ -18,8 +18,8 @@ func main() {
 		SIp:      []string{"192.168.0.1"},
 		DIp:      []string{"192.168.0.1", "192.168.1.1-192.168.1.100", "192.168.2.1/24"}, // 四段式子网掩码不支持
 		Protocol: "tcp",
-		SPort:    20,
-		DPort:    30,
+		SPort:    model.Ports{"20"},
+		DPort:    model.Ports{"30", "8000-8100"},
 		LogTag:   "test-log1",
 		Action:   nft.ALLOW,
 	}
@@ -33,8 +33,8 @@ func main() {
 		SIp:      []string{"192.168.0.1"},
 		DIp:      []string{"192.168.0.1", "192.168.1.1-192.168.1.100", "192.168.2.1/24"},
 		Protocol: "tcp",
-		SPort:    20,
-		DPort:    30,
+		SPort:    model.Ports{"20"},
+		DPort:    model.Ports{"30", "8000-8100"},
 		Time:     []model.PolicyTime{{Hour: "18:00:00-19:00:00", Month: "1,10-15"}},
 		LogTag:   "test-log2",
 		Action:   nft.ALLOW,
@@ -49,8 +49,8 @@ func main() {
 		SIp:      []string{"192.168.0.1"},
 		DIp:      []string{"192.168.0.1", "192.168.1.1-192.168.1.100", "192.168.2.1/24"}, // 四段式子网掩码不支持
 		Protocol: "tcp",
-		SPort:    20,
-		DPort:    30,
+		SPort:    model.Ports{"20"},
+		DPort:    model.Ports{"30", "8000-8100"},
 		Time:     []model.PolicyTime{{Week: "0,1,2,3,4,5,6", Hour: "18:00:00-19:00:00"}},
 		LogTag:   "test-log3",
 		Action:   nft.ALLOW,
@@ -65,14 +65,26 @@ func main() {
 		SIp:      []string{"192.168.0.1"},
 		DIp:      []string{"192.168.0.1", "192.168.1.1-192.168.1.100", "192.168.2.1/24"}, // 四段式子网掩码不支持
 		Protocol: "tcp",
-		SPort:    20,
-		DPort:    30,
+		SPort:    model.Ports{"20"},
+		DPort:    model.Ports{"30", "8000-8100"},
 		Time:     []model.PolicyTime{{Day: "2022-11-22 18:00:00-2022-11-22 19:00:00"}},
 		LogTag:   "test-log3",
 		Action:   nft.ALLOW,
 	}
 	policys = append(policys, policy4)
 
+	// IPv4和IPv6混合的地址会拆分成两条规则
+	policy5 := model.Policy{
+		SRegion:  []string{"enp2s0"},
+		SIp:      []string{"192.168.0.1", "2001:db8::1"},
+		DIp:      []string{"192.168.2.1/24", "2001:db8:1::/64", "2001:db8:2::1-2001:db8:2::ff"},
+		Protocol: "tcp",
+		DPort:    model.Ports{"30"},
+		LogTag:   "test-log5",
+		Action:   nft.DROP,
+	}
+	policys = append(policys, policy5)
+
 	err := nftService.GeneratePolicyRule(policys)
 	if err != nil {
 		fmt.Println(err)
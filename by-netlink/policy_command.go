@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// policyCommand 根据策略ID管理策略
+func policyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "policy",
+		Usage: "根据策略ID管理策略",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "get",
+				Usage:     "查看策略对应的规则",
+				ArgsUsage: "<id>",
+				Action: func(cCtx *cli.Context) error {
+					identifier, err := newPolicyIdentifier(cCtx)
+					if err != nil {
+						return err
+					}
+
+					rules, err := identifier.GetPolicyRules(cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					for _, rule := range rules {
+						fmt.Printf("handle %d version %s: %s\n", rule.Handle, rule.Version, rule.Expr)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "查看已下发的策略，由内核规则还原",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+				},
+				Action: listPolicys,
+			},
+			policyStatsCommand(),
+			simulateCommand(),
+			lintCommand(),
+			{
+				Name:  "update",
+				Usage: "根据策略ID更新策略: policy update --id policy1 --sip 192.168.0.1 --action drop",
+				Flags: policyFlags,
+				Action: func(cCtx *cli.Context) error {
+					policy, err := parsePolicy(cCtx)
+					if err != nil {
+						return err
+					}
+					if len(policy.Id) == 0 {
+						return strerror.CreateError("policy id is required")
+					}
+
+					identifier, err := newPolicyIdentifier(cCtx)
+					if err != nil {
+						return err
+					}
+					if err := identifier.UpdatePolicy(policy); err != nil {
+						return err
+					}
+
+					_, err = newPolicyStore(cCtx).SavePolicy(policy)
+					return err
+				},
+			},
+			{
+				Name:      "del",
+				Usage:     "根据策略ID删除策略",
+				ArgsUsage: "<id>",
+				Action: func(cCtx *cli.Context) error {
+					identifier, err := newPolicyIdentifier(cCtx)
+					if err != nil {
+						return err
+					}
+					id := cCtx.Args().First()
+					if err := identifier.DeletePolicy(id); err != nil {
+						return err
+					}
+
+					// 策略文件之前没有保存该策略时只提示
+					if _, err := newPolicyStore(cCtx).DeletePolicy(id); err != nil {
+						fmt.Printf("policy store: %v\n", err)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// newPolicyIdentifier 按策略ID管理策略，目前只有netlink方式支持
+func newPolicyIdentifier(cCtx *cli.Context) (service.PolicyIdentifier, error) {
+	backend, err := newRuleBackend(cCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	identifier, ok := backend.(service.PolicyIdentifier)
+	if !ok {
+		return nil, strerror.CreateError("backend does not support policy id:" + cCtx.String("backend"))
+	}
+	return identifier, nil
+}
+
+// listPolicys 还原已下发的规则，所有下发方式共用内核中的同一条链，直接通过netlink读取
+func listPolicys(cCtx *cli.Context) error {
+	policyManager := service.PolicyManagerService{}
+	decoded, err := policyManager.DecodePolicys()
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		policys := make([]model.Policy, 0, len(decoded))
+		for _, d := range decoded {
+			policys = append(policys, d.Policy)
+		}
+		data, err := json.MarshalIndent(policys, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHANDLE\tACTION\tSRC\tDST\tPROTO\tSPORT\tDPORT\tAPP\tTIME\tCT\tLIMIT\tLOG")
+	for _, d := range decoded {
+		policy := d.Policy
+		var handles, times []string
+		for _, handle := range d.Handles {
+			handles = append(handles, strconv.FormatUint(handle, 10))
+		}
+		for _, t := range policy.Time {
+			times = append(times, strings.Trim(strings.Join([]string{t.Day, t.Hour, t.Week}, " "), " "))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			listValue(policy.Id), strings.Join(handles, ","), nft.ActionName(policy.Action),
+			listValue(strings.Join(append(append([]string{}, policy.SRegion...), policy.SIp...), ",")),
+			listValue(strings.Join(append(append([]string{}, policy.DRegion...), policy.DIp...), ",")),
+			listValue(policy.Protocol), listValue(strings.Join(policy.SPort, ",")), listValue(strings.Join(policy.DPort, ",")),
+			listValue(policy.App.Name), listValue(strings.Join(times, ";")), listValue(ctValue(policy)),
+			listValue(limitValue(policy)), listValue(policy.LogTag))
+		for _, unknown := range d.Unknown {
+			fmt.Fprintf(w, "\t\tunknown: %s\n", unknown)
+		}
+	}
+	return w.Flush()
+}
+
+// limitValue 限速和连接数限制 100/second,conn 20 per saddr
+func limitValue(policy model.Policy) string {
+	var values []string
+	if len(policy.Limit) != 0 {
+		values = append(values, policy.Limit)
+	}
+	if policy.ConnLimit != 0 {
+		values = append(values, fmt.Sprintf("conn %d", policy.ConnLimit))
+	}
+	value := strings.Join(values, ",")
+	if len(value) != 0 && len(policy.LimitPer) != 0 {
+		value += " per " + policy.LimitPer
+	}
+	return value
+}
+
+// ctValue 连接状态、方向和标记 established,related reply mark 0x00000010
+func ctValue(policy model.Policy) string {
+	var values []string
+	if len(policy.CtState) != 0 {
+		values = append(values, policy.CtState)
+	}
+	if len(policy.CtDirection) != 0 {
+		values = append(values, policy.CtDirection)
+	}
+	if len(policy.CtMark) != 0 {
+		values = append(values, "mark "+policy.CtMark)
+	}
+	return strings.Join(values, " ")
+}
+
+func listValue(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// policySourceFlags 策略来源
+var policySourceFlags = []cli.Flag{
+	&cli.StringFlag{Name: "file", Usage: "使用JSON策略数组文件中的策略: --file policys.json"},
+	&cli.BoolFlag{Name: "store-policys", Usage: "使用策略文件中保存的策略"},
+	&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+}
+
+// loadPolicys 待检查的策略，--file 指定JSON策略数组文件，--store-policys 使用策略文件中保存的策略，默认由内核中的规则还原
+func loadPolicys(cCtx *cli.Context) ([]model.Policy, error) {
+	if cCtx.IsSet("file") {
+		data, err := os.ReadFile(cCtx.String("file"))
+		if err != nil {
+			return nil, err
+		}
+		var policys []model.Policy
+		if err := json.Unmarshal(data, &policys); err != nil {
+			return nil, err
+		}
+		for _, policy := range policys {
+			if err := nft.CheckPorts(policy); err != nil {
+				return nil, err
+			}
+		}
+		return policys, nil
+	}
+
+	if cCtx.Bool("store-policys") {
+		snapshot, err := newPolicyStore(cCtx).Load()
+		if err != nil {
+			return nil, err
+		}
+		return snapshot.Policys, nil
+	}
+
+	policyManager := service.PolicyManagerService{}
+	decoded, err := policyManager.DecodePolicys()
+	if err != nil {
+		return nil, err
+	}
+	policys := make([]model.Policy, 0, len(decoded))
+	for _, d := range decoded {
+		policys = append(policys, d.Policy)
+	}
+	return policys, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ruleCommand 已下发的规则
+func ruleCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rule",
+		Usage: "已下发的规则",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "查看规则",
+				Action: func(cCtx *cli.Context) error {
+					backend, err := newRuleBackend(cCtx)
+					if err != nil {
+						return err
+					}
+
+					rules, err := backend.ListRules()
+					if err != nil {
+						return err
+					}
+					for _, rule := range rules {
+						fmt.Printf("handle %d policy %s version %s: %s\n", rule.Handle, rule.PolicyId, rule.Version, rule.Expr)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "del",
+				Usage:     "根据句柄删除规则",
+				ArgsUsage: "<handle>",
+				Action: func(cCtx *cli.Context) error {
+					handle, err := strconv.ParseUint(cCtx.Args().First(), 10, 64)
+					if err != nil {
+						return err
+					}
+
+					backend, err := newRuleBackend(cCtx)
+					if err != nil {
+						return err
+					}
+					return backend.DeleteRule(handle)
+				},
+			},
+			{
+				Name:  "flush",
+				Usage: "清空所有规则，保留连接跟踪规则",
+				Action: func(cCtx *cli.Context) error {
+					backend, err := newRuleBackend(cCtx)
+					if err != nil {
+						return err
+					}
+					return backend.FlushRules()
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/api"
+	"netvine.com/firewall/server/service"
+	"netvine.com/firewall/server/store"
+	tail_log "netvine.com/firewall/server/utils/log"
+)
+
+// serveCommand 策略管理HTTP服务
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "启动策略管理HTTP服务",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{Name: "listen", Value: "127.0.0.1:8080", EnvVars: []string{"FD_LISTEN"}, Usage: "监听地址: --listen 127.0.0.1:8080"},
+			&cli.DurationFlag{Name: "sample-interval", Value: 30 * time.Second, Usage: "命中统计的采样间隔，0表示不采样: --sample-interval 30s"},
+			&cli.BoolFlag{Name: "nflog", Usage: "读取NFLOG日志，保存告警和安全日志"},
+			&cli.UintFlag{Name: "nflog-group", Value: tail_log.DefaultGroup, Usage: "NFLOG组: --nflog-group 1"},
+			&cli.BoolFlag{Name: "watch-drift", Usage: "监视策略表的变化，与策略文件不一致时输出告警"},
+			&cli.BoolFlag{Name: "auto-heal", Usage: "与策略文件不一致时重新下发策略文件，需要 --watch-drift"},
+			&cli.DurationFlag{Name: "drift-settle", Value: service.DefaultDriftSettle, Usage: "最后一次变化之后等待多久再对比: --drift-settle 2s"},
+		}, exportFlags...),
+		Action: func(cCtx *cli.Context) error {
+			backend, err := newRuleBackend(cCtx)
+			if err != nil {
+				return err
+			}
+
+			server := api.NewServer(backend, newPolicyStore(cCtx), store.NewStatsStore(cCtx.String("stats")))
+			server.SampleInterval = cCtx.Duration("sample-interval")
+			server.Events = newEventLogService(cCtx)
+
+			exporters, err := newExporters(cCtx)
+			if err != nil {
+				return err
+			}
+			var sink tail_log.Sink = server.Events
+			if len(exporters) != 0 {
+				defer exporters.Close()
+				server.Audit = exporters
+				sink = tail_log.MultiSink{server.Events, exporters}
+			}
+			if cCtx.Bool("nflog") {
+				server.LogConsumer = service.NewLogConsumerService(uint16(cCtx.Uint("nflog-group")), sink)
+			}
+			if cCtx.Bool("watch-drift") {
+				server.WatchDrift = true
+				server.Drift.Heal = cCtx.Bool("auto-heal")
+				server.Drift.Settle = cCtx.Duration("drift-settle")
+				server.Drift.Sink = sink
+			}
+			return server.ListenAndServe(cCtx.String("listen"))
+		},
+	}
+}
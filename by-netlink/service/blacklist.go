@@ -0,0 +1,286 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// BlacklistService 通过netlink管理黑名单
+// 黑名单链挂在prerouting，优先级raw，在其他链之前丢弃，增删地址只修改集合元素，不修改规则
+type BlacklistService struct {
+	Nft   *nft.NfTables
+	Table *nftables.Table
+	Chain *nftables.Chain
+	sets  map[string]*nftables.Set
+}
+
+// discard 丢弃连接中未提交的消息，与PolicyManagerService一致
+func (b *BlacklistService) discard() {
+	if b.Nft != nil {
+		if err := b.Nft.NetNS.Close(); err != nil {
+			fmt.Printf("NetNS.Close() failed: %v\n", err)
+		}
+		b.Nft = nil
+	}
+}
+
+func (b *BlacklistService) rollback(err *error) {
+	if *err != nil {
+		metrics.NetlinkErrors.Inc("blacklist")
+		b.discard()
+	}
+}
+
+// init 创建表、黑名单集合和黑名单链
+func (b *BlacklistService) init() (err error) {
+	if b.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		b.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+	defer b.rollback(&err)
+
+	b.Table, err = b.Nft.CreateTableIfNotExist(nftables.TableFamilyINet, tableName)
+	if err != nil {
+		return err
+	}
+	// 表提交之后才能读取表中的集合
+	if err := b.Nft.Conn.Flush(); err != nil {
+		return err
+	}
+
+	b.sets, err = b.Nft.CreateSetsIfNotExist(b.Table, nft.GetBlacklistSets(b.Table))
+	if err != nil {
+		return err
+	}
+
+	var created bool
+	b.Chain, created, err = b.Nft.CreateBaseChainIfNotExist(b.Table, nftcmd.IpMacBlackListChain, nftables.ChainHookPrerouting, nftables.ChainPriorityRaw, nftables.ChainPolicyAccept)
+	if err != nil {
+		return err
+	}
+	upgraded, err := b.upgradeSets()
+	if err != nil {
+		return err
+	}
+	if created || upgraded {
+		for _, exprs := range nft.GetBlacklistRuleExprs(b.sets) {
+			b.Nft.Conn.AddRule(&nftables.Rule{Table: b.Table, Chain: b.Chain, Exprs: exprs})
+		}
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// upgradeSets 之前创建的集合没有timeout标志，元素不能设置超时时间
+// 集合被规则引用时不能删除，在同一批次中清空黑名单链，重建集合并保留原有地址，由init重新添加规则
+func (b *BlacklistService) upgradeSets() (bool, error) {
+	var upgrade []*nftables.Set
+	for _, set := range nft.GetBlacklistSets(b.Table) {
+		if !b.sets[set.Name].HasTimeout {
+			upgrade = append(upgrade, set)
+		}
+	}
+	if len(upgrade) == 0 {
+		return false, nil
+	}
+
+	b.Nft.Conn.FlushChain(b.Chain)
+	for _, set := range upgrade {
+		old := b.sets[set.Name]
+		elements, err := b.Nft.Conn.GetSetElements(old)
+		if err != nil {
+			return false, err
+		}
+		b.Nft.Conn.DelSet(old)
+		if err := b.Nft.Conn.AddSet(set, elements); err != nil {
+			return false, err
+		}
+		b.sets[set.Name] = set
+	}
+	return true, nil
+}
+
+// getBlacklistElements 地址按集合分组，重复的地址只保留一个
+func getBlacklistElements(values []string) (map[string][]nftables.SetElement, error) {
+	elements := make(map[string][]nftables.SetElement)
+	exist := make(map[string]bool)
+	for _, value := range values {
+		setName, element, normalized, err := nft.GetBlacklistElement(value)
+		if err != nil {
+			return nil, err
+		}
+		if !exist[normalized] {
+			exist[normalized] = true
+			elements[setName] = append(elements[setName], element)
+		}
+	}
+	return elements, nil
+}
+
+// ListBlacklist 查看黑名单，依次为IPv4、IPv6、MAC地址
+func (b *BlacklistService) ListBlacklist() ([]model.BlacklistEntry, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+
+	var entries []model.BlacklistEntry
+	for _, name := range []string{nft.BlacklistIp4Set, nft.BlacklistIp6Set, nft.BlacklistMacSet} {
+		set := b.sets[name]
+		elements, err := b.Nft.Conn.GetSetElements(set)
+		if err != nil {
+			return nil, err
+		}
+		timeouts, err := nft.GetSetElementTimeouts(b.Nft.NetNS, set)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, nft.GetBlacklistEntries(set, elements, timeouts)...)
+	}
+	return entries, nil
+}
+
+// CountBlacklist 按地址类型统计黑名单条目数，只读取不创建表和集合，不存在时为0，用于采集指标
+func (b *BlacklistService) CountBlacklist() (counts map[string]int, err error) {
+	if b.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		b.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+	defer b.rollback(&err)
+
+	counts = make(map[string]int)
+	for _, set := range nft.GetBlacklistSets(nil) {
+		counts[set.KeyType.Name] = 0
+	}
+
+	tables, err := b.Nft.Conn.ListTablesOfFamily(nftables.TableFamilyINet)
+	if err != nil {
+		return nil, err
+	}
+	var table *nftables.Table
+	for _, t := range tables {
+		if t.Name == tableName {
+			table = t
+		}
+	}
+	if table == nil {
+		return counts, nil
+	}
+
+	sets, err := b.Nft.Conn.GetSets(table)
+	if err != nil {
+		return nil, err
+	}
+	exist := make(map[string]*nftables.Set)
+	for _, set := range sets {
+		set.Table = table
+		exist[set.Name] = set
+	}
+	for _, blacklistSet := range nft.GetBlacklistSets(table) {
+		set, ok := exist[blacklistSet.Name]
+		if !ok {
+			continue
+		}
+		elements, err := b.Nft.Conn.GetSetElements(set)
+		if err != nil {
+			return nil, err
+		}
+		counts[blacklistSet.KeyType.Name] += len(elements)
+	}
+	return counts, nil
+}
+
+// AddBlacklist 增加地址，ttl大于0时到期后由内核删除，为0时永久封禁
+// 已经存在的地址按新的封禁时长重新添加，所有地址在一个netlink批次中提交
+func (b *BlacklistService) AddBlacklist(values []string, ttl time.Duration) (err error) {
+	if ttl != 0 && ttl < time.Second {
+		return strerror.CreateError("blacklist ttl error:" + ttl.String())
+	}
+	elements, err := getBlacklistElements(values)
+	if err != nil {
+		return err
+	}
+
+	// 内核不会更新已经存在的元素的超时时间，先删除再添加
+	entries, err := b.ListBlacklist()
+	if err != nil {
+		return err
+	}
+	exist := make(map[string]bool)
+	for _, entry := range entries {
+		exist[entry.Value] = true
+	}
+	replace := make(map[string][]nftables.SetElement)
+	for _, value := range values {
+		setName, element, normalized, _ := nft.GetBlacklistElement(value)
+		if exist[normalized] {
+			exist[normalized] = false
+			replace[setName] = append(replace[setName], element)
+		}
+	}
+	defer b.rollback(&err)
+
+	for name, setElements := range replace {
+		if err := b.Nft.Conn.SetDeleteElements(b.sets[name], setElements); err != nil {
+			return err
+		}
+	}
+	for name, setElements := range elements {
+		for i := range setElements {
+			setElements[i].Timeout = ttl
+		}
+		if err := b.Nft.Conn.SetAddElements(b.sets[name], setElements); err != nil {
+			return err
+		}
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// DeleteBlacklist 删除地址，地址不在黑名单中时返回CodeNotFound
+func (b *BlacklistService) DeleteBlacklist(values []string) (err error) {
+	elements, err := getBlacklistElements(values)
+	if err != nil {
+		return err
+	}
+
+	entries, err := b.ListBlacklist()
+	if err != nil {
+		return err
+	}
+	exist := make(map[string]bool)
+	for _, entry := range entries {
+		exist[entry.Value] = true
+	}
+	for _, value := range values {
+		_, _, normalized, _ := nft.GetBlacklistElement(value)
+		if !exist[normalized] {
+			return strerror.CreateCodeError(strerror.CodeNotFound, "blacklist address not found:"+value)
+		}
+	}
+	defer b.rollback(&err)
+
+	for name, setElements := range elements {
+		if err := b.Nft.Conn.SetDeleteElements(b.sets[name], setElements); err != nil {
+			return err
+		}
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// FlushBlacklist 清空黑名单，黑名单链保留
+func (b *BlacklistService) FlushBlacklist() (err error) {
+	if err := b.init(); err != nil {
+		return err
+	}
+	defer b.rollback(&err)
+
+	for _, set := range b.sets {
+		b.Nft.Conn.FlushSet(set)
+	}
+	return b.Nft.Conn.Flush()
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// ConntrackService 通过netlink设置策略链最前面的连接跟踪规则
+// 已建立连接的报文直接放行，无效状态的报文直接阻断，每个表单独设置
+type ConntrackService struct {
+	Nft   *nft.NfTables
+	Table *nftables.Table
+	Chain *nftables.Chain
+}
+
+// discard 丢弃连接中未提交的消息，与PolicyManagerService一致
+func (c *ConntrackService) discard() {
+	if c.Nft != nil {
+		if err := c.Nft.NetNS.Close(); err != nil {
+			fmt.Printf("NetNS.Close() failed: %v\n", err)
+		}
+		c.Nft = nil
+	}
+}
+
+func (c *ConntrackService) rollback(err *error) {
+	if *err != nil {
+		metrics.NetlinkErrors.Inc("conntrack")
+		c.discard()
+	}
+}
+
+// init 创建表和策略链，表名为空时使用策略表
+func (c *ConntrackService) init(name string) (err error) {
+	if c.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		c.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+	defer c.rollback(&err)
+
+	if len(name) == 0 {
+		name = tableName
+	}
+	c.Table, c.Chain, err = initPolicyChain(c.Nft, name)
+	if err != nil {
+		return err
+	}
+	return c.Nft.Conn.Flush()
+}
+
+// conntrackComments 链中连接跟踪规则的注释
+func conntrackComments(rules []*nftables.Rule) []string {
+	var comments []string
+	for _, rule := range rules {
+		if comment := nft.GetRuleComment(rule.UserData); nftcmd.IsConntrackComment(comment) {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+// getConntrackRules 按注释生成连接跟踪规则
+func getConntrackRules(table *nftables.Table, chain *nftables.Chain, comments []string) ([]*nftables.Rule, error) {
+	var rules []*nftables.Rule
+	for _, comment := range comments {
+		exprs, err := nft.GetConntrackRuleExprs(comment)
+		if err != nil {
+			return nil, err
+		}
+		if len(exprs) == 0 {
+			continue
+		}
+		rules = append(rules, &nftables.Rule{
+			Table:    table,
+			Chain:    chain,
+			Exprs:    exprs,
+			UserData: nft.GetCommentUserData(comment),
+		})
+	}
+	return rules, nil
+}
+
+// GetConfig 查看表中启用的连接跟踪规则
+func (c *ConntrackService) GetConfig(name string) (model.ConntrackConfig, error) {
+	if err := c.init(name); err != nil {
+		return model.ConntrackConfig{}, err
+	}
+
+	rules, err := c.Nft.Conn.GetRules(c.Table, c.Chain)
+	if err != nil {
+		return model.ConntrackConfig{}, err
+	}
+	config := nftcmd.GetConntrackConfig(conntrackComments(rules))
+	config.Table = c.Table.Name
+	return config, nil
+}
+
+// SetConfig 删除原有的连接跟踪规则，按配置插入到策略链最前面，在一个netlink批次中提交
+func (c *ConntrackService) SetConfig(config model.ConntrackConfig) (err error) {
+	if err := c.init(config.Table); err != nil {
+		return err
+	}
+	defer c.rollback(&err)
+
+	rules, err := c.Nft.Conn.GetRules(c.Table, c.Chain)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if !nftcmd.IsConntrackComment(nft.GetRuleComment(rule.UserData)) {
+			continue
+		}
+		err := c.Nft.Conn.DelRule(&nftables.Rule{Table: c.Table, Chain: c.Chain, Handle: rule.Handle})
+		if err != nil {
+			return err
+		}
+	}
+
+	ctRules, err := getConntrackRules(c.Table, c.Chain, nftcmd.ConntrackComments(config))
+	if err != nil {
+		return err
+	}
+	// 每条都插到链的最前面，所以倒序加入
+	for i := len(ctRules) - 1; i >= 0; i-- {
+		c.Nft.Conn.InsertRule(ctRules[i])
+	}
+
+	if err := c.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("SetConfig Flush() failed: %v\n", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
+	tail_log "netvine.com/firewall/server/utils/log"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// 规则与策略文件不一致的类型
+const (
+	DriftMissing    = "missing"    // 策略文件中的规则不在策略链中
+	DriftUnexpected = "unexpected" // 策略链中多出的规则
+	DriftReordered  = "reordered"  // 规则顺序不同
+)
+
+// DefaultDriftSettle 收到变化通知后等待这么久再对比，命令行下发策略后才保存策略文件
+const DefaultDriftSettle = 2 * time.Second
+
+// 一次检查最多记录的变化通知，flush ruleset 时每条规则都有一条通知
+const maxDriftChanges = 20
+
+// DriftService 监视策略表的变化，对比策略链和策略文件，不一致时输出告警，可以重新下发策略文件
+// 本进程提交的变化不检查，其他进程(nft命令、其他fd-cmd)的变化等待Settle后对比
+type DriftService struct {
+	Backend RuleBackend
+	Store   *store.PolicyStore
+	Heal    bool          // 不一致时重新下发策略文件
+	Settle  time.Duration // 最后一次变化之后等待的时间
+	Sink    tail_log.Sink // 不为空时不一致的规则作为告警输出
+	Manager *PolicyManagerService
+}
+
+func NewDriftService(backend RuleBackend, policyStore *store.PolicyStore) *DriftService {
+	return &DriftService{Backend: backend, Store: policyStore, Settle: DefaultDriftSettle, Manager: &PolicyManagerService{}}
+}
+
+// Drifted 策略链与策略文件是否不一致
+func Drifted(report model.DriftReport) bool {
+	return len(report.Missing) != 0 || len(report.Unexpected) != 0 || report.Reordered
+}
+
+// compareRules 按注释对比，与ReconcilePolicys一致只对比本程序下发的策略规则
+// 连接跟踪规则和其他软件的规则不参与对比，重新下发时也不会删除
+// 同一个注释的规则按出现的次数匹配，按地址族拆分的策略有多条相同注释的规则
+func compareRules(report *model.DriftReport, desired []desiredRule, rules []model.RuleInfo) {
+	desiredCount := make(map[string]int)
+	for _, rule := range desired {
+		desiredCount[rule.comment]++
+	}
+
+	var current []string
+	matchedCount := make(map[string]int)
+	for _, rule := range rules {
+		if !nftcmd.IsRuleComment(rule.Comment) {
+			continue
+		}
+		if desiredCount[rule.Comment] == 0 {
+			report.Unexpected = append(report.Unexpected,
+				model.DriftRule{Comment: rule.Comment, PolicyId: rule.PolicyId, Version: rule.Version, Handle: rule.Handle})
+			continue
+		}
+		desiredCount[rule.Comment]--
+		matchedCount[rule.Comment]++
+		current = append(current, rule.Comment)
+	}
+
+	var expected []string
+	for _, rule := range desired {
+		if matchedCount[rule.comment] == 0 {
+			id, version := nftcmd.ParseRuleComment(rule.comment)
+			report.Missing = append(report.Missing, model.DriftRule{Comment: rule.comment, PolicyId: id, Version: version})
+			continue
+		}
+		matchedCount[rule.comment]--
+		expected = append(expected, rule.comment)
+	}
+
+	// 两边都存在的规则顺序不同
+	for i := range current {
+		if current[i] != expected[i] {
+			report.Reordered = true
+			break
+		}
+	}
+}
+
+// Check 对比策略链和策略文件，heal 为true且不一致时重新下发策略文件
+// 策略文件不存在时返回CodeNotFound，避免把策略链当作多余的规则清空
+func (d *DriftService) Check(heal bool) (model.DriftReport, error) {
+	report := model.DriftReport{Time: time.Now()}
+	if !d.Store.Exist() {
+		return report, strerror.CreateCodeError(strerror.CodeNotFound, "policy store not exist:"+d.Store.Path)
+	}
+
+	snapshot, err := d.Store.Load()
+	if err != nil {
+		return report, err
+	}
+	report.StoreVersion = snapshot.Version
+
+	desired, err := getDesiredRules(snapshot.Policys)
+	if err != nil {
+		return report, err
+	}
+	rules, err := d.Backend.ListRules()
+	if err != nil {
+		return report, err
+	}
+	compareRules(&report, desired, rules)
+
+	if !heal || !Drifted(report) {
+		return report, nil
+	}
+	err = SyncPolicys(d.Backend, snapshot.Policys)
+	metrics.DriftHeals.Inc(metrics.Result(err))
+	if err != nil {
+		return report, err
+	}
+	report.Healed = true
+	return report, nil
+}
+
+// policyChanges 策略表的变化通知
+func policyChanges(generation nft.Generation) []string {
+	var changes []string
+	for _, change := range generation.Changes {
+		if change.Table == tableName {
+			changes = append(changes, change.String())
+		}
+	}
+	return changes
+}
+
+// ownTask 提交变化的线程是否属于本进程，内核上报的是线程ID
+func ownTask(pid uint32) bool {
+	if pid == 0 {
+		return false
+	}
+	_, err := os.Stat("/proc/self/task/" + strconv.FormatUint(uint64(pid), 10))
+	return err == nil
+}
+
+// Run 订阅规则集变化并在变化后对比，启动时先对比一次，直到 stop 关闭
+// mu 用于与其他使用netlink连接的操作串行执行，本进程修改规则和保存策略文件之间不会对比
+func (d *DriftService) Run(mu sync.Locker, stop <-chan struct{}) error {
+	if err := d.Manager.InitNft(false); err != nil {
+		return err
+	}
+	// 与策略链使用同一个network namespace
+	monitor, err := nft.DialMonitor(int(d.Manager.Nft.NetNS))
+	if err != nil {
+		return err
+	}
+	defer monitor.Close()
+
+	// 停止监视期间的变化没有通知
+	d.check(mu, model.DriftReport{})
+
+	var pending model.DriftReport
+	var deadline time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// 定期超时，检查是否需要退出和对比
+		if err := monitor.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+		generations, err := monitor.Receive()
+		var netErr net.Error
+		switch {
+		case err == nil:
+		case errors.As(err, &netErr) && netErr.Timeout():
+		case errors.Is(err, unix.ENOBUFS):
+			// 接收缓冲区满时内核丢弃通知，不知道变化了什么，直接对比
+			metrics.NetlinkErrors.Inc("monitor")
+			fmt.Printf("nftables monitor overrun, notifications lost\n")
+			monitor.Reset()
+			deadline = time.Now().Add(d.Settle)
+		default:
+			return err
+		}
+
+		for _, generation := range generations {
+			changes := policyChanges(generation)
+			if len(changes) == 0 || ownTask(generation.Pid) {
+				continue
+			}
+			for _, change := range changes {
+				if len(pending.Changes) < maxDriftChanges {
+					pending.Changes = append(pending.Changes, change)
+				}
+			}
+			pending.ProcName, pending.Pid = generation.ProcName, generation.Pid
+			deadline = time.Now().Add(d.Settle)
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			d.check(mu, pending)
+			pending, deadline = model.DriftReport{}, time.Time{}
+		}
+	}
+}
+
+// check 对比一次并输出结果，trigger 是触发对比的变化
+func (d *DriftService) check(mu sync.Locker, trigger model.DriftReport) {
+	mu.Lock()
+	report, err := d.Check(d.Heal)
+	mu.Unlock()
+	if err != nil {
+		fmt.Printf("drift check failed: %v\n", err)
+	}
+	// 重新下发失败时仍然输出不一致的规则
+	if !Drifted(report) {
+		return
+	}
+
+	report.Changes, report.ProcName, report.Pid = trigger.Changes, trigger.ProcName, trigger.Pid
+	d.report(report)
+}
+
+// report 输出不一致的规则，每条规则一个告警事件
+func (d *DriftService) report(report model.DriftReport) {
+	fmt.Printf("drift: store version %d, missing=%d unexpected=%d reordered=%v healed=%v",
+		report.StoreVersion, len(report.Missing), len(report.Unexpected), report.Reordered, report.Healed)
+	if len(report.ProcName) != 0 {
+		fmt.Printf(" by %s(%d)", report.ProcName, report.Pid)
+	}
+	fmt.Println()
+	for _, change := range report.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+
+	var events []model.LogEvent
+	for _, rule := range report.Missing {
+		events = append(events, driftEvent(report, DriftMissing, rule))
+	}
+	for _, rule := range report.Unexpected {
+		events = append(events, driftEvent(report, DriftUnexpected, rule))
+	}
+	if report.Reordered {
+		events = append(events, driftEvent(report, DriftReordered, model.DriftRule{}))
+	}
+
+	for _, event := range events {
+		metrics.DriftEvents.Inc(event.LogTag)
+		if d.Sink == nil {
+			continue
+		}
+		if err := d.Sink.Write(event); err != nil {
+			fmt.Printf("drift sink write failed: %v\n", err)
+		}
+	}
+}
+
+// driftEvent 不一致的规则作为告警，LogTag为不一致的类型，Prefix为规则注释
+func driftEvent(report model.DriftReport, kind string, rule model.DriftRule) model.LogEvent {
+	return model.LogEvent{
+		Time:     report.Time,
+		Prefix:   rule.Comment,
+		LogTag:   kind,
+		Warn:     true,
+		Source:   LogSourceDrift,
+		PolicyId: rule.PolicyId,
+		Version:  rule.Version,
+	}
+}
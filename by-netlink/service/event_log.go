@@ -0,0 +1,68 @@
+package service
+
+import (
+	"path/filepath"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 事件的存储位置，日志前缀带有 #W 的事件是告警，带有 @L 的事件是安全日志，两者都有时分别保存
+const (
+	EventKindAlarm = "alarm"
+	EventKindLog   = "log"
+)
+
+// EventLogService 保存和查询告警、安全日志，实现 tail_log.Sink
+type EventLogService struct {
+	Alarms *store.EventStore
+	Logs   *store.EventStore
+}
+
+// NewEventLogService 告警保存在 <dir>/alarm，安全日志保存在 <dir>/log
+func NewEventLogService(dir string, retention time.Duration) *EventLogService {
+	if len(dir) == 0 {
+		dir = store.DefaultEventDir
+	}
+	return &EventLogService{
+		Alarms: store.NewEventStore(filepath.Join(dir, EventKindAlarm), retention),
+		Logs:   store.NewEventStore(filepath.Join(dir, EventKindLog), retention),
+	}
+}
+
+// Write 按告警和日志开关保存事件，都没有的事件不保存
+func (e *EventLogService) Write(event model.LogEvent) error {
+	if event.Warn {
+		if err := e.Alarms.Append(event); err != nil {
+			return err
+		}
+	}
+	if event.Log {
+		if err := e.Logs.Append(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query 查询告警或者安全日志
+func (e *EventLogService) Query(kind string, query model.EventQuery) ([]model.LogEvent, error) {
+	switch kind {
+	case EventKindAlarm:
+		return e.Alarms.Query(query)
+	case EventKindLog:
+		return e.Logs.Query(query)
+	}
+	return nil, strerror.CreateError("event kind error:" + kind)
+}
+
+// Close 关闭正在写入的段文件
+func (e *EventLogService) Close() error {
+	err := e.Alarms.Close()
+	if logErr := e.Logs.Close(); err == nil {
+		err = logErr
+	}
+	return err
+}
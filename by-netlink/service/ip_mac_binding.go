@@ -0,0 +1,279 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// IpMacBindingService 通过netlink管理IP-MAC绑定
+// 绑定保存在策略表的命名集合中，绑定链挂在prerouting，转发和本机的报文都会检查
+type IpMacBindingService struct {
+	Nft   *nft.NfTables
+	Table *nftables.Table
+	Chain *nftables.Chain
+	sets  map[string]*nftables.Set
+}
+
+// discard 丢弃连接中未提交的消息，与PolicyManagerService一致
+func (b *IpMacBindingService) discard() {
+	if b.Nft != nil {
+		if err := b.Nft.NetNS.Close(); err != nil {
+			fmt.Printf("NetNS.Close() failed: %v\n", err)
+		}
+		b.Nft = nil
+	}
+}
+
+func (b *IpMacBindingService) rollback(err *error) {
+	if *err != nil {
+		metrics.NetlinkErrors.Inc("binding")
+		b.discard()
+	}
+}
+
+// init 创建表、绑定链和集合，新建的绑定链默认阻断
+func (b *IpMacBindingService) init() (err error) {
+	if b.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		b.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+	defer b.rollback(&err)
+
+	b.Table, err = b.Nft.CreateTableIfNotExist(nftables.TableFamilyINet, tableName)
+	if err != nil {
+		return err
+	}
+	// 表提交之后才能读取表中的集合
+	if err := b.Nft.Conn.Flush(); err != nil {
+		return err
+	}
+
+	b.sets, err = b.Nft.CreateSetsIfNotExist(b.Table, nft.GetBindingSets(b.Table))
+	if err != nil {
+		return err
+	}
+
+	var created bool
+	b.Chain, created, err = b.Nft.CreateBaseChainIfNotExist(b.Table, nftcmd.IpMacBindingChain, nftables.ChainHookPrerouting, nftables.ChainPriorityFilter, nftables.ChainPolicyAccept)
+	if err != nil {
+		return err
+	}
+	if created {
+		b.addRules(nftcmd.DROP)
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// addRules 绑定链中IPv4和IPv6各一条规则
+func (b *IpMacBindingService) addRules(action int) {
+	b.Nft.Conn.AddRule(&nftables.Rule{Table: b.Table, Chain: b.Chain,
+		Exprs: nft.GetBindingRuleExprs(b.sets[nft.BindingIp4Set], b.sets[nft.BindingPair4Set], false, action)})
+	b.Nft.Conn.AddRule(&nftables.Rule{Table: b.Table, Chain: b.Chain,
+		Exprs: nft.GetBindingRuleExprs(b.sets[nft.BindingIp6Set], b.sets[nft.BindingPair6Set], true, action)})
+}
+
+// listBindings 读取当前的绑定
+func (b *IpMacBindingService) listBindings() ([]model.IpMacBinding, error) {
+	var bindings []model.IpMacBinding
+	for _, pair := range []struct {
+		name  string
+		ipLen int
+	}{{nft.BindingPair4Set, net.IPv4len}, {nft.BindingPair6Set, net.IPv6len}} {
+		elements, err := b.Nft.Conn.GetSetElements(b.sets[pair.name])
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, nft.GetBindings(pair.ipLen, elements)...)
+	}
+	return bindings, nil
+}
+
+// GetConfig 查看绑定链的动作和所有绑定
+func (b *IpMacBindingService) GetConfig() (model.IpMacBindingConfig, error) {
+	var config model.IpMacBindingConfig
+	if err := b.init(); err != nil {
+		return config, err
+	}
+
+	rules, err := b.Nft.Conn.GetRules(b.Table, b.Chain)
+	if err != nil {
+		return config, err
+	}
+	config.Action = nft.GetBindingAction(rules)
+
+	config.Bindings, err = b.listBindings()
+	return config, err
+}
+
+// SetAction 设置MAC不一致时的动作，告警或者阻断
+func (b *IpMacBindingService) SetAction(action int) (err error) {
+	if action != nftcmd.WARN && action != nftcmd.DROP {
+		return strerror.CreateError("binding action must be warn or drop:" + nftcmd.ActionName(action))
+	}
+	if err := b.init(); err != nil {
+		return err
+	}
+	defer b.rollback(&err)
+
+	b.Nft.Conn.FlushChain(b.Chain)
+	b.addRules(action)
+	return b.Nft.Conn.Flush()
+}
+
+// AddBindings 增加绑定，地址已经绑定其他MAC时替换，replace时先清空已有的绑定
+// 所有修改在一个netlink批次中提交
+func (b *IpMacBindingService) AddBindings(bindings []model.IpMacBinding, replace bool) (err error) {
+	// 同一个地址以最后一条为准
+	index := make(map[string]int)
+	var unique []model.IpMacBinding
+	for _, binding := range bindings {
+		ip, mac, err := nft.ParseBinding(binding)
+		if err != nil {
+			return err
+		}
+		binding = model.IpMacBinding{Ip: ip.String(), Mac: mac.String()}
+		if i, ok := index[ip.String()]; ok {
+			unique[i] = binding
+			continue
+		}
+		index[ip.String()] = len(unique)
+		unique = append(unique, binding)
+	}
+
+	if err := b.init(); err != nil {
+		return err
+	}
+	defer b.rollback(&err)
+
+	current, err := b.listBindings()
+	if err != nil {
+		return err
+	}
+
+	var stale []model.IpMacBinding
+	if replace {
+		for _, set := range b.sets {
+			b.Nft.Conn.FlushSet(set)
+		}
+	} else {
+		for _, binding := range current {
+			if i, ok := index[binding.Ip]; ok && unique[i].Mac != binding.Mac {
+				stale = append(stale, binding)
+			}
+		}
+	}
+
+	if err := b.setElements(stale, b.Nft.Conn.SetDeleteElements); err != nil {
+		return err
+	}
+	if err := b.setElements(unique, b.Nft.Conn.SetAddElements); err != nil {
+		return err
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// DeleteBindings 根据地址删除绑定，地址没有绑定时返回CodeNotFound
+func (b *IpMacBindingService) DeleteBindings(ips []string) (err error) {
+	if err := b.init(); err != nil {
+		return err
+	}
+	defer b.rollback(&err)
+
+	current, err := b.listBindings()
+	if err != nil {
+		return err
+	}
+	bound := make(map[string]model.IpMacBinding)
+	for _, binding := range current {
+		bound[binding.Ip] = binding
+	}
+
+	var bindings []model.IpMacBinding
+	for _, value := range ips {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return strerror.CreateError("binding ip error:" + value)
+		}
+		binding, ok := bound[ip.String()]
+		if !ok {
+			return strerror.CreateCodeError(strerror.CodeNotFound, "binding not found:"+value)
+		}
+		bindings = append(bindings, binding)
+	}
+
+	if err := b.setElements(bindings, b.Nft.Conn.SetDeleteElements); err != nil {
+		return err
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// FlushBindings 清空所有绑定，绑定链保留
+func (b *IpMacBindingService) FlushBindings() (err error) {
+	if err := b.init(); err != nil {
+		return err
+	}
+	defer b.rollback(&err)
+
+	for _, set := range b.sets {
+		b.Nft.Conn.FlushSet(set)
+	}
+	return b.Nft.Conn.Flush()
+}
+
+// setElements 绑定转换为集合元素后增加或者删除
+func (b *IpMacBindingService) setElements(bindings []model.IpMacBinding, apply func(*nftables.Set, []nftables.SetElement) error) error {
+	elements, err := nft.GetBindingElements(bindings)
+	if err != nil {
+		return err
+	}
+	for name, setElements := range elements {
+		if err := apply(b.sets[name], setElements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseBindingCSV 解析CSV绑定文件，每行 地址,MAC，支持#注释和表头
+func ParseBindingCSV(r io.Reader) ([]model.IpMacBinding, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var bindings []model.IpMacBinding
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, strerror.CreateError("binding csv error: " + err.Error())
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 {
+			return nil, strerror.CreateError(fmt.Sprintf("binding csv line %d: ip,mac required", line))
+		}
+
+		binding := model.IpMacBinding{Ip: strings.TrimSpace(record[0]), Mac: strings.TrimSpace(record[1])}
+		if first && net.ParseIP(binding.Ip) == nil {
+			// 表头
+			continue
+		}
+		if _, _, err := nft.ParseBinding(binding); err != nil {
+			return nil, strerror.CreateError(fmt.Sprintf("binding csv line %d: %v", line, err))
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	tail_log "netvine.com/firewall/server/utils/log"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// 日志事件的来源
+const (
+	LogSourcePolicy    = "policy"
+	LogSourceBlacklist = "blacklist"
+	LogSourceBinding   = "binding"
+	LogSourceDrift     = "drift" // 策略链与策略文件不一致，由DriftService输出
+)
+
+// 每隔这么久重新读取一次策略链，策略更新、删除后日志前缀对应的策略随之变化
+const logTagRefreshInterval = 10 * time.Second
+
+// logTagPolicy 日志前缀对应的策略
+type logTagPolicy struct {
+	PolicyId string
+	Version  string
+	Action   string
+}
+
+// LogConsumerService 读取NFLOG组中的报文，解析日志前缀和报文头，输出日志事件
+// 内核不上报命中的规则，策略ID根据日志前缀在策略链中查找，多条策略使用同一个前缀时取第一条
+type LogConsumerService struct {
+	Group     uint16
+	CopyRange uint32
+	Sink      tail_log.Sink
+	Manager   *PolicyManagerService
+
+	tags      map[string]logTagPolicy
+	refreshed time.Time
+	ifNames   map[uint32]string
+}
+
+func NewLogConsumerService(group uint16, sink tail_log.Sink) *LogConsumerService {
+	return &LogConsumerService{Group: group, CopyRange: tail_log.DefaultCopyRange, Sink: sink, Manager: &PolicyManagerService{}}
+}
+
+// Run 绑定NFLOG组并持续输出事件，直到 stop 关闭
+func (l *LogConsumerService) Run(stop <-chan struct{}) error {
+	if err := l.Manager.InitNft(false); err != nil {
+		return err
+	}
+	// 与策略链使用同一个network namespace
+	conn, err := tail_log.Dial(l.Group, l.CopyRange, int(l.Manager.Nft.NetNS))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// 定期超时，检查是否需要退出
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+		packets, err := conn.Receive()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			// 接收缓冲区满时内核丢弃报文，继续读取
+			if errors.Is(err, unix.ENOBUFS) {
+				metrics.NetlinkErrors.Inc("nflog")
+				fmt.Printf("nflog receive overrun, events lost\n")
+				continue
+			}
+			return err
+		}
+
+		for _, packet := range packets {
+			event := l.Event(packet)
+			metrics.LogEvents.Inc(metricValue(event.Source), metricValue(event.Action))
+			if err := l.Sink.Write(event); err != nil {
+				fmt.Printf("nflog sink write failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// Event 将NFLOG报文转换为日志事件，报文头无法解析时只输出前缀和接口
+func (l *LogConsumerService) Event(packet tail_log.Packet) model.LogEvent {
+	event := model.LogEvent{Time: packet.Time, Prefix: packet.Prefix}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.LogTag, event.Warn, event.Log = nftcmd.ParseLogPrefix(packet.Prefix)
+	l.resolve(&event)
+
+	event.IIfName = l.ifName(packet.InDev)
+	event.OIfName = l.ifName(packet.OutDev)
+	event.SMac, event.DMac = packet.Macs()
+
+	header, err := tail_log.DecodeHeader(packet.Payload)
+	if err != nil {
+		return event
+	}
+	event.SIp, event.DIp = header.SIp.String(), header.DIp.String()
+	event.Protocol = nftcmd.ProtocolName(header.Protocol)
+	event.SPort, event.DPort = int(header.SPort), int(header.DPort)
+	event.Length = header.Length
+	return event
+}
+
+// resolve 根据日志前缀填充事件来源、策略和动作
+func (l *LogConsumerService) resolve(event *model.LogEvent) {
+	switch event.LogTag {
+	case nft.BlacklistLogTag:
+		event.Source, event.Action = LogSourceBlacklist, nftcmd.ActionName(nftcmd.DROP)
+		return
+	case nft.BindingLogTag:
+		event.Source, event.Action = LogSourceBinding, nftcmd.ActionName(nftcmd.DROP)
+		if event.Warn {
+			event.Action = nftcmd.ActionName(nftcmd.WARN)
+		}
+		return
+	}
+
+	// 命中缓存时也定期刷新，否则策略更新后一直使用旧的策略ID和版本
+	if time.Since(l.refreshed) > logTagRefreshInterval {
+		if err := l.refreshTags(); err != nil {
+			fmt.Printf("nflog refresh policys failed: %v\n", err)
+		}
+	}
+	policy, ok := l.tags[event.Prefix]
+	if !ok {
+		if event.Warn {
+			event.Action = nftcmd.ActionName(nftcmd.WARN)
+		}
+		return
+	}
+	event.Source = LogSourcePolicy
+	event.PolicyId, event.Version, event.Action = policy.PolicyId, policy.Version, policy.Action
+}
+
+// refreshTags 读取策略链，记录每个日志前缀对应的第一条策略
+func (l *LogConsumerService) refreshTags() error {
+	l.refreshed = time.Now()
+	decoded, err := l.Manager.DecodePolicys()
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]logTagPolicy)
+	for _, d := range decoded {
+		prefix := nftcmd.LogPrefix(d.Policy)
+		if _, ok := tags[prefix]; ok || len(prefix) == 0 {
+			continue
+		}
+		tags[prefix] = logTagPolicy{PolicyId: d.Policy.Id, Version: d.Version, Action: nftcmd.ActionName(d.Policy.Action)}
+	}
+	l.tags = tags
+	return nil
+}
+
+// metricValue 无法识别的来源和动作使用 unknown
+func metricValue(value string) string {
+	if len(value) == 0 {
+		return "unknown"
+	}
+	return value
+}
+
+// ifName 接口序号转换为名称，接口不存在时使用序号
+func (l *LogConsumerService) ifName(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+	if name, ok := l.ifNames[index]; ok {
+		return name
+	}
+
+	name := strconv.FormatUint(uint64(index), 10)
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		name = iface.Name
+	}
+	if l.ifNames == nil {
+		l.ifNames = make(map[uint32]string)
+	}
+	l.ifNames[index] = name
+	return name
+}
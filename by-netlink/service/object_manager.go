@@ -0,0 +1,303 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/metrics"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// ObjectManagerService 通过netlink管理表、链、集合
+type ObjectManagerService struct {
+	Nft *nft.NfTables
+}
+
+func (o *ObjectManagerService) initNft() {
+	if o.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		o.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+}
+
+// discard 丢弃连接中未提交的消息，与PolicyManagerService一致
+func (o *ObjectManagerService) discard() {
+	if o.Nft != nil {
+		if err := o.Nft.NetNS.Close(); err != nil {
+			fmt.Printf("NetNS.Close() failed: %v\n", err)
+		}
+		o.Nft = nil
+	}
+}
+
+// flush 提交当前批次，失败时丢弃连接
+func (o *ObjectManagerService) flush() error {
+	if err := o.Nft.Conn.Flush(); err != nil {
+		metrics.NetlinkErrors.Inc("object")
+		o.discard()
+		return err
+	}
+	return nil
+}
+
+// getTable 查找表，不存在时返回CodeNotFound
+func (o *ObjectManagerService) getTable(family string, name string) (*nftables.Table, error) {
+	o.initNft()
+
+	tableFamily, err := nft.ParseFamily(family)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := o.Nft.Conn.ListTablesOfFamily(tableFamily)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if table.Name == name {
+			return table, nil
+		}
+	}
+	return nil, strerror.CreateCodeError(strerror.CodeNotFound, "table not found:"+family+" "+name)
+}
+
+// ListTables 查看所有表
+func (o *ObjectManagerService) ListTables() ([]model.TableInfo, error) {
+	o.initNft()
+
+	tables, err := o.Nft.Conn.ListTables()
+	if err != nil {
+		return nil, err
+	}
+
+	var tableInfos []model.TableInfo
+	for _, table := range tables {
+		tableInfos = append(tableInfos, model.TableInfo{Family: nft.FamilyName(table.Family), Name: table.Name})
+	}
+	return tableInfos, nil
+}
+
+// AddTable 创建表，已存在时返回CodeConflict
+func (o *ObjectManagerService) AddTable(info model.TableInfo) error {
+	if _, err := o.getTable(info.Family, info.Name); err == nil {
+		return strerror.CreateCodeError(strerror.CodeConflict, "table exist:"+info.Family+" "+info.Name)
+	} else if strerror.GetErrorCode(err) != strerror.CodeNotFound {
+		return err
+	}
+
+	family, _ := nft.ParseFamily(info.Family)
+	o.Nft.Conn.AddTable(&nftables.Table{Family: family, Name: info.Name})
+	return o.flush()
+}
+
+// DeleteTable 删除表以及表中的链、集合、规则
+func (o *ObjectManagerService) DeleteTable(family string, name string) error {
+	table, err := o.getTable(family, name)
+	if err != nil {
+		return err
+	}
+
+	o.Nft.Conn.DelTable(table)
+	return o.flush()
+}
+
+// getChain 查找链，不存在时返回CodeNotFound
+func (o *ObjectManagerService) getChain(family string, tableName string, name string) (*nftables.Chain, error) {
+	table, err := o.getTable(family, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := o.Nft.Conn.ListChainsOfTableFamily(table.Family)
+	if err != nil {
+		return nil, err
+	}
+	for _, chain := range chains {
+		if chain.Table.Name == table.Name && chain.Name == name {
+			chain.Table = table
+			return chain, nil
+		}
+	}
+	return nil, strerror.CreateCodeError(strerror.CodeNotFound, "chain not found:"+family+" "+tableName+" "+name)
+}
+
+// ListChains 查看所有链
+func (o *ObjectManagerService) ListChains() ([]model.ChainInfo, error) {
+	o.initNft()
+
+	chains, err := o.Nft.Conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+
+	var chainInfos []model.ChainInfo
+	for _, chain := range chains {
+		chainInfo := model.ChainInfo{
+			Family: nft.FamilyName(chain.Table.Family),
+			Table:  chain.Table.Name,
+			Name:   chain.Name,
+			Type:   string(chain.Type),
+			Hook:   nft.HookName(chain.Table.Family, chain.Hooknum),
+			Policy: nft.ChainPolicyName(chain.Policy),
+		}
+		if chain.Priority != nil {
+			chainInfo.Priority = int(*chain.Priority)
+		}
+		chainInfos = append(chainInfos, chainInfo)
+	}
+	return chainInfos, nil
+}
+
+// AddChain 创建链，Hook为空时创建普通链
+func (o *ObjectManagerService) AddChain(info model.ChainInfo) error {
+	table, err := o.getTable(info.Family, info.Table)
+	if err != nil {
+		return err
+	}
+
+	if _, err := o.getChain(info.Family, info.Table, info.Name); err == nil {
+		return strerror.CreateCodeError(strerror.CodeConflict, "chain exist:"+info.Family+" "+info.Table+" "+info.Name)
+	} else if strerror.GetErrorCode(err) != strerror.CodeNotFound {
+		return err
+	}
+
+	chain := &nftables.Chain{Name: info.Name, Table: table}
+
+	hook, err := nft.ParseHook(info.Hook)
+	if err != nil {
+		return err
+	}
+	if hook != nil {
+		policy, err := nft.ParseChainPolicy(info.Policy)
+		if err != nil {
+			return err
+		}
+
+		chainType := nftables.ChainTypeFilter
+		if len(info.Type) != 0 {
+			chainType = nftables.ChainType(info.Type)
+		}
+
+		chain.Hooknum = hook
+		chain.Priority = nftables.ChainPriorityRef(nftables.ChainPriority(info.Priority))
+		chain.Type = chainType
+		chain.Policy = policy
+	}
+
+	o.Nft.Conn.AddChain(chain)
+	return o.flush()
+}
+
+// DeleteChain 删除链，链中有规则时先清空
+func (o *ObjectManagerService) DeleteChain(family string, tableName string, name string) error {
+	chain, err := o.getChain(family, tableName, name)
+	if err != nil {
+		return err
+	}
+
+	o.Nft.Conn.FlushChain(chain)
+	o.Nft.Conn.DelChain(chain)
+	return o.flush()
+}
+
+// getSet 查找集合，不存在时返回CodeNotFound
+func (o *ObjectManagerService) getSet(family string, tableName string, name string) (*nftables.Set, error) {
+	table, err := o.getTable(family, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := o.Nft.Conn.GetSetByName(table, name)
+	if err != nil {
+		return nil, strerror.CreateCodeError(strerror.CodeNotFound, "set not found:"+family+" "+tableName+" "+name)
+	}
+	set.Table = table
+	return set, nil
+}
+
+// ListSets 查看表中的命名集合以及元素
+func (o *ObjectManagerService) ListSets(family string, tableName string) ([]model.SetInfo, error) {
+	table, err := o.getTable(family, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := o.Nft.Conn.GetSets(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var setInfos []model.SetInfo
+	for _, set := range sets {
+		if set.Anonymous {
+			continue
+		}
+		set.Table = table
+
+		elements, err := o.Nft.Conn.GetSetElements(set)
+		if err != nil {
+			fmt.Printf("GetSetElements %s failed: %v\n", set.Name, err)
+		}
+		setInfos = append(setInfos, model.SetInfo{
+			Family:   family,
+			Table:    tableName,
+			Name:     set.Name,
+			KeyType:  set.KeyType.Name,
+			Interval: set.Interval,
+			Elements: nft.GetSetElementStrings(set.KeyType, set.Interval, elements),
+		})
+	}
+	return setInfos, nil
+}
+
+// AddSet 创建命名集合，元素包含范围时创建区间集合
+func (o *ObjectManagerService) AddSet(info model.SetInfo) error {
+	table, err := o.getTable(info.Family, info.Table)
+	if err != nil {
+		return err
+	}
+
+	if _, err := o.getSet(info.Family, info.Table, info.Name); err == nil {
+		return strerror.CreateCodeError(strerror.CodeConflict, "set exist:"+info.Family+" "+info.Table+" "+info.Name)
+	}
+
+	keyType, err := nft.ParseSetKeyType(info.KeyType)
+	if err != nil {
+		return err
+	}
+
+	elements, interval, err := nft.GetSetElements(keyType, info.Elements)
+	if err != nil {
+		return err
+	}
+
+	// 指定区间集合时，单个元素也需要写成范围
+	if info.Interval && !interval && len(elements) != 0 {
+		return strerror.CreateError("interval set elements must be ranges:" + info.Name)
+	}
+
+	set := &nftables.Set{
+		Table:    table,
+		Name:     info.Name,
+		KeyType:  keyType,
+		Interval: interval || info.Interval,
+	}
+	if err := o.Nft.Conn.AddSet(set, elements); err != nil {
+		o.discard()
+		return err
+	}
+	return o.flush()
+}
+
+// DeleteSet 删除集合，被规则引用时内核返回错误
+func (o *ObjectManagerService) DeleteSet(family string, tableName string, name string) error {
+	set, err := o.getSet(family, tableName, name)
+	if err != nil {
+		return err
+	}
+
+	o.Nft.Conn.DelSet(set)
+	return o.flush()
+}
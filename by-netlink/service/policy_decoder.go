@@ -0,0 +1,52 @@
+package service
+
+import (
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// DecodePolicys 读取策略链中的规则并还原为策略
+// 同一策略按地址族拆分的相邻规则注释相同，合并为一条策略
+func (p *PolicyManagerService) DecodePolicys() ([]model.DecodedPolicy, error) {
+	exist, err := p.lookupPolicyChain()
+	if err != nil || !exist {
+		return nil, err
+	}
+
+	rules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := nft.RuleDecoder{Conn: p.Nft.Conn, Table: p.Table}
+	var policys []model.DecodedPolicy
+	lastComment := ""
+	for _, rule := range rules {
+		comment := nft.GetRuleComment(rule.UserData)
+		// 连接跟踪规则不属于任何策略
+		if nftcmd.IsConntrackComment(comment) {
+			continue
+		}
+		policy, unknown := decoder.DecodeRule(rule)
+
+		if len(comment) != 0 && comment == lastComment {
+			last := &policys[len(policys)-1]
+			last.Handles = append(last.Handles, rule.Handle)
+			last.Policy.SIp = append(last.Policy.SIp, policy.SIp...)
+			last.Policy.DIp = append(last.Policy.DIp, policy.DIp...)
+			last.Unknown = append(last.Unknown, unknown...)
+			continue
+		}
+		lastComment = comment
+
+		_, version := nftcmd.ParseRuleComment(comment)
+		policys = append(policys, model.DecodedPolicy{
+			Handles: []uint64{rule.Handle},
+			Version: version,
+			Policy:  policy,
+			Unknown: unknown,
+		})
+	}
+	return policys, nil
+}
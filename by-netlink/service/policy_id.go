@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// getPolicyRules 查找策略ID对应的规则，策略按地址族拆分时对应多条规则
+func (p *PolicyManagerService) getPolicyRules(id string) ([]*nftables.Rule, error) {
+	err := nftcmd.CheckPolicyId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	exist, err := p.lookupPolicyChain()
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, strerror.CreateCodeError(strerror.CodeNotFound, "policy not found:"+id)
+	}
+
+	rules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var policyRules []*nftables.Rule
+	for _, rule := range rules {
+		policyId, _ := nftcmd.ParseRuleComment(nft.GetRuleComment(rule.UserData))
+		if policyId == id {
+			policyRules = append(policyRules, rule)
+		}
+	}
+
+	if len(policyRules) == 0 {
+		return nil, strerror.CreateCodeError(strerror.CodeNotFound, "policy not found:"+id)
+	}
+	return policyRules, nil
+}
+
+// GetPolicyRules 根据策略ID查看规则
+func (p *PolicyManagerService) GetPolicyRules(id string) ([]model.RuleInfo, error) {
+	rules, err := p.getPolicyRules(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleInfos []model.RuleInfo
+	for _, rule := range rules {
+		ruleInfos = append(ruleInfos, getRuleInfo(rule))
+	}
+	return ruleInfos, nil
+}
+
+// UpdatePolicy 根据策略ID原位替换规则，规则在链中的位置不变，所有修改在一个netlink批次中提交
+func (p *PolicyManagerService) UpdatePolicy(policy model.Policy) (err error) {
+	oldRules, err := p.getPolicyRules(policy.Id)
+	if err != nil {
+		return err
+	}
+	defer p.rollback(&err)
+
+	policys, err := nftcmd.SplitPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	comment := nftcmd.RuleComment(policy)
+	var newRules []*nftables.Rule
+	for _, familyPolicy := range policys {
+		rule, err := p.getFamilyPolicyRule(familyPolicy, comment)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			newRules = append(newRules, rule)
+		}
+	}
+
+	// 规则数相同的部分原位替换
+	var anchor uint64
+	for i := 0; i < len(oldRules) && i < len(newRules); i++ {
+		newRules[i].Handle = oldRules[i].Handle
+		p.Nft.Conn.ReplaceRule(newRules[i])
+		anchor = oldRules[i].Handle
+	}
+
+	// 地址族变少时删除多余的规则
+	for i := len(newRules); i < len(oldRules); i++ {
+		err := p.Nft.Conn.DelRule(&nftables.Rule{Table: p.Table, Chain: p.Chain, Handle: oldRules[i].Handle})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 地址族变多时插入到最后一条替换的规则后面
+	for i := len(newRules) - 1; i >= len(oldRules); i-- {
+		newRules[i].Position = anchor
+		p.Nft.Conn.AddRule(newRules[i])
+	}
+
+	// 地址族或者按地址计算变化后不再使用的动态集合
+	keep, err := meterSetNames(policys)
+	if err != nil {
+		return err
+	}
+	if err := p.deleteMeterSets(oldRules, keep); err != nil {
+		return err
+	}
+
+	if err := p.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("UpdatePolicy Flush() failed: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeletePolicy 根据策略ID删除规则
+func (p *PolicyManagerService) DeletePolicy(id string) (err error) {
+	rules, err := p.getPolicyRules(id)
+	if err != nil {
+		return err
+	}
+	defer p.rollback(&err)
+
+	for _, rule := range rules {
+		err := p.Nft.Conn.DelRule(&nftables.Rule{Table: p.Table, Chain: p.Chain, Handle: rule.Handle})
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.deleteCounters(rules, nil); err != nil {
+		return err
+	}
+	if err := p.deleteMeterSets(rules, nil); err != nil {
+		return err
+	}
+
+	if err := p.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("DeletePolicy Flush() failed: %v\n", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package service
+
+import (
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// LintPolicys 按链中的顺序检查策略，报告被覆盖、冗余、例外和部分重叠的策略
+// 策略的匹配范围与生成的规则表达式一致，包括网卡、地址族、地址段、端口和时间
+func LintPolicys(policys []model.Policy) []model.LintIssue {
+	return nft.LintPolicys(policys)
+}
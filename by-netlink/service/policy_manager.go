@@ -1,25 +1,20 @@
 package service
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/metrics"
 	"netvine.com/firewall/server/utils/nft"
 	"strings"
-	"time"
 )
 
 const (
-	tableName = "filter"
-	chainName = "FORWARD"
-
-	NFT_META_TIME_NS   = 30
-	NFT_META_TIME_DAY  = 31
-	NFT_META_TIME_HOUR = 32
+	// 与nft命令、libnftables保持同一张表和链，三种方式可以互相替换
+	tableName = nftcmd.NftTable
+	chainName = nftcmd.BaseRuleChain
 )
 
 type PolicyManagerService struct {
@@ -28,43 +23,6 @@ type PolicyManagerService struct {
 	Chain *nftables.Chain
 }
 
-// 字符串类型，只要增加一个结束符"\x00"即可
-// cmp eq reg 1 0x696c7075 0x00306b6e 0x00000000 0x00000000
-// []byte{0x75, 0x70, 0x6c, 0x69, 0x6e, 0x6b, 0x31, 0x00}
-func ifname(n string) []byte {
-	b := make([]byte, 16)
-	copy(b, []byte(n+"\x00"))
-	return b
-}
-
-// mac地址
-func macaddr(addr string) []byte {
-	addr = strings.ReplaceAll(addr, ":", "")
-	macByte, err := hex.DecodeString(addr)
-	if err != nil {
-		return macByte
-	}
-	return macByte
-}
-
-// 时间转换
-func metaTime(timeStr string) []byte {
-	// 1970-01-01
-	timeS := "1970-01-01 " + timeStr
-	loc, _ := time.LoadLocation("Asia/Shanghai")
-	metaTime, err := time.ParseInLocation("2006-01-02 15:04:05", timeS, loc)
-	if err != nil {
-		return []byte{}
-	}
-
-	timestamp := metaTime.Unix()
-	bytesBuffer := bytes.NewBuffer([]byte{})
-	_ = binary.Write(bytesBuffer, binary.LittleEndian, timestamp)
-
-	byte := bytesBuffer.Bytes()
-	return byte[:4]
-}
-
 func (p *PolicyManagerService) InitNft(flushrule bool) (err error) {
 
 	if p.Nft == nil {
@@ -83,73 +41,351 @@ func (p *PolicyManagerService) InitNft(flushrule bool) (err error) {
 			}
 		}
 
-		p.Table, err = p.Nft.CreateTableIfNotExist(tableName)
+		p.Table, p.Chain, err = initPolicyChain(p.Nft, tableName)
 		if err != nil {
 			return err
 		}
 
-		p.Chain, err = p.Nft.CreateChainIfNotExist(p.Table, chainName)
+		if err := p.Nft.Conn.Flush(); err != nil {
+			fmt.Printf("InitNft Flush() failed: %v\n", err)
+			p.discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// initPolicyChain 创建表和策略链，加入当前批次
+// 新建的策略链默认丢弃没有命中策略的报文，开启已建立连接的快速放行，已存在的链不修改
+func initPolicyChain(nfTables *nft.NfTables, name string) (*nftables.Table, *nftables.Chain, error) {
+	// inet表同时处理IPv4和IPv6报文
+	table, err := nfTables.CreateTableIfNotExist(nftables.TableFamilyINet, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain, created, err := nfTables.CreateBaseChainIfNotExist(table, chainName, nftables.ChainHookForward, nftables.ChainPriorityFilter, nftables.ChainPolicyDrop)
+	if err != nil {
+		return nil, nil, err
+	}
+	if created {
+		rules, err := getConntrackRules(table, chain, nftcmd.ConntrackComments(model.ConntrackConfig{Established: true}))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rule := range rules {
+			nfTables.Conn.AddRule(rule)
+		}
+	}
+	return table, chain, nil
+}
+
+// lookupPolicyChain 只查找表和策略链，不存在时不创建，用于查看规则、计数等只读操作
+func (p *PolicyManagerService) lookupPolicyChain() (exist bool, err error) {
+	if p.Nft == nil {
+		conn, nsHandle := nft.OpenSystemNFTConn()
+		p.Nft = &nft.NfTables{Conn: conn, NetNS: nsHandle}
+	}
+	defer p.rollback(&err)
+
+	chains, err := p.Nft.Conn.ListChainsOfTableFamily(nftables.TableFamilyINet)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range chains {
+		if c.Name == chainName && c.Table.Name == tableName {
+			p.Table, p.Chain = c.Table, c
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// discard 丢弃连接，重新下发时建立新的连接
+// nftables.Conn 出错后不会清空已加入批次的消息和序列化错误，继续使用会把这些消息带到下一次提交中
+func (p *PolicyManagerService) discard() {
+	if p.Nft != nil {
+		if err := p.Nft.NetNS.Close(); err != nil {
+			fmt.Printf("NetNS.Close() failed: %v\n", err)
+		}
+		p.Nft = nil
+	}
+}
+
+// rollback 生成规则或者提交失败时丢弃当前批次
+// 同一批次的修改由内核保证要么全部生效要么全部不生效，丢弃批次后规则与修改前一致
+func (p *PolicyManagerService) rollback(err *error) {
+	if *err != nil {
+		metrics.NetlinkErrors.Inc("policy")
+		p.discard()
+	}
+}
+
+func (p *PolicyManagerService) GeneratePolicyRule(policy model.Policy) (err error) {
+
+	// 初始化时只下发差异，不清空其他软件的规则
+	if policy.Manager == "init" {
+		_, err := p.ReconcilePolicys([]model.Policy{policy})
+		return err
+	}
+
+	err = p.InitNft(false)
+	if err != nil {
+		fmt.Printf("GeneratePolicyRule Error %v", err)
+		return err
+	}
+	defer p.rollback(&err)
+
+	err = p.addPolicyRule(policy)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("%v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ApplyPolicys 追加下发策略，所有规则在一个netlink批次中提交
+func (p *PolicyManagerService) ApplyPolicys(policys []model.Policy) (err error) {
+	err = p.InitNft(false)
+	if err != nil {
+		return err
+	}
+	defer p.rollback(&err)
+
+	for _, policy := range policys {
+		err := p.addPolicyRule(policy)
 		if err != nil {
 			return err
 		}
+	}
 
-		if err := p.Nft.Conn.Flush(); err != nil {
-			fmt.Printf("InitNft Flush() failed: %v\n", err)
+	if err := p.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("ApplyPolicys Flush() failed: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// ListRules 查看已下发的规则
+func (p *PolicyManagerService) ListRules() ([]model.RuleInfo, error) {
+	exist, err := p.lookupPolicyChain()
+	if err != nil || !exist {
+		return nil, err
+	}
+
+	rules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleInfos []model.RuleInfo
+	for _, rule := range rules {
+		ruleInfos = append(ruleInfos, getRuleInfo(rule))
+	}
+	return ruleInfos, nil
+}
+
+// getRuleInfo 规则的句柄、表达式和注释
+func getRuleInfo(rule *nftables.Rule) model.RuleInfo {
+	var exprs []string
+	for _, e := range rule.Exprs {
+		exprs = append(exprs, fmt.Sprintf("%T%+v", e, e))
+	}
+	ruleInfo := model.RuleInfo{Handle: rule.Handle, Expr: strings.Join(exprs, " ")}
+	nftcmd.SetRuleComment(&ruleInfo, nft.GetRuleComment(rule.UserData))
+	return ruleInfo
+}
+
+// DeleteRule 根据句柄删除规则
+func (p *PolicyManagerService) DeleteRule(handle uint64) (err error) {
+	err = p.InitNft(false)
+	if err != nil {
+		return err
+	}
+	defer p.rollback(&err)
+
+	err = p.Nft.Conn.DelRule(&nftables.Rule{Table: p.Table, Chain: p.Chain, Handle: handle})
+	if err != nil {
+		return err
+	}
+	return p.Nft.Conn.Flush()
+}
+
+// deleteCounters 删除已删除规则引用的计数器，加入当前批次，keep 中的计数器仍在使用
+// 升级前下发的规则没有计数器，只删除已存在的计数器
+func (p *PolicyManagerService) deleteCounters(rules []*nftables.Rule, keep map[string]bool) error {
+	objs, err := p.Nft.Conn.GetObjects(p.Table)
+	if err != nil {
+		return err
+	}
+	exist := make(map[string]bool)
+	for _, obj := range objs {
+		if counter, ok := obj.(*nftables.CounterObj); ok {
+			exist[counter.Name] = true
+		}
+	}
+
+	for _, rule := range rules {
+		name := nftcmd.CounterName(nft.GetRuleComment(rule.UserData))
+		if !exist[name] || keep[name] {
+			continue
 		}
+		p.Nft.Conn.DeleteObject(&nftables.CounterObj{Table: p.Table, Name: name})
+		exist[name] = false
 	}
 	return nil
 }
 
-func (p *PolicyManagerService) GeneratePolicyRule(policy model.Policy) error {
+// deleteMeterSets 删除已删除规则引用的按地址计算的动态集合，加入当前批次，keep 中的集合仍在使用
+// 规则删除后集合不会自动删除，与计数器一样和规则在同一批次中删除
+func (p *PolicyManagerService) deleteMeterSets(rules []*nftables.Rule, keep map[string]bool) error {
+	sets, err := p.Nft.Conn.GetSets(p.Table)
+	if err != nil {
+		return err
+	}
+	exist := make(map[string]bool)
+	for _, set := range sets {
+		if nftcmd.IsMeterSetName(set.Name) {
+			exist[set.Name] = true
+		}
+	}
 
-	flushruleset := (policy.Manager == "init")
-	err := p.InitNft(flushruleset)
+	for _, rule := range rules {
+		for _, e := range rule.Exprs {
+			dynset, ok := e.(*expr.Dynset)
+			if !ok || !exist[dynset.SetName] || keep[dynset.SetName] {
+				continue
+			}
+			p.Nft.Conn.DelSet(&nftables.Set{Table: p.Table, Name: dynset.SetName})
+			exist[dynset.SetName] = false
+		}
+	}
+	return nil
+}
+
+// meterSetNames 按地址族拆分后的策略使用的动态集合
+func meterSetNames(policys []model.Policy) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, policy := range policys {
+		name, err := nftcmd.FamilyMeterSetName(policy)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) != 0 {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// FlushRules 清空策略链，连接跟踪规则在同一批次中重新添加
+func (p *PolicyManagerService) FlushRules() (err error) {
+	err = p.InitNft(false)
 	if err != nil {
-		fmt.Printf("GeneratePolicyRule Error %v", err)
+		return err
+	}
+	defer p.rollback(&err)
+
+	rules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return err
+	}
+	ctRules, err := getConntrackRules(p.Table, p.Chain, conntrackComments(rules))
+	if err != nil {
+		return err
+	}
+
+	p.Nft.Conn.FlushChain(p.Chain)
+	for _, rule := range ctRules {
+		p.Nft.Conn.AddRule(rule)
+	}
+	if err := p.deleteCounters(rules, nil); err != nil {
+		return err
 	}
+	if err := p.deleteMeterSets(rules, nil); err != nil {
+		return err
+	}
+	return p.Nft.Conn.Flush()
+}
+
+// addPolicyRule 生成策略规则，加入当前批次
+// 展开应用，同时包含IPv4和IPv6地址的策略按地址族拆分成多条规则
+func (p *PolicyManagerService) addPolicyRule(policy model.Policy) error {
+	policys, err := nftcmd.SplitPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	comment := nftcmd.RuleComment(policy)
+	for _, familyPolicy := range policys {
+		rule, err := p.getFamilyPolicyRule(familyPolicy, comment)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			p.Nft.Conn.AddRule(rule)
+		}
+	}
+	return nil
+}
 
+// getFamilyPolicyRule 生成单个地址族的规则，comment 标识规则对应的策略
+// 规则用到的匿名集合已经加入当前批次
+func (p *PolicyManagerService) getFamilyPolicyRule(policy model.Policy, comment string) (*nftables.Rule, error) {
 	var exprs []expr.Any
 
 	// 入接口
-	ifExpr, err := nft.AddInterfaceExpr(p.Table, p.Nft.Conn, "if_set", expr.MetaKeyIIFNAME, policy.SRegion)
+	ifExpr, err := nft.AddInterfaceExpr(p.Table, p.Nft.Conn, expr.MetaKeyIIFNAME, policy.SRegion)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(ifExpr) != 0 {
 		exprs = append(exprs, ifExpr...)
 	}
 
 	// 出接口
-	ofExpr, err := nft.AddInterfaceExpr(p.Table, p.Nft.Conn, "of_set", expr.MetaKeyOIFNAME, policy.SRegion)
+	ofExpr, err := nft.AddInterfaceExpr(p.Table, p.Nft.Conn, expr.MetaKeyOIFNAME, policy.DRegion)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(ofExpr) != 0 {
 		exprs = append(exprs, ofExpr...)
 	}
 
 	// 协议
-	protocolExpr, err := nft.AddProtocolExpr(policy.Protocol)
+	protocolMatch, err := nftcmd.GetProtocolMatch(policy)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	protocolExpr, err := nft.AddProtocolExpr(p.Table, p.Nft.Conn, protocolMatch)
+	if err != nil {
+		return nil, err
 	}
 	if len(protocolExpr) != 0 {
 		exprs = append(exprs, protocolExpr...)
 	}
 
+	// 地址族，没有地址的策略按地址族拆分时设置
+	exprs = append(exprs, nft.AddFamilyExpr(policy.Family)...)
+
 	// 源IP
-	sourceIpExpr, err := nft.AddIPExpr(p.Table, p.Nft.Conn, "sip_set", 12, policy.SIp)
+	sourceIpExpr, err := nft.AddIPExpr(p.Table, p.Nft.Conn, true, policy.SIp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(sourceIpExpr) != 0 {
 		exprs = append(exprs, sourceIpExpr...)
 	}
 
 	// 目的IP
-	destIpExpr, err := nft.AddIPExpr(p.Table, p.Nft.Conn, "dip_set", 16, policy.DIp)
+	destIpExpr, err := nft.AddIPExpr(p.Table, p.Nft.Conn, false, policy.DIp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(destIpExpr) != 0 {
 		exprs = append(exprs, destIpExpr...)
@@ -158,7 +394,7 @@ func (p *PolicyManagerService) GeneratePolicyRule(policy model.Policy) error {
 	// source mac addr
 	sourceMacExpr, err := nft.GetMacExpr(expr.MetaKeyIIFTYPE, policy.SMac)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(sourceMacExpr) != 0 {
 		exprs = append(exprs, sourceMacExpr...)
@@ -167,66 +403,89 @@ func (p *PolicyManagerService) GeneratePolicyRule(policy model.Policy) error {
 	//dst mac
 	destMacExpr, err := nft.GetMacExpr(expr.MetaKeyOIFTYPE, policy.DMac)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(destMacExpr) != 0 {
 		exprs = append(exprs, destMacExpr...)
 	}
 
 	// 源端口
-	sourcePortExpr, err := nft.GetPortExpr(0, uint(policy.SPort))
+	sourcePortExpr, err := nft.GetPortExpr(p.Table, p.Nft.Conn, 0, policy.SPort)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(sourcePortExpr) != 0 {
 		exprs = append(exprs, sourcePortExpr...)
 	}
 
 	// 目的端口
-	destPortExpr, err := nft.GetPortExpr(2, uint(policy.DPort))
+	destPortExpr, err := nft.GetPortExpr(p.Table, p.Nft.Conn, 2, policy.DPort)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(destPortExpr) != 0 {
 		exprs = append(exprs, destPortExpr...)
 	}
 
 	// 时间
-	timeExpr, err := nft.GetTimeExpr(p.Table, p.Nft.Conn, "time_set", policy.Time)
+	timeExpr, err := nft.GetTimeExpr(p.Table, p.Nft.Conn, policy.Time)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(timeExpr) != 0 {
 		exprs = append(exprs, timeExpr...)
 	}
 
+	// 连接状态、方向和标记
+	ctExpr, err := nft.GetCtExpr(policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(ctExpr) != 0 {
+		exprs = append(exprs, ctExpr...)
+	}
+
+	// 限速、连接数限制，只有超过限制的报文继续执行日志和动作
+	limitExpr, err := nft.GetLimitExpr(p.Table, p.Nft.Conn, policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(limitExpr) != 0 {
+		exprs = append(exprs, limitExpr...)
+	}
+
+	// 命中计数
+	counterExpr := nft.GetCounterExpr(p.Table, p.Nft.Conn, comment)
+	if len(counterExpr) != 0 {
+		exprs = append(exprs, counterExpr...)
+	}
+
 	// 日志
-	logExpr, err := nft.GetLogExpr(policy.LogTag)
+	logExpr, err := nft.GetLogExpr(nftcmd.LogPrefix(policy))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(logExpr) != 0 {
 		exprs = append(exprs, logExpr...)
 	}
 
 	// 动作
-	actionExpr, err := nft.GetActionExpr(policy.Action)
+	actionExpr, err := nft.GetActionExpr(string(nftcmd.PolicyRuleAction(policy)))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(actionExpr) != 0 {
 		exprs = append(exprs, actionExpr...)
 	}
 
-	if len(exprs) > 0 {
-		rule := &nftables.Rule{Table: p.Table, Chain: p.Chain, Exprs: exprs}
-		p.Nft.Conn.AddRule(rule)
+	if len(exprs) == 0 {
+		return nil, nil
 	}
 
-	if err := p.Nft.Conn.Flush(); err != nil {
-		fmt.Printf("%v", err)
-		return err
-	}
-
-	return nil
+	return &nftables.Rule{
+		Table:    p.Table,
+		Chain:    p.Chain,
+		Exprs:    exprs,
+		UserData: nft.GetCommentUserData(comment),
+	}, nil
 }
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/store"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// PolicyStatsService 读取、清零策略的命名计数器，定期采样记录最近一次命中的时间
+// 所有下发方式共用内核中的同一条链，直接通过netlink读取
+type PolicyStatsService struct {
+	Manager *PolicyManagerService
+	Store   *store.StatsStore
+}
+
+func NewPolicyStatsService(statsStore *store.StatsStore) *PolicyStatsService {
+	return &PolicyStatsService{Manager: &PolicyManagerService{}, Store: statsStore}
+}
+
+// counters 策略表中本程序创建的计数器
+func (s *PolicyStatsService) counters() (map[string]*nftables.CounterObj, error) {
+	objs, err := s.Manager.Nft.Conn.GetObjects(s.Manager.Table)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]*nftables.CounterObj)
+	for _, obj := range objs {
+		if counter, ok := obj.(*nftables.CounterObj); ok && nftcmd.IsCounterName(counter.Name) {
+			counters[counter.Name] = counter
+		}
+	}
+	return counters, nil
+}
+
+// Stats 按规则顺序返回每条策略的计数，按地址族拆分的规则只返回一次
+func (s *PolicyStatsService) Stats() ([]model.PolicyStats, error) {
+	exist, err := s.Manager.lookupPolicyChain()
+	if err != nil || !exist {
+		return nil, err
+	}
+
+	rules, err := s.Manager.Nft.Conn.GetRules(s.Manager.Table, s.Manager.Chain)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := s.counters()
+	if err != nil {
+		return nil, err
+	}
+	samples, err := s.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []model.PolicyStats
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		comment := nft.GetRuleComment(rule.UserData)
+		if !nftcmd.IsRuleComment(comment) || seen[comment] {
+			continue
+		}
+		seen[comment] = true
+
+		id, version := nftcmd.ParseRuleComment(comment)
+		stat := model.PolicyStats{PolicyId: id, Version: version}
+		// 升级前下发的规则没有计数器
+		if counter, ok := counters[nftcmd.CounterName(comment)]; ok {
+			stat.Counter = counter.Name
+			stat.Packets = counter.Packets
+			stat.Bytes = counter.Bytes
+			stat.LastHit = samples[counter.Name].LastHit
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// Reset 清零策略的计数器，策略ID为空时清零全部计数器，最近命中时间保留
+func (s *PolicyStatsService) Reset(id string) error {
+	exist, err := s.Manager.lookupPolicyChain()
+	if err != nil {
+		return err
+	}
+	if !exist && len(id) != 0 {
+		return strerror.CreateCodeError(strerror.CodeNotFound, "policy counter not found:"+id)
+	}
+	if !exist {
+		return nil
+	}
+
+	counters, err := s.counters()
+	if err != nil {
+		return err
+	}
+	if len(id) != 0 {
+		name := nftcmd.PolicyCounterName(id)
+		counter, ok := counters[name]
+		if !ok {
+			return strerror.CreateCodeError(strerror.CodeNotFound, "policy counter not found:"+id)
+		}
+		counters = map[string]*nftables.CounterObj{name: counter}
+	}
+
+	return s.Store.Update(func(samples map[string]store.CounterSample) (map[string]store.CounterSample, error) {
+		for name, counter := range counters {
+			if _, err := s.Manager.Nft.Conn.ResetObject(counter); err != nil {
+				return nil, err
+			}
+			sample := samples[name]
+			sample.Packets = 0
+			samples[name] = sample
+		}
+		return samples, nil
+	})
+}
+
+// Sample 采样一次计数器，报文数增加时记录命中时间
+// 报文数比上次少说明计数器被清零过，不为0时同样认为有命中
+func (s *PolicyStatsService) Sample(now time.Time) error {
+	// 策略表不存在时没有计数器，不保存采样
+	exist, err := s.Manager.lookupPolicyChain()
+	if err != nil || !exist {
+		return err
+	}
+
+	counters, err := s.counters()
+	if err != nil {
+		return err
+	}
+	return s.Store.Update(func(samples map[string]store.CounterSample) (map[string]store.CounterSample, error) {
+		current := make(map[string]store.CounterSample)
+		for name, counter := range counters {
+			sample := samples[name]
+			if counter.Packets > sample.Packets || (counter.Packets < sample.Packets && counter.Packets != 0) {
+				sample.LastHit = now
+			}
+			sample.Packets = counter.Packets
+			current[name] = sample
+		}
+		// 已删除的计数器不再保存
+		return current, nil
+	})
+}
+
+// Run 按间隔采样直到 stop 关闭，mu 用于与其他使用netlink连接的操作串行执行
+func (s *PolicyStatsService) Run(interval time.Duration, mu sync.Locker, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			mu.Lock()
+			if err := s.Sample(now); err != nil {
+				fmt.Printf("policy stats sample failed: %v\n", err)
+			}
+			mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// desiredRule 期望下发的规则，策略按地址族拆分后每条对应一条规则
+type desiredRule struct {
+	comment string
+	policy  model.Policy
+}
+
+// getDesiredRules 按策略顺序展开期望的规则
+func getDesiredRules(policys []model.Policy) ([]desiredRule, error) {
+	var rules []desiredRule
+	for _, policy := range policys {
+		familyPolicys, err := nftcmd.SplitPolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+
+		comment := nftcmd.RuleComment(policy)
+		for _, familyPolicy := range familyPolicys {
+			rules = append(rules, desiredRule{comment: comment, policy: familyPolicy})
+		}
+	}
+	return rules, nil
+}
+
+// matchRules 最长公共子序列，返回保持不变的规则下标对 [当前规则, 期望规则]
+func matchRules(current []string, desired []string) [][2]int {
+	n, m := len(current), len(desired)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if current[i] == desired[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		if current[i] == desired[j] {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return pairs
+}
+
+// policyRules 只对比本程序下发的策略规则，连接跟踪规则和其他软件的规则不参与对比，也不会被删除
+func policyRules(chainRules []*nftables.Rule) []*nftables.Rule {
+	var rules []*nftables.Rule
+	for _, rule := range chainRules {
+		if nftcmd.IsRuleComment(nft.GetRuleComment(rule.UserData)) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// ReconcilePolicys 对比期望的策略和当前链中的规则，只下发差异部分
+// 未变化的规则保留原句柄，变化的规则原位替换，多余的规则删除，所有修改在一个netlink批次中提交
+func (p *PolicyManagerService) ReconcilePolicys(policys []model.Policy) (result model.ReconcileResult, err error) {
+	err = p.InitNft(false)
+	if err != nil {
+		return result, err
+	}
+	defer p.rollback(&err)
+
+	desired, err := getDesiredRules(policys)
+	if err != nil {
+		return result, err
+	}
+
+	chainRules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return result, err
+	}
+
+	rules := policyRules(chainRules)
+	currentComments := make([]string, len(rules))
+	for i, rule := range rules {
+		currentComments[i] = nft.GetRuleComment(rule.UserData)
+	}
+	desiredComments := make([]string, len(desired))
+	for i, rule := range desired {
+		desiredComments[i] = rule.comment
+	}
+
+	pairs := matchRules(currentComments, desiredComments)
+	// 末尾加一个哨兵，处理最后一段差异
+	pairs = append(pairs, [2]int{len(rules), len(desired)})
+
+	// anchor 前一条保留规则的句柄，新增规则插入到它后面
+	var anchor uint64
+	i, j := 0, 0
+	for _, pair := range pairs {
+		oldRules := rules[i:pair[0]]
+		newRules := desired[j:pair[1]]
+
+		// 差异段中先原位替换，保持句柄和位置
+		replaced := len(oldRules)
+		if len(newRules) < replaced {
+			replaced = len(newRules)
+		}
+		for k := 0; k < replaced; k++ {
+			rule, err := p.getFamilyPolicyRule(newRules[k].policy, newRules[k].comment)
+			if err != nil {
+				return result, err
+			}
+			if rule == nil {
+				oldRules[k].Table, oldRules[k].Chain = p.Table, p.Chain
+				if err := p.Nft.Conn.DelRule(oldRules[k]); err != nil {
+					return result, err
+				}
+				result.Deleted++
+				continue
+			}
+			rule.Handle = oldRules[k].Handle
+			p.Nft.Conn.ReplaceRule(rule)
+			anchor = rule.Handle
+			result.Replaced++
+		}
+
+		// 多余的旧规则删除
+		for _, old := range oldRules[replaced:] {
+			err := p.Nft.Conn.DelRule(&nftables.Rule{Table: p.Table, Chain: p.Chain, Handle: old.Handle})
+			if err != nil {
+				return result, err
+			}
+			result.Deleted++
+		}
+
+		// 新增规则
+		var added []*nftables.Rule
+		for _, newRule := range newRules[replaced:] {
+			rule, err := p.getFamilyPolicyRule(newRule.policy, newRule.comment)
+			if err != nil {
+				return result, err
+			}
+			if rule != nil {
+				added = append(added, rule)
+			}
+		}
+		p.addRules(added, anchor, rules, pair[0])
+		result.Added += len(added)
+
+		if pair[0] < len(rules) {
+			anchor = rules[pair[0]].Handle
+			result.Kept++
+		}
+		i, j = pair[0]+1, pair[1]+1
+	}
+
+	// 不再使用的计数器和动态集合与规则在同一批次中删除
+	keep := make(map[string]bool)
+	for _, comment := range desiredComments {
+		keep[nftcmd.CounterName(comment)] = true
+	}
+	if err := p.deleteCounters(rules, keep); err != nil {
+		return result, err
+	}
+	var familyPolicys []model.Policy
+	for _, rule := range desired {
+		familyPolicys = append(familyPolicys, rule.policy)
+	}
+	keepSets, err := meterSetNames(familyPolicys)
+	if err != nil {
+		return result, err
+	}
+	if err := p.deleteMeterSets(rules, keepSets); err != nil {
+		return result, err
+	}
+
+	if err := p.Nft.Conn.Flush(); err != nil {
+		fmt.Printf("ReconcilePolicys Flush() failed: %v\n", err)
+		return result, err
+	}
+
+	fmt.Printf("reconcile: added=%d deleted=%d replaced=%d kept=%d\n",
+		result.Added, result.Deleted, result.Replaced, result.Kept)
+	return result, nil
+}
+
+// addRules 按顺序加入新增规则
+// 有前一条保留规则时插到它后面；否则插到下一条保留规则前面；链中没有保留规则时追加到末尾
+func (p *PolicyManagerService) addRules(added []*nftables.Rule, anchor uint64, rules []*nftables.Rule, next int) {
+	if anchor != 0 {
+		// 每条都插到anchor后面，所以倒序加入
+		for k := len(added) - 1; k >= 0; k-- {
+			added[k].Position = anchor
+			p.Nft.Conn.AddRule(added[k])
+		}
+		return
+	}
+
+	if next < len(rules) {
+		for _, rule := range added {
+			rule.Position = rules[next].Handle
+			p.Nft.Conn.InsertRule(rule)
+		}
+		return
+	}
+
+	for _, rule := range added {
+		p.Nft.Conn.AddRule(rule)
+	}
+}
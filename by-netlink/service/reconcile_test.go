@@ -0,0 +1,102 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+func TestMatchRules(t *testing.T) {
+	const (
+		a = "fd:a:0000000000000001"
+		b = "fd:b:0000000000000002"
+		c = "fd:c:0000000000000003"
+		d = "fd:d:0000000000000004"
+	)
+
+	tests := []struct {
+		name    string
+		current []string
+		desired []string
+		want    [][2]int
+	}{
+		{"unchanged", []string{a, b, c}, []string{a, b, c}, [][2]int{{0, 0}, {1, 1}, {2, 2}}},
+		{"empty chain", nil, []string{a, b}, nil},
+		{"empty desired", []string{a, b}, nil, nil},
+		{"insert head", []string{b, c}, []string{a, b, c}, [][2]int{{0, 1}, {1, 2}}},
+		{"insert middle", []string{a, c}, []string{a, b, c}, [][2]int{{0, 0}, {1, 2}}},
+		{"insert tail", []string{a, b}, []string{a, b, c}, [][2]int{{0, 0}, {1, 1}}},
+		{"delete head", []string{a, b, c}, []string{b, c}, [][2]int{{1, 0}, {2, 1}}},
+		{"delete middle", []string{a, b, c}, []string{a, c}, [][2]int{{0, 0}, {2, 1}}},
+		{"replace middle", []string{a, b, c}, []string{a, d, c}, [][2]int{{0, 0}, {2, 2}}},
+		{"move last to head", []string{a, b, c}, []string{c, a, b}, [][2]int{{0, 1}, {1, 2}}},
+		{"move head to last", []string{a, b, c}, []string{b, c, a}, [][2]int{{1, 0}, {2, 1}}},
+		{"split by family", []string{a, a, b}, []string{a, a, c, b}, [][2]int{{0, 0}, {1, 1}, {2, 3}}},
+		{"family removed", []string{a, a, b}, []string{a, b}, [][2]int{{0, 0}, {2, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchRules(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("matchRules() = %v, want %v", got, tt.want)
+			}
+			// 保留的规则按顺序递增，并且注释相同
+			for k, pair := range got {
+				if tt.current[pair[0]] != tt.desired[pair[1]] {
+					t.Errorf("pair %v matches %q with %q", pair, tt.current[pair[0]], tt.desired[pair[1]])
+				}
+				if k > 0 && (pair[0] <= got[k-1][0] || pair[1] <= got[k-1][1]) {
+					t.Errorf("pairs not increasing: %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	rule := func(handle uint64, comment string) *nftables.Rule {
+		r := &nftables.Rule{Handle: handle}
+		if len(comment) != 0 {
+			r.UserData = nft.GetCommentUserData(comment)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		chain []*nftables.Rule
+		want  []uint64
+	}{
+		{"empty chain", nil, nil},
+		{"only policy rules", []*nftables.Rule{rule(1, "fd:a:01"), rule(2, "fd:b:02")}, []uint64{1, 2}},
+		{
+			"conntrack and foreign rules kept out",
+			[]*nftables.Rule{
+				rule(1, nftcmd.CtEstablishedComment),
+				rule(2, "fd:a:01"),
+				rule(3, "docker"),
+				rule(4, ""),
+				rule(5, "fd:b:02"),
+				rule(6, nftcmd.CtInvalidComment),
+			},
+			[]uint64{2, 5},
+		},
+		{"only foreign rules", []*nftables.Rule{rule(1, "docker"), rule(2, "")}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []uint64
+			for _, r := range policyRules(tt.chain) {
+				got = append(got, r.Handle)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("policyRules() handles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"netvine.com/firewall/server/libnft"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+	"netvine.com/firewall/server/utils/metrics"
+)
+
+type BackendType string
+
+// 规则下发方式
+const (
+	BackendNetlink BackendType = "netlink" // 直接通过netlink与内核通信
+	BackendCommand BackendType = "nft"     // 调用nft命令
+	BackendLibNft  BackendType = "libnft"  // 通过libnftables JSON接口
+)
+
+// RuleBackend 规则下发接口，三种下发方式可以互相替换
+type RuleBackend interface {
+	ApplyPolicys(policys []model.Policy) error // 追加下发策略
+	ListRules() ([]model.RuleInfo, error)      // 查看已下发的规则
+	DeleteRule(handle uint64) error            // 根据句柄删除规则
+	FlushRules() error                         // 清空策略链
+}
+
+// Reconciler 支持差异下发的规则下发方式，只修改变化的规则
+type Reconciler interface {
+	ReconcilePolicys(policys []model.Policy) (model.ReconcileResult, error)
+}
+
+// PolicyReplacer 清空策略链和下发策略可以在同一个事务中执行的规则下发方式
+type PolicyReplacer interface {
+	ReplacePolicys(policys []model.Policy) error
+}
+
+// PolicyIdentifier 支持按策略ID查找、更新、删除策略的规则下发方式
+type PolicyIdentifier interface {
+	GetPolicyRules(id string) ([]model.RuleInfo, error) // 查看策略对应的规则
+	UpdatePolicy(policy model.Policy) error             // 根据策略ID原位更新
+	DeletePolicy(id string) error                       // 根据策略ID删除
+}
+
+type BackendConfig struct {
+	Type BackendType // 下发方式 netlink/nft/libnft
+}
+
+// NewRuleBackend 根据配置选择规则下发方式，默认netlink
+func NewRuleBackend(config BackendConfig) (RuleBackend, error) {
+	switch config.Type {
+	case "", BackendNetlink:
+		return &PolicyManagerService{}, nil
+	case BackendCommand:
+		return &nftcmd.PolicyManagerCommandService{}, nil
+	case BackendLibNft:
+		return &libnft.PolicyManagerLibNftService{}, nil
+	}
+	return nil, strerror.CreateError("unknown backend:" + string(config.Type))
+}
+
+// BackendName 规则下发方式的名称，用于指标标签
+func BackendName(backend RuleBackend) BackendType {
+	switch backend.(type) {
+	case *nftcmd.PolicyManagerCommandService:
+		return BackendCommand
+	case *libnft.PolicyManagerLibNftService:
+		return BackendLibNft
+	}
+	return BackendNetlink
+}
+
+// ApplyPolicys 追加下发策略并记录耗时
+func ApplyPolicys(backend RuleBackend, policys []model.Policy) (err error) {
+	defer func(start time.Time) {
+		metrics.ApplyDuration.ObserveSince(start, string(BackendName(backend)), metrics.Result(err))
+	}(time.Now())
+	return backend.ApplyPolicys(policys)
+}
+
+// SyncPolicys 使内核中的策略链与给定策略一致并记录耗时
+func SyncPolicys(backend RuleBackend, policys []model.Policy) (err error) {
+	defer func(start time.Time) {
+		metrics.SyncDuration.ObserveSince(start, string(BackendName(backend)), metrics.Result(err))
+	}(time.Now())
+	return syncPolicys(backend, policys)
+}
+
+// syncPolicys 支持差异下发时只修改变化的规则，否则清空策略链重新下发
+func syncPolicys(backend RuleBackend, policys []model.Policy) error {
+	if reconciler, ok := backend.(Reconciler); ok {
+		_, err := reconciler.ReconcilePolicys(policys)
+		return err
+	}
+
+	if replacer, ok := backend.(PolicyReplacer); ok {
+		return replacer.ReplacePolicys(policys)
+	}
+
+	if err := backend.FlushRules(); err != nil {
+		return err
+	}
+	return backend.ApplyPolicys(policys)
+}
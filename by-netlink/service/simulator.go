@@ -0,0 +1,76 @@
+package service
+
+import (
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/utils/nft"
+)
+
+// SimulatePacket 按顺序匹配策略，返回第一条命中的策略和规则动作
+// 允许和告警都交给suricata队列，阻断直接丢弃，没有命中时按策略链的默认动作丢弃
+// 带有限速、连接数限制的策略只有超过限制时才执行动作，记录后继续匹配后面的策略
+func SimulatePacket(policys []model.Policy, packet model.Packet) (model.SimulateResult, error) {
+	var limited []int
+	for i, policy := range policys {
+		matched, err := nft.MatchPolicy(policy, packet)
+		if err != nil {
+			return model.SimulateResult{}, err
+		}
+		if !matched {
+			continue
+		}
+		if nftcmd.HasLimit(policy) {
+			limited = append(limited, i)
+			continue
+		}
+
+		return model.SimulateResult{
+			Matched:   true,
+			Index:     i,
+			Policy:    policy,
+			Verdict:   string(nftcmd.PolicyRuleAction(policy)),
+			LogPrefix: nftcmd.LogPrefix(policy),
+			Limited:   limited,
+		}, nil
+	}
+
+	return model.SimulateResult{Index: -1, Verdict: string(nftcmd.ActionDrop), Limited: limited}, nil
+}
+
+// SimulateConntrack 报文先匹配策略链最前面的连接跟踪规则，命中时不再匹配策略
+func SimulateConntrack(config model.ConntrackConfig, packet model.Packet) (model.SimulateResult, bool, error) {
+	for _, comment := range nftcmd.ConntrackComments(config) {
+		matched, err := nft.MatchConntrackRule(comment, packet)
+		if err != nil {
+			return model.SimulateResult{}, false, err
+		}
+		if matched {
+			_, action := nftcmd.ConntrackRuleState(comment)
+			return model.SimulateResult{Index: -1, Verdict: string(action), Conntrack: comment}, true, nil
+		}
+	}
+	return model.SimulateResult{}, false, nil
+}
+
+// SimulateKernelPacket 使用内核中已下发的规则模拟匹配
+func (p *PolicyManagerService) SimulateKernelPacket(packet model.Packet) (model.SimulateResult, error) {
+	decoded, err := p.DecodePolicys()
+	if err != nil {
+		return model.SimulateResult{}, err
+	}
+
+	rules, err := p.Nft.Conn.GetRules(p.Table, p.Chain)
+	if err != nil {
+		return model.SimulateResult{}, err
+	}
+	config := nftcmd.GetConntrackConfig(conntrackComments(rules))
+	if result, ok, err := SimulateConntrack(config, packet); ok || err != nil {
+		return result, err
+	}
+
+	policys := make([]model.Policy, 0, len(decoded))
+	for _, d := range decoded {
+		policys = append(policys, d.Policy)
+	}
+	return SimulatePacket(policys, packet)
+}
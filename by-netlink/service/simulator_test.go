@@ -0,0 +1,117 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+)
+
+func TestSimulatePacket(t *testing.T) {
+	ssh := model.Policy{Id: "ssh", SIp: []string{"10.0.0.0/8"}, Protocol: "tcp", DPort: model.Ports{"22"}, Action: nftcmd.ALLOW}
+	block := model.Policy{Id: "block", SIp: []string{"10.0.0.0/8"}, Action: nftcmd.DROP}
+	warn := model.Policy{Id: "warn", Protocol: "tcp", Action: nftcmd.WARN, LogSwitch: 1}
+	limit := model.Policy{Id: "limit", Protocol: "tcp", DPort: model.Ports{"22"}, Action: nftcmd.DROP, Limit: "100/second"}
+	connLimit := model.Policy{Id: "conn", SIp: []string{"10.0.0.0/8"}, Action: nftcmd.DROP, ConnLimit: 8}
+
+	sshPacket := model.Packet{SIp: "10.1.1.1", DIp: "192.168.1.1", Protocol: "tcp", SPort: 40000, DPort: 22}
+	httpPacket := model.Packet{SIp: "10.1.1.1", DIp: "192.168.1.1", Protocol: "tcp", SPort: 40000, DPort: 80}
+	otherPacket := model.Packet{SIp: "172.16.0.1", DIp: "192.168.1.1", Protocol: "udp", SPort: 40000, DPort: 53}
+
+	tests := []struct {
+		name    string
+		policys []model.Policy
+		packet  model.Packet
+		index   int
+		verdict nftcmd.RuleAction
+		limited []int
+	}{
+		{"first match allow", []model.Policy{ssh, block}, sshPacket, 0, nftcmd.ActionQueue, nil},
+		{"first match drop", []model.Policy{block, ssh}, sshPacket, 0, nftcmd.ActionDrop, nil},
+		{"skip unmatched", []model.Policy{ssh, block}, httpPacket, 1, nftcmd.ActionDrop, nil},
+		{"warn", []model.Policy{warn}, httpPacket, 0, nftcmd.ActionQueue, nil},
+		{"no policy", nil, sshPacket, -1, nftcmd.ActionDrop, nil},
+		{"no match", []model.Policy{ssh, block}, otherPacket, -1, nftcmd.ActionDrop, nil},
+		{"limit continues", []model.Policy{limit, ssh}, sshPacket, 1, nftcmd.ActionQueue, []int{0}},
+		{"conn limit continues", []model.Policy{connLimit, limit, ssh}, sshPacket, 2, nftcmd.ActionQueue, []int{0, 1}},
+		{"limit without match", []model.Policy{limit}, sshPacket, -1, nftcmd.ActionDrop, []int{0}},
+		{"unmatched limit not recorded", []model.Policy{limit, block}, httpPacket, 1, nftcmd.ActionDrop, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SimulatePacket(tt.policys, tt.packet)
+			if err != nil {
+				t.Fatalf("SimulatePacket() error: %v", err)
+			}
+			if got.Index != tt.index || got.Matched != (tt.index >= 0) {
+				t.Fatalf("SimulatePacket() index = %d matched = %v, want %d", got.Index, got.Matched, tt.index)
+			}
+			if got.Verdict != string(tt.verdict) {
+				t.Fatalf("SimulatePacket() verdict = %s, want %s", got.Verdict, tt.verdict)
+			}
+			if tt.index >= 0 && got.Policy.Id != tt.policys[tt.index].Id {
+				t.Fatalf("SimulatePacket() policy = %s, want %s", got.Policy.Id, tt.policys[tt.index].Id)
+			}
+			if !reflect.DeepEqual(got.Limited, tt.limited) {
+				t.Fatalf("SimulatePacket() limited = %v, want %v", got.Limited, tt.limited)
+			}
+		})
+	}
+}
+
+func TestSimulatePacketLogPrefix(t *testing.T) {
+	policy := model.Policy{Id: "log", Protocol: "tcp", Action: nftcmd.WARN, LogTag: "web", LogSwitch: 1}
+	got, err := SimulatePacket([]model.Policy{policy}, model.Packet{Protocol: "tcp", SPort: 40000, DPort: 80})
+	if err != nil {
+		t.Fatalf("SimulatePacket() error: %v", err)
+	}
+	if got.LogPrefix != "web#W@L" {
+		t.Fatalf("SimulatePacket() log prefix = %q, want %q", got.LogPrefix, "web#W@L")
+	}
+}
+
+func TestSimulateConntrack(t *testing.T) {
+	all := model.ConntrackConfig{Established: true, Invalid: true}
+
+	tests := []struct {
+		name      string
+		config    model.ConntrackConfig
+		state     string
+		matched   bool
+		verdict   nftcmd.RuleAction
+		conntrack string
+	}{
+		{"established", all, "established", true, nftcmd.ActionAccept, nftcmd.CtEstablishedComment},
+		{"related", all, "related", true, nftcmd.ActionAccept, nftcmd.CtEstablishedComment},
+		{"invalid", all, "invalid", true, nftcmd.ActionDrop, nftcmd.CtInvalidComment},
+		{"new", all, "new", false, "", ""},
+		{"no state", all, "", false, "", ""},
+		{"established disabled", model.ConntrackConfig{Invalid: true}, "established", false, "", ""},
+		{"invalid disabled", model.ConntrackConfig{Established: true}, "invalid", false, "", ""},
+		{"no rules", model.ConntrackConfig{}, "established", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := model.Packet{SIp: "10.1.1.1", DIp: "192.168.1.1", Protocol: "tcp", SPort: 40000, DPort: 22, CtState: tt.state}
+			got, matched, err := SimulateConntrack(tt.config, packet)
+			if err != nil {
+				t.Fatalf("SimulateConntrack() error: %v", err)
+			}
+			if matched != tt.matched {
+				t.Fatalf("SimulateConntrack() matched = %v, want %v", matched, tt.matched)
+			}
+			if !matched {
+				return
+			}
+			if got.Index != -1 || got.Matched {
+				t.Fatalf("SimulateConntrack() index = %d matched = %v, want -1 and no policy", got.Index, got.Matched)
+			}
+			if got.Verdict != string(tt.verdict) || got.Conntrack != tt.conntrack {
+				t.Fatalf("SimulateConntrack() = %s %s, want %s %s", got.Verdict, got.Conntrack, tt.verdict, tt.conntrack)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	"netvine.com/firewall/server/service"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// simulateCommand 模拟报文匹配策略
+func simulateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "simulate",
+		Usage: "模拟报文匹配策略: policy simulate --iif eth0 --sip 192.168.0.1 --dip 10.0.0.1 --protocol tcp --dport 22",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{Name: "iif", Usage: "入接口: --iif eth0"},
+			&cli.StringFlag{Name: "oif", Usage: "出接口: --oif eth1"},
+			&cli.StringFlag{Name: "smac", Usage: "源MAC: --smac 0c:73:eb:92:80:cf"},
+			&cli.StringFlag{Name: "dmac", Usage: "目的MAC: --dmac 0c:73:eb:92:80:cf"},
+			&cli.StringFlag{Name: "sip", Usage: "源IP: --sip 192.168.0.1"},
+			&cli.StringFlag{Name: "dip", Usage: "目的IP: --dip 10.0.0.1"},
+			&cli.StringFlag{Name: "protocol", Aliases: []string{"p"}, Usage: "协议: --protocol tcp"},
+			&cli.StringFlag{Name: "icmp-type", Usage: "ICMP类型: --icmp-type echo-request"},
+			&cli.StringFlag{Name: "icmp-code", Usage: "ICMP代码: --icmp-code 0"},
+			&cli.StringFlag{Name: "tcp-flags", Usage: "报文中置位的TCP标志: --tcp-flags syn"},
+			&cli.IntFlag{Name: "sport", Usage: "源端口: --sport 40000"},
+			&cli.IntFlag{Name: "dport", Usage: "目的端口: --dport 22"},
+			&cli.StringFlag{Name: "time", Usage: "报文时间，默认当前时间: --time \"2022-11-22 18:30:00\""},
+			&cli.StringFlag{Name: "ct-state", Usage: "连接状态: --ct-state established"},
+			&cli.StringFlag{Name: "ct-direction", Usage: "连接方向: --ct-direction reply"},
+			&cli.StringFlag{Name: "ct-mark", Usage: "连接标记: --ct-mark 0x10"},
+		}, policySourceFlags...),
+		Action: simulatePacket,
+	}
+}
+
+// simulatePacket 模拟报文匹配
+func simulatePacket(cCtx *cli.Context) error {
+	packet := model.Packet{
+		IIfName:     cCtx.String("iif"),
+		OIfName:     cCtx.String("oif"),
+		SMac:        cCtx.String("smac"),
+		DMac:        cCtx.String("dmac"),
+		SIp:         cCtx.String("sip"),
+		DIp:         cCtx.String("dip"),
+		Protocol:    cCtx.String("protocol"),
+		IcmpType:    cCtx.String("icmp-type"),
+		IcmpCode:    cCtx.String("icmp-code"),
+		TcpFlags:    cCtx.String("tcp-flags"),
+		SPort:       cCtx.Int("sport"),
+		DPort:       cCtx.Int("dport"),
+		CtState:     cCtx.String("ct-state"),
+		CtDirection: cCtx.String("ct-direction"),
+		CtMark:      cCtx.String("ct-mark"),
+	}
+	if cCtx.IsSet("time") {
+		packetTime, err := time.ParseInLocation("2006-01-02 15:04:05", cCtx.String("time"), time.Local)
+		if err != nil {
+			return strerror.CreateError("time error:" + cCtx.String("time"))
+		}
+		packet.Time = packetTime
+	}
+
+	policys, err := loadPolicys(cCtx)
+	if err != nil {
+		return err
+	}
+	result, err := simulateKernelConntrack(cCtx, packet)
+	if err != nil {
+		return err
+	}
+	if len(result.Conntrack) == 0 {
+		result, err = service.SimulatePacket(policys, packet)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cCtx.Bool("json") {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(result.Conntrack) != 0 {
+		fmt.Printf("conntrack rule %s matched, verdict %s\n", result.Conntrack, result.Verdict)
+		return nil
+	}
+	for _, index := range result.Limited {
+		fmt.Printf("policy #%d id %s action %s when over limit %s\n", index, listValue(policys[index].Id),
+			nft.ActionName(policys[index].Action), limitValue(policys[index]))
+	}
+	if !result.Matched {
+		fmt.Printf("no policy matched, verdict %s\n", result.Verdict)
+		return nil
+	}
+	fmt.Printf("policy #%d id %s action %s, verdict %s, log %s\n", result.Index, listValue(result.Policy.Id),
+		nft.ActionName(result.Policy.Action), result.Verdict, listValue(result.LogPrefix))
+	return nil
+}
+
+// simulateKernelConntrack 策略由内核规则还原时，报文先匹配策略链最前面的连接跟踪规则
+func simulateKernelConntrack(cCtx *cli.Context, packet model.Packet) (model.SimulateResult, error) {
+	if cCtx.IsSet("file") || cCtx.Bool("store-policys") {
+		return model.SimulateResult{}, nil
+	}
+
+	conntrackService := service.ConntrackService{}
+	config, err := conntrackService.GetConfig("")
+	if err != nil {
+		return model.SimulateResult{}, err
+	}
+	result, _, err := service.SimulateConntrack(config, packet)
+	return result, err
+}
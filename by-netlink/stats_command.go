@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/model"
+)
+
+// policyStatsCommand 策略命中统计
+func policyStatsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "查看策略命中的报文数、字节数和最近命中时间",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "json", Usage: "以JSON格式输出"},
+		},
+		Action: listPolicyStats,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "reset",
+				Usage:     "清零策略的计数器，不指定策略ID时清零全部",
+				ArgsUsage: "[id]",
+				Action: func(cCtx *cli.Context) error {
+					return newPolicyStatsService(cCtx).Reset(cCtx.Args().First())
+				},
+			},
+		},
+	}
+}
+
+// listPolicyStats 查看每条策略的命中统计，最近命中时间由 serve 定期采样得到
+func listPolicyStats(cCtx *cli.Context) error {
+	stats, err := newPolicyStatsService(cCtx).Stats()
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		if stats == nil {
+			stats = []model.PolicyStats{}
+		}
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tVERSION\tPACKETS\tBYTES\tLAST HIT")
+	for _, stat := range stats {
+		packets, bytes, lastHit := "-", "-", "-"
+		if len(stat.Counter) != 0 {
+			packets, bytes = strconv.FormatUint(stat.Packets, 10), strconv.FormatUint(stat.Bytes, 10)
+		}
+		if !stat.LastHit.IsZero() {
+			lastHit = stat.LastHit.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", listValue(stat.PolicyId), stat.Version, packets, bytes, lastHit)
+	}
+	return w.Flush()
+}
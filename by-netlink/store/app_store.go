@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// DefaultAppPath 默认的自定义应用文件
+const DefaultAppPath = "/var/lib/fd/apps.json"
+
+// AppStore 本地JSON文件保存自定义应用
+type AppStore struct {
+	Path string
+}
+
+func NewAppStore(path string) *AppStore {
+	if len(path) == 0 {
+		path = DefaultAppPath
+	}
+	return &AppStore{Path: path}
+}
+
+// Load 读取自定义应用，文件不存在时返回空
+func (s *AppStore) Load() ([]model.App, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []model.App
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return nil, strerror.CreateError("app store error:" + s.Path + ": " + err.Error())
+	}
+	return apps, nil
+}
+
+// Update 加锁后读取、修改并保存自定义应用，命令行和serve同时修改时不会丢失更新
+func (s *AppStore) Update(update func(apps []model.App) ([]model.App, error)) error {
+	unlock, err := lockFile(s.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.Load()
+	if err != nil {
+		return err
+	}
+	apps, err := update(current)
+	if err != nil {
+		return err
+	}
+	return s.save(apps)
+}
+
+// save 保存全部自定义应用，调用者持有锁
+func (s *AppStore) save(apps []model.App) error {
+	data, err := json.MarshalIndent(apps, "", "\t")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.Path, data)
+}
+
+// SetApp 名称已存在时替换，否则追加到末尾，返回保存后的全部应用
+func SetApp(apps []model.App, app model.App) []model.App {
+	apps = append([]model.App{}, apps...)
+	for i, a := range apps {
+		if strings.EqualFold(a.Name, app.Name) {
+			apps[i] = app
+			return apps
+		}
+	}
+	return append(apps, app)
+}
+
+// DeleteApp 根据名称删除自定义应用
+func (s *AppStore) DeleteApp(name string) error {
+	return s.Update(func(current []model.App) ([]model.App, error) {
+		var apps []model.App
+		for _, a := range current {
+			if !strings.EqualFold(a.Name, name) {
+				apps = append(apps, a)
+			}
+		}
+		if len(apps) == len(current) {
+			return nil, strerror.CreateCodeError(strerror.CodeNotFound, "app not found:"+name)
+		}
+		return apps, nil
+	})
+}
@@ -0,0 +1,320 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// DefaultEventDir 默认的告警和安全日志目录
+const DefaultEventDir = "/var/lib/fd/events"
+
+// 段文件的默认大小、时长、保留时间和目录总大小
+const (
+	DefaultSegmentSize   = 16 * 1024 * 1024
+	DefaultSegmentAge    = 24 * time.Hour
+	DefaultRetention     = 30 * 24 * time.Hour
+	DefaultEventDirSize  = 1024 * 1024 * 1024
+	DefaultEventLimit    = 100
+	segmentSuffix        = ".jsonl"
+	pruneInterval        = time.Minute
+	segmentTimeTolerance = time.Minute // 事件时间是内核时间戳，与写入时间有偏差
+)
+
+// EventStore 追加写入的JSON行段文件，文件名是段的开始时间(纳秒)
+// 段文件超过大小或者时长后新建，超过保留时间、目录超过总大小时删除最早的段文件
+type EventStore struct {
+	Dir         string
+	SegmentSize int64
+	SegmentAge  time.Duration
+	Retention   time.Duration
+	MaxSize     int64
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	start  time.Time
+	pruned time.Time
+}
+
+func NewEventStore(dir string, retention time.Duration) *EventStore {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &EventStore{Dir: dir, SegmentSize: DefaultSegmentSize, SegmentAge: DefaultSegmentAge,
+		Retention: retention, MaxSize: DefaultEventDirSize}
+}
+
+// ParseEventTime 解析查询时间 2022-11-22 18:00:00 / 2022-11-22 / RFC3339
+func ParseEventTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, strerror.CreateError("event time error:" + value)
+}
+
+// segment 段文件和开始时间
+type segment struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+// segments 按开始时间排序的段文件
+func (s *EventStore) segments() ([]segment, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		nano, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{path: filepath.Join(s.Dir, name), start: time.Unix(0, nano), size: entry.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.Before(segments[j].start) })
+	return segments, nil
+}
+
+// open 打开最后一个段文件继续写入，超过大小或者时长时新建
+func (s *EventStore) open(now time.Time) error {
+	if s.file != nil {
+		if s.size < s.SegmentSize && now.Sub(s.start) < s.SegmentAge {
+			return nil
+		}
+		s.file.Close()
+		s.file = nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	path, start, size := filepath.Join(s.Dir, fmt.Sprintf("%019d%s", now.UnixNano(), segmentSuffix)), now, int64(0)
+	if len(segments) != 0 {
+		last := segments[len(segments)-1]
+		if last.size < s.SegmentSize && now.Sub(last.start) < s.SegmentAge {
+			path, start, size = last.path, last.start, last.size
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file, s.start, s.size = file, start, size
+	return nil
+}
+
+// Append 追加一个事件，每个事件一次写入一行
+func (s *EventStore) Append(event model.LogEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if err := s.open(now); err != nil {
+		return err
+	}
+	n, err := s.file.Write(append(data, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if now.Sub(s.pruned) >= pruneInterval {
+		s.pruned = now
+		return s.prune(now)
+	}
+	return nil
+}
+
+// prune 删除全部事件都超过保留时间的段文件，目录超过总大小时继续删除最早的段文件，正在写入的段文件不删除
+func (s *EventStore) prune(now time.Time) error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+	for i := 0; i < len(segments)-1; i++ {
+		expired := now.Sub(segments[i+1].start) > s.Retention
+		if !expired && total <= s.MaxSize {
+			break
+		}
+		if err := os.Remove(segments[i].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= segments[i].size
+	}
+	return nil
+}
+
+// Close 关闭正在写入的段文件
+func (s *EventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Query 从最新的段文件开始查找，按时间倒序返回符合条件的事件
+func (s *EventStore) Query(query model.EventQuery) ([]model.LogEvent, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultEventLimit
+	}
+	match, err := eventMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []model.LogEvent
+	for i := len(segments) - 1; i >= 0 && len(events) < limit; i-- {
+		// 段文件的时间范围是 [开始时间, 下一个段文件的开始时间)
+		if !query.Until.IsZero() && segments[i].start.After(query.Until.Add(segmentTimeTolerance)) {
+			continue
+		}
+		if !query.Since.IsZero() && i+1 < len(segments) && segments[i+1].start.Before(query.Since.Add(-segmentTimeTolerance)) {
+			break
+		}
+
+		matched, err := readSegment(segments[i].path, match)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(matched) - 1; j >= 0 && len(events) < limit; j-- {
+			events = append(events, matched[j])
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events, nil
+}
+
+// readSegment 读取段文件中符合条件的事件，段文件已被删除时返回空，无法解析的行(正在写入的最后一行)跳过
+func readSegment(path string, match func(model.LogEvent) bool) ([]model.LogEvent, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []model.LogEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event model.LogEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// eventMatcher 根据查询条件生成匹配函数，地址可以是单个地址或者网段
+func eventMatcher(query model.EventQuery) (func(model.LogEvent) bool, error) {
+	var ipNet *net.IPNet
+	if len(query.Ip) != 0 {
+		value := query.Ip
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, strerror.CreateError("event ip error:" + query.Ip)
+			}
+			value = ip.String() + "/128"
+			if ip.To4() != nil {
+				value = ip.String() + "/32"
+			}
+		}
+		var err error
+		if _, ipNet, err = net.ParseCIDR(value); err != nil {
+			return nil, strerror.CreateError("event ip error:" + query.Ip)
+		}
+	}
+	if query.Port < 0 || query.Port > 65535 {
+		return nil, strerror.CreateError("event port error:" + strconv.Itoa(query.Port))
+	}
+
+	containsIp := func(value string) bool {
+		ip := net.ParseIP(value)
+		return ip != nil && ipNet.Contains(ip)
+	}
+	return func(event model.LogEvent) bool {
+		if !query.Since.IsZero() && event.Time.Before(query.Since) {
+			return false
+		}
+		if !query.Until.IsZero() && event.Time.After(query.Until) {
+			return false
+		}
+		if len(query.PolicyId) != 0 && event.PolicyId != query.PolicyId {
+			return false
+		}
+		if len(query.Action) != 0 && !strings.EqualFold(event.Action, query.Action) {
+			return false
+		}
+		if query.Port != 0 && event.SPort != query.Port && event.DPort != query.Port {
+			return false
+		}
+		if ipNet != nil && !containsIp(event.SIp) && !containsIp(event.DIp) {
+			return false
+		}
+		return true
+	}, nil
+}
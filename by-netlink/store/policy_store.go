@@ -0,0 +1,262 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	"netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// DefaultPath 默认的策略文件
+const DefaultPath = "/var/lib/fd/policys.json"
+
+// 保留的历史版本数
+const historyCount = 10
+
+// Snapshot 策略文件内容，每次保存版本号加一
+type Snapshot struct {
+	Version   uint64         `json:"version"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Policys   []model.Policy `json:"policys"`
+}
+
+// PolicyStore 本地JSON文件保存策略，历史版本保存为 <Path>.<版本号>
+type PolicyStore struct {
+	Path string
+}
+
+func NewPolicyStore(path string) *PolicyStore {
+	if len(path) == 0 {
+		path = DefaultPath
+	}
+	return &PolicyStore{Path: path}
+}
+
+func (s *PolicyStore) historyPath(version uint64) string {
+	return s.Path + "." + strconv.FormatUint(version, 10)
+}
+
+func readSnapshot(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, strerror.CreateError("policy store error:" + path + ": " + err.Error())
+	}
+	// 旧版本保存的端口在读取时校验，不等到生成规则时才报错
+	for _, policy := range snapshot.Policys {
+		if err := nft.CheckPorts(policy); err != nil {
+			return snapshot, strerror.CreateError("policy store error:" + path + ": " + err.Error())
+		}
+	}
+	return snapshot, nil
+}
+
+// Exist 策略文件是否存在
+func (s *PolicyStore) Exist() bool {
+	_, err := os.Stat(s.Path)
+	return err == nil
+}
+
+// Load 读取当前策略，文件不存在时返回空的版本0
+func (s *PolicyStore) Load() (Snapshot, error) {
+	snapshot, err := readSnapshot(s.Path)
+	if os.IsNotExist(err) {
+		return Snapshot{}, nil
+	}
+	return snapshot, err
+}
+
+// LoadVersion 读取指定版本的策略，当前版本或历史版本
+func (s *PolicyStore) LoadVersion(version uint64) (Snapshot, error) {
+	current, err := s.Load()
+	if err != nil {
+		return current, err
+	}
+	if current.Version == version {
+		return current, nil
+	}
+
+	snapshot, err := readSnapshot(s.historyPath(version))
+	if os.IsNotExist(err) {
+		return snapshot, strerror.CreateCodeError(strerror.CodeNotFound, "policy store version not found:"+strconv.FormatUint(version, 10))
+	}
+	return snapshot, err
+}
+
+// Versions 历史版本号，从小到大
+func (s *PolicyStore) Versions() ([]uint64, error) {
+	matches, err := filepath.Glob(s.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []uint64
+	for _, match := range matches {
+		version, err := strconv.ParseUint(strings.TrimPrefix(match, s.Path+"."), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// lock 对策略文件加排它锁，命令行和serve可能同时修改策略文件
+func (s *PolicyStore) lock() (func(), error) {
+	return lockFile(s.Path)
+}
+
+// Save 保存全部策略，当前版本转为历史版本
+func (s *PolicyStore) Save(policys []model.Policy) (Snapshot, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer unlock()
+	return s.save(policys)
+}
+
+// save 先写临时文件，再把当前版本链接为历史版本，最后重命名临时文件
+// 任何一步失败或者断电时当前策略文件都保持完整，调用者持有锁
+func (s *PolicyStore) save(policys []model.Policy) (Snapshot, error) {
+	current, err := s.Load()
+	if err != nil {
+		return current, err
+	}
+
+	snapshot := Snapshot{Version: current.Version + 1, UpdatedAt: time.Now(), Policys: policys}
+	data, err := json.MarshalIndent(snapshot, "", "\t")
+	if err != nil {
+		return current, err
+	}
+
+	tmpPath := s.Path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return current, err
+	}
+
+	if current.Version != 0 {
+		if err := linkHistory(s.Path, s.historyPath(current.Version)); err != nil {
+			os.Remove(tmpPath)
+			return current, err
+		}
+	}
+
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		os.Remove(tmpPath)
+		return current, err
+	}
+
+	s.pruneHistory()
+	return snapshot, nil
+}
+
+// linkHistory 当前策略文件硬链接为历史版本，文件系统不支持硬链接时复制
+// 上次保存失败留下的同名历史版本先删除
+func linkHistory(path string, historyPath string) error {
+	if err := os.Remove(historyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, historyPath); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(historyPath, data)
+}
+
+// pruneHistory 删除多余的历史版本
+func (s *PolicyStore) pruneHistory() {
+	versions, err := s.Versions()
+	if err != nil {
+		fmt.Printf("policy store history error: %v\n", err)
+		return
+	}
+
+	for len(versions) > historyCount {
+		if err := os.Remove(s.historyPath(versions[0])); err != nil {
+			fmt.Printf("policy store history error: %v\n", err)
+		}
+		versions = versions[1:]
+	}
+}
+
+// SavePolicy 保存单条策略，策略ID已存在时替换，否则追加到末尾
+func (s *PolicyStore) SavePolicy(policy model.Policy) (Snapshot, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer unlock()
+
+	current, err := s.Load()
+	if err != nil {
+		return current, err
+	}
+
+	policy.Manager = ""
+	policys := append([]model.Policy{}, current.Policys...)
+	for i, p := range policys {
+		if len(policy.Id) != 0 && p.Id == policy.Id {
+			policys[i] = policy
+			return s.save(policys)
+		}
+	}
+	return s.save(append(policys, policy))
+}
+
+// DeletePolicy 根据策略ID删除策略
+func (s *PolicyStore) DeletePolicy(id string) (Snapshot, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer unlock()
+
+	current, err := s.Load()
+	if err != nil {
+		return current, err
+	}
+
+	var policys []model.Policy
+	for _, p := range current.Policys {
+		if p.Id != id {
+			policys = append(policys, p)
+		}
+	}
+	if len(policys) == len(current.Policys) {
+		return current, strerror.CreateCodeError(strerror.CodeNotFound, "policy not found:"+id)
+	}
+	return s.save(policys)
+}
+
+// GetPolicy 根据策略ID查找策略
+func (s *PolicyStore) GetPolicy(id string) (model.Policy, error) {
+	current, err := s.Load()
+	if err != nil {
+		return model.Policy{}, err
+	}
+
+	for _, p := range current.Policys {
+		if p.Id == id {
+			return p, nil
+		}
+	}
+	return model.Policy{}, strerror.CreateCodeError(strerror.CodeNotFound, "policy not found:"+id)
+}
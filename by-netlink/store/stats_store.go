@@ -0,0 +1,76 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// DefaultStatsPath 默认的命中统计采样文件
+const DefaultStatsPath = "/var/lib/fd/stats.json"
+
+// CounterSample 计数器最近一次采样的报文数和发现计数增加的时间
+type CounterSample struct {
+	Packets uint64    `json:"packets"`
+	LastHit time.Time `json:"lastHit"`
+}
+
+// StatsStore 本地JSON文件保存计数器采样，按计数器名称索引
+type StatsStore struct {
+	Path string
+}
+
+func NewStatsStore(path string) *StatsStore {
+	if len(path) == 0 {
+		path = DefaultStatsPath
+	}
+	return &StatsStore{Path: path}
+}
+
+// Load 读取采样，文件不存在时返回空
+func (s *StatsStore) Load() (map[string]CounterSample, error) {
+	samples := make(map[string]CounterSample)
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return samples, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, strerror.CreateError("stats store error:" + s.Path + ": " + err.Error())
+	}
+	return samples, nil
+}
+
+// Update 加锁后读取、修改并保存采样，命令行和serve同时修改时不会丢失更新
+func (s *StatsStore) Update(update func(samples map[string]CounterSample) (map[string]CounterSample, error)) error {
+	unlock, err := lockFile(s.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.Load()
+	if err != nil {
+		return err
+	}
+	samples, err := update(current)
+	if err != nil {
+		return err
+	}
+	return s.save(samples)
+}
+
+// save 保存全部采样，调用者持有锁
+func (s *StatsStore) save(samples map[string]CounterSample) error {
+	data, err := json.MarshalIndent(samples, "", "\t")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.Path, data)
+}
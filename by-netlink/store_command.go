@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"netvine.com/firewall/server/service"
+	"netvine.com/firewall/server/store"
+)
+
+// restoreCommand 将策略文件重新下发到内核
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "将策略文件重新下发到内核，开机时使用 restore --boot",
+		UsageText: "restore [--version 3] [--boot]",
+		Flags: []cli.Flag{
+			&cli.Uint64Flag{Name: "version", Usage: "恢复指定的历史版本"},
+			&cli.BoolFlag{Name: "boot", Usage: "开机一次性执行，策略文件不存在时直接退出"},
+		},
+		Action: restorePolicys,
+	}
+}
+
+// restorePolicys 将策略文件中的策略重新下发到内核
+func restorePolicys(cCtx *cli.Context) error {
+	policyStore := newPolicyStore(cCtx)
+
+	// 开机时还没有保存过策略，不算失败
+	if cCtx.Bool("boot") && !policyStore.Exist() {
+		fmt.Printf("policy store %s not exist, skip restore\n", policyStore.Path)
+		return nil
+	}
+
+	var snapshot store.Snapshot
+	var err error
+	if cCtx.IsSet("version") {
+		snapshot, err = policyStore.LoadVersion(cCtx.Uint64("version"))
+	} else {
+		snapshot, err = policyStore.Load()
+	}
+	if err != nil {
+		return err
+	}
+
+	backend, err := newRuleBackend(cCtx)
+	if err != nil {
+		return err
+	}
+
+	if err := service.SyncPolicys(backend, snapshot.Policys); err != nil {
+		return err
+	}
+	fmt.Printf("restore %d policys, version %d\n", len(snapshot.Policys), snapshot.Version)
+
+	// 恢复历史版本后，该版本成为当前版本
+	if cCtx.IsSet("version") {
+		_, err = policyStore.Save(snapshot.Policys)
+	}
+	return err
+}
+
+// storeCommand 查看策略文件和历史版本
+func storeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "store",
+		Usage: "策略文件",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "show",
+				Usage: "查看保存的策略",
+				Flags: []cli.Flag{
+					&cli.Uint64Flag{Name: "version", Usage: "查看指定的历史版本"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					policyStore := newPolicyStore(cCtx)
+
+					var snapshot store.Snapshot
+					var err error
+					if cCtx.IsSet("version") {
+						snapshot, err = policyStore.LoadVersion(cCtx.Uint64("version"))
+					} else {
+						snapshot, err = policyStore.Load()
+					}
+					if err != nil {
+						return err
+					}
+
+					bs, err := json.MarshalIndent(snapshot, "", "\t")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(bs))
+					return nil
+				},
+			},
+			{
+				Name:  "versions",
+				Usage: "查看历史版本",
+				Action: func(cCtx *cli.Context) error {
+					policyStore := newPolicyStore(cCtx)
+					versions, err := policyStore.Versions()
+					if err != nil {
+						return err
+					}
+
+					current, err := policyStore.Load()
+					if err != nil {
+						return err
+					}
+					for _, version := range versions {
+						fmt.Println(version)
+					}
+					fmt.Printf("%d (current)\n", current.Version)
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	suricatarules "netvine.com/firewall/server/utils/suricata_rules"
+
+	"github.com/urfave/cli/v2"
+)
+
+// whitelistCommand suricata白名单
+func whitelistCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "whitelist",
+		Aliases: []string{"wl"},
+		Usage:   "白名单模块",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "增加白名单",
+				Action: func(cCtx *cli.Context) error {
+					rule := cCtx.Args().First()
+					fmt.Println("新增白名单规则:", rule)
+					suricatarules.AddWhiteList(rule)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "查看白名单",
+				Action: func(cCtx *cli.Context) error {
+					fmt.Println("白名单列表为:", cCtx.Args().First())
+					return nil
+				},
+			},
+			{
+				Name:  "del",
+				Usage: "清空所有白名单",
+				Action: func(cCtx *cli.Context) error {
+					fmt.Println("白名单已清空")
+					suricatarules.DelWhiteList()
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// suricataCommand suricata规则
+func suricataCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "suricata",
+		Aliases: []string{"sc"},
+		Usage:   "suricata",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "reload",
+				Usage: "规则重载",
+				Action: func(cCtx *cli.Context) error {
+					fmt.Println("suricata 规则重载")
+					suricatarules.ReloadRules()
+					return nil
+				},
+			},
+		},
+	}
+}
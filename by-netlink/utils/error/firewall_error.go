@@ -1,13 +1,40 @@
 package strerror
 
+// ErrorCode 错误类型，接口根据错误类型返回对应的状态码
+type ErrorCode int
+
+const (
+	CodeInvalid  ErrorCode = iota // 参数错误
+	CodeNotFound                  // 不存在
+	CodeConflict                  // 已存在
+	CodeInternal                  // 内部错误
+)
+
 type FDStringError struct {
 	name string
+	code ErrorCode
 }
 
 func (e *FDStringError) Error() string {
 	return e.name
 }
 
+func (e *FDStringError) Code() ErrorCode {
+	return e.code
+}
+
 func CreateError(name string) error {
-	return &FDStringError{name}
+	return &FDStringError{name: name}
+}
+
+func CreateCodeError(code ErrorCode, name string) error {
+	return &FDStringError{name: name, code: code}
+}
+
+// GetErrorCode 程序自己生成的错误默认为参数错误，其他错误(系统调用、netlink等)为内部错误
+func GetErrorCode(err error) ErrorCode {
+	if e, ok := err.(*FDStringError); ok {
+		return e.code
+	}
+	return CodeInternal
 }
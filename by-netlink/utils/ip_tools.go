@@ -1,60 +1,195 @@
 package iptools
 
 import (
-	"encoding/binary"
-	"math"
+	"bytes"
 	"net"
-	strerror "netvine.com/firewall/server/utils/error"
+	"sort"
+	"strconv"
 	"strings"
+
+	strerror "netvine.com/firewall/server/utils/error"
 )
 
-// GetCidrIpRange 计算ip范围
+// GetCidrIpRange 计算ip范围，支持IPv4和IPv6
 func GetCidrIpRange(ipStr string) (net.IP, net.IP, error) {
 	_, ipNet, err := net.ParseCIDR(ipStr)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ip := ipNet.IP.To4()
-	var min, max uint32
-	for i := 0; i < 4; i++ {
-		b := uint32(ip[i] & ipNet.Mask[i])
-		min += b << ((3 - uint(i)) * 8)
+	// IPv4网段的IP和掩码都是4字节
+	ip := ipNet.IP
+	if ip4 := ip.To4(); ip4 != nil && len(ipNet.Mask) == net.IPv4len {
+		ip = ip4
 	}
-	one, _ := ipNet.Mask.Size()
-	max = min | uint32(math.Pow(2, float64(32-one))-1)
 
-	ipStart := make(net.IP, 4)
-	ipEnd := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ipStart, uint32(min))
-	binary.BigEndian.PutUint32(ipEnd, uint32(max))
+	ipStart := make(net.IP, len(ip))
+	ipEnd := make(net.IP, len(ip))
+	for i := 0; i < len(ip); i++ {
+		ipStart[i] = ip[i] & ipNet.Mask[i]
+		ipEnd[i] = ip[i] | ^ipNet.Mask[i]
+	}
 
 	return ipStart, ipEnd, nil
 }
 
+// parseIp IPv4返回4字节，IPv6返回16字节
+func parseIp(ipStr string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(ipStr))
+	if ip == nil {
+		return nil, strerror.CreateError("ip error:" + ipStr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
 // GetIpBytes 获取ip地址或者范围
 func GetIpBytes(ip string) ([]byte, []byte, error) {
 	var startIp []byte
 	var endIp []byte
 
-	if strings.Contains(ip, "-") { // 192.168.0.1-192.168.0.255
+	if strings.Contains(ip, "-") { // 192.168.0.1-192.168.0.255 / 2001:db8::1-2001:db8::ff
 		ipRange := strings.Split(ip, "-")
-		if len(ipRange) < 2 {
+		if len(ipRange) != 2 {
 			return startIp, endIp, strerror.CreateError("ip range error")
 		}
-		startIp = net.ParseIP(ipRange[0]).To4()
-		endIp = net.ParseIP(ipRange[1]).To4()
+		start, err := parseIp(ipRange[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		end, err := parseIp(ipRange[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(start) != len(end) || bytes.Compare(start, end) > 0 {
+			return nil, nil, strerror.CreateError("ip range error:" + ip)
+		}
+		startIp = start
+		endIp = end
 
-	} else if strings.Contains(ip, "/") { // 192.168.0.1/24
+	} else if strings.Contains(ip, "/") { // 192.168.0.1/24 / 2001:db8::/64
 		startIpNet, endIpNet, err := GetCidrIpRange(ip)
 		if err != nil {
 			return nil, nil, err
 		}
-		startIp = startIpNet.To4()
-		endIp = endIpNet.To4()
+		startIp = startIpNet
+		endIp = endIpNet
 
-	} else { // 独立ip地址 192.168.0.1
-		startIp = net.ParseIP(ip).To4()
+	} else { // 独立ip地址 192.168.0.1 / 2001:db8::1
+		start, err := parseIp(ip)
+		if err != nil {
+			return nil, nil, err
+		}
+		startIp = start
 	}
 	return startIp, endIp, nil
 }
+
+// IsIPv6 判断地址、范围、网段是否是IPv6
+func IsIPv6(ip string) (bool, error) {
+	value := ip
+	if index := strings.IndexAny(value, "-/"); index != -1 {
+		value = value[:index]
+	}
+
+	parsed, err := parseIp(value)
+	if err != nil {
+		return false, err
+	}
+	return len(parsed) == net.IPv6len, nil
+}
+
+// SplitIpFamily 按IPv4/IPv6拆分地址
+func SplitIpFamily(values []string) ([]string, []string, error) {
+	var ipv4, ipv6 []string
+	for _, value := range values {
+		isIPv6, err := IsIPv6(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isIPv6 {
+			ipv6 = append(ipv6, value)
+		} else {
+			ipv4 = append(ipv4, value)
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// NextIp 地址加一，溢出时返回false
+func NextIp(ip []byte) ([]byte, bool) {
+	next := make([]byte, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next, true
+		}
+	}
+	return next, false
+}
+
+type IpRange struct {
+	Start []byte
+	End   []byte
+}
+
+// GetIpRanges 解析地址列表为有序、不重叠的地址段
+func GetIpRanges(values []string) ([]IpRange, error) {
+	var ranges []IpRange
+	for _, value := range values {
+		start, end, err := GetIpBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(end) == 0 {
+			end = start
+		}
+		ranges = append(ranges, IpRange{Start: start, End: end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].Start, ranges[j].Start) < 0
+	})
+
+	// 合并重叠、相邻的地址段
+	var merged []IpRange
+	for _, r := range ranges {
+		if len(merged) != 0 {
+			last := &merged[len(merged)-1]
+			next, ok := NextIp(last.End)
+			if !ok || bytes.Compare(r.Start, next) <= 0 {
+				if bytes.Compare(r.End, last.End) > 0 {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// FormatIpRange 地址段转换为字符串，单个地址 192.168.0.1，网段 192.168.0.0/24，其他 192.168.0.1-192.168.0.100
+func FormatIpRange(start []byte, end []byte) string {
+	if bytes.Equal(start, end) {
+		return net.IP(start).String()
+	}
+
+	bits := len(start) * 8
+	for ones := 0; ones < bits; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		network := make(net.IP, len(start))
+		broadcast := make(net.IP, len(start))
+		for i := range start {
+			network[i] = start[i] & mask[i]
+			broadcast[i] = start[i] | ^mask[i]
+		}
+		if bytes.Equal(network, start) && bytes.Equal(broadcast, end) {
+			return network.String() + "/" + strconv.Itoa(ones)
+		}
+	}
+	return net.IP(start).String() + "-" + net.IP(end).String()
+}
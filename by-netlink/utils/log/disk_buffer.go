@@ -0,0 +1,185 @@
+package tail_log
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 磁盘缓冲的段文件大小和后缀
+const (
+	bufferSegmentSize = 1024 * 1024
+	bufferSuffix      = ".buf"
+)
+
+// DiskBuffer 日志服务器不可用时保存待发送的消息，每行一条消息
+// 超过 MaxSize 时删除最早的段文件，只由发送协程使用，不支持并发
+type DiskBuffer struct {
+	Dir     string
+	MaxSize int64
+
+	file *os.File
+	size int64
+	seq  uint64
+}
+
+func NewDiskBuffer(dir string, maxSize int64) *DiskBuffer {
+	return &DiskBuffer{Dir: dir, MaxSize: maxSize}
+}
+
+// segments 按写入顺序排列的段文件
+func (b *DiskBuffer) segments() ([]string, error) {
+	entries, err := ioutil.ReadDir(b.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), bufferSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Empty 是否没有待发送的消息
+func (b *DiskBuffer) Empty() bool {
+	segments, err := b.segments()
+	return err == nil && len(segments) == 0
+}
+
+// Push 追加一条消息，消息中的换行替换为空格
+func (b *DiskBuffer) Push(message string) error {
+	if b.file == nil || b.size >= bufferSegmentSize {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+	}
+
+	line := strings.NewReplacer("\r", " ", "\n", " ").Replace(message) + "\n"
+	n, err := b.file.WriteString(line)
+	b.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return b.limit()
+}
+
+// rotate 新建段文件，文件名按时间和序号排序
+func (b *DiskBuffer) rotate() error {
+	b.closeFile()
+	if err := os.MkdirAll(b.Dir, 0755); err != nil {
+		return err
+	}
+
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+	if len(segments) != 0 {
+		fmt.Sscanf(segments[len(segments)-1], "%d", &b.seq)
+	}
+	b.seq++
+
+	file, err := os.OpenFile(filepath.Join(b.Dir, fmt.Sprintf("%020d%s", b.seq, bufferSuffix)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	b.file, b.size = file, 0
+	return nil
+}
+
+// limit 超过总大小时删除最早的段文件，正在写入的段文件不删除
+func (b *DiskBuffer) limit() error {
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(segments))
+	for i, name := range segments {
+		if info, err := os.Stat(filepath.Join(b.Dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; i < len(segments)-1 && total > b.MaxSize; i++ {
+		if err := os.Remove(filepath.Join(b.Dir, segments[i])); err != nil {
+			return err
+		}
+		total -= sizes[i]
+		fmt.Printf("syslog buffer full, drop segment %s\n", segments[i])
+	}
+	return nil
+}
+
+func (b *DiskBuffer) closeFile() {
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+}
+
+// Drain 按写入顺序发送全部消息，发送失败时保留未发送的消息
+func (b *DiskBuffer) Drain(send func(message string) error) error {
+	b.closeFile()
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range segments {
+		path := filepath.Join(b.Dir, name)
+		lines, err := readLines(path)
+		if err != nil {
+			return err
+		}
+		for i, line := range lines {
+			if err := send(line); err != nil {
+				// 已发送的消息从段文件中去掉
+				if writeErr := ioutil.WriteFile(path, []byte(strings.Join(lines[i:], "\n")+"\n"), 0600); writeErr != nil {
+					fmt.Printf("syslog buffer rewrite failed: %v\n", writeErr)
+				}
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭正在写入的段文件，未发送的消息保留到下次启动
+func (b *DiskBuffer) Close() error {
+	b.closeFile()
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) != 0 {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package tail_log
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"netvine.com/firewall/server/model"
+)
+
+// 消息类型，作为syslog的MSGID
+const (
+	msgIdEvent = "event"
+	msgIdAudit = "audit"
+)
+
+// AuditSink 审计事件的输出
+type AuditSink interface {
+	WriteAudit(audit model.AuditEvent) error
+}
+
+// FileOutput 每条消息一行追加到文件，不带syslog头部
+type FileOutput struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenFileOutput(path string) (*FileOutput, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOutput{file: file}, nil
+}
+
+func (f *FileOutput) Send(message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.file.WriteString(message.Text + "\n")
+	return err
+}
+
+func (f *FileOutput) Close() error {
+	return f.file.Close()
+}
+
+// Exporter 按格式导出日志事件和审计事件，实现 Sink 和 AuditSink
+type Exporter struct {
+	Format Format
+	Output Output
+}
+
+func (e *Exporter) Write(event model.LogEvent) error {
+	text, err := FormatEvent(e.Format, event)
+	if err != nil {
+		return err
+	}
+	return e.Output.Send(Message{Time: event.Time, Severity: eventSeverity(event), MsgId: msgIdEvent, Text: text})
+}
+
+func (e *Exporter) WriteAudit(audit model.AuditEvent) error {
+	text, err := FormatAudit(e.Format, audit)
+	if err != nil {
+		return err
+	}
+	return e.Output.Send(Message{Time: audit.Time, Severity: SeverityNotice, MsgId: msgIdAudit, Text: text})
+}
+
+// Exporters 同时导出到多个目的地，返回第一个错误
+type Exporters []*Exporter
+
+func (e Exporters) Write(event model.LogEvent) error {
+	var first error
+	for _, exporter := range e {
+		if err := exporter.Write(event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (e Exporters) WriteAudit(audit model.AuditEvent) error {
+	var first error
+	for _, exporter := range e {
+		if err := exporter.WriteAudit(audit); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close 关闭全部目的地，syslog会先发送队列中剩余的消息
+func (e Exporters) Close() error {
+	var first error
+	for _, exporter := range e {
+		if err := exporter.Output.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,215 @@
+package tail_log
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// Format 导出事件的格式
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatCEF  Format = "cef"  // ArcSight Common Event Format
+	FormatLEEF Format = "leef" // QRadar Log Event Extended Format 2.0
+)
+
+// CEF、LEEF头部中的厂商、产品和版本
+const (
+	deviceVendor  = "Netvine"
+	deviceProduct = "fd-cmd"
+	deviceVersion = "1.0"
+)
+
+// syslog 严重级别
+const (
+	SeverityWarning = 4
+	SeverityNotice  = 5
+	SeverityInfo    = 6
+)
+
+// ParseFormat 解析导出格式 json / cef / leef
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(value)); format {
+	case FormatJSON, FormatCEF, FormatLEEF:
+		return format, nil
+	}
+	return "", strerror.CreateError("export format error:" + value)
+}
+
+// field 扩展字段，值为空时不输出
+type field struct {
+	key   string
+	value string
+}
+
+// eventSeverity 告警为warning，阻断为notice，其他为info
+func eventSeverity(event model.LogEvent) int {
+	switch {
+	case event.Warn:
+		return SeverityWarning
+	case event.Action == "drop":
+		return SeverityNotice
+	}
+	return SeverityInfo
+}
+
+// cefSeverity syslog严重级别转换为CEF的0-10
+func cefSeverity(severity int) int {
+	switch severity {
+	case SeverityWarning:
+		return 7
+	case SeverityNotice:
+		return 5
+	}
+	return 3
+}
+
+func portValue(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+// FormatEvent 按格式生成日志事件的文本，不包含换行
+func FormatEvent(format Format, event model.LogEvent) (string, error) {
+	name := event.LogTag
+	if len(name) == 0 {
+		name = "firewall log"
+	}
+	source := event.Source
+	if len(source) == 0 {
+		source = "log"
+	}
+	severity := eventSeverity(event)
+
+	switch format {
+	case FormatCEF:
+		fields := []field{
+			{"rt", strconv.FormatInt(event.Time.UnixNano()/int64(time.Millisecond), 10)},
+			{"act", event.Action},
+			{"src", event.SIp}, {"dst", event.DIp},
+			{"spt", portValue(event.SPort)}, {"dpt", portValue(event.DPort)},
+			{"proto", event.Protocol},
+			{"smac", event.SMac}, {"dmac", event.DMac},
+			{"deviceInboundInterface", event.IIfName}, {"deviceOutboundInterface", event.OIfName},
+		}
+		fields = append(fields, cefString(1, "policyId", event.PolicyId)...)
+		fields = append(fields, cefString(2, "policyVersion", event.Version)...)
+		fields = append(fields, cefString(3, "logTag", event.LogTag)...)
+		return cefLine(source, name, severity, fields), nil
+
+	case FormatLEEF:
+		fields := []field{
+			{"devTime", event.Time.Format(leefTimeLayout)}, {"devTimeFormat", leefTimeFormat},
+			{"cat", source}, {"sev", strconv.Itoa(cefSeverity(severity))},
+			{"action", event.Action},
+			{"src", event.SIp}, {"dst", event.DIp},
+			{"srcPort", portValue(event.SPort)}, {"dstPort", portValue(event.DPort)},
+			{"proto", event.Protocol},
+			{"srcMAC", event.SMac}, {"dstMAC", event.DMac},
+			{"inInterface", event.IIfName}, {"outInterface", event.OIfName},
+			{"policyId", event.PolicyId}, {"policyVersion", event.Version}, {"logTag", event.LogTag},
+		}
+		return leefLine(source, fields), nil
+	}
+
+	data, err := json.Marshal(event)
+	return string(data), err
+}
+
+// FormatAudit 按格式生成审计事件的文本，不包含换行
+func FormatAudit(format Format, audit model.AuditEvent) (string, error) {
+	name := audit.Method + " " + audit.Path
+	switch format {
+	case FormatCEF:
+		fields := []field{
+			{"rt", strconv.FormatInt(audit.Time.UnixNano()/int64(time.Millisecond), 10)},
+			{"src", audit.Remote},
+			{"requestMethod", audit.Method}, {"request", audit.Path},
+			{"outcome", strconv.Itoa(audit.Status)},
+		}
+		fields = append(fields, cefString(1, "policyId", audit.PolicyId)...)
+		return cefLine("audit", name, SeverityNotice, fields), nil
+
+	case FormatLEEF:
+		fields := []field{
+			{"devTime", audit.Time.Format(leefTimeLayout)}, {"devTimeFormat", leefTimeFormat},
+			{"cat", "audit"}, {"sev", strconv.Itoa(cefSeverity(SeverityNotice))},
+			{"src", audit.Remote},
+			{"method", audit.Method}, {"url", audit.Path},
+			{"status", strconv.Itoa(audit.Status)},
+			{"policyId", audit.PolicyId},
+		}
+		return leefLine("audit", fields), nil
+	}
+
+	data, err := json.Marshal(audit)
+	return string(data), err
+}
+
+// cefString 自定义字符串字段 cs1Label=policyId cs1=policy1，值为空时不输出
+func cefString(index int, label string, value string) []field {
+	if len(value) == 0 {
+		return nil
+	}
+	key := "cs" + strconv.Itoa(index)
+	return []field{{key + "Label", label}, {key, value}}
+}
+
+// cefHeaderEscaper CEF头部转义 \ 和 |
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+// cefValueEscaper CEF扩展字段转义 \ 和 =，换行转为 \n
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// cefLine CEF:0|Netvine|fd-cmd|1.0|policy|log1122|7|rt=... src=...
+func cefLine(signature string, name string, severity int, fields []field) string {
+	var b strings.Builder
+	b.WriteString("CEF:0|" + deviceVendor + "|" + deviceProduct + "|" + deviceVersion + "|")
+	b.WriteString(cefHeaderEscaper.Replace(signature) + "|" + cefHeaderEscaper.Replace(name) + "|")
+	b.WriteString(strconv.Itoa(cefSeverity(severity)) + "|")
+
+	var extensions []string
+	for _, f := range fields {
+		if len(f.value) != 0 {
+			extensions = append(extensions, f.key+"="+cefValueEscaper.Replace(f.value))
+		}
+	}
+	b.WriteString(strings.Join(extensions, " "))
+	return b.String()
+}
+
+// LEEF的时间格式，devTimeFormat 使用Java SimpleDateFormat
+const (
+	leefTimeLayout = "Jan 02 2006 15:04:05.000 -0700"
+	leefTimeFormat = "MMM dd yyyy HH:mm:ss.SSS Z"
+)
+
+// leefHeaderEscaper LEEF头部不能包含 |
+var leefHeaderEscaper = strings.NewReplacer("|", " ", "\r", " ", "\n", " ")
+
+// leefValueEscaper LEEF属性之间用制表符分隔，值中的制表符和换行替换为空格
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// leefLine LEEF:2.0|Netvine|fd-cmd|1.0|policy|x09|devTime=...<tab>src=...，x09表示属性之间用制表符分隔
+func leefLine(eventId string, fields []field) string {
+	var b strings.Builder
+	b.WriteString("LEEF:2.0|" + deviceVendor + "|" + deviceProduct + "|" + deviceVersion + "|")
+	b.WriteString(leefHeaderEscaper.Replace(eventId) + "|x09|")
+
+	var attributes []string
+	for _, f := range fields {
+		if len(f.value) != 0 {
+			attributes = append(attributes, f.key+"="+leefValueEscaper.Replace(f.value))
+		}
+	}
+	b.WriteString(strings.Join(attributes, "\t"))
+	return b.String()
+}
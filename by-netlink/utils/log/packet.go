@@ -0,0 +1,121 @@
+package tail_log
+
+import (
+	"encoding/binary"
+	"net"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// IPv6扩展头，解析传输层时跳过
+const (
+	ipv6HopByHop = 0
+	ipv6Routing  = 43
+	ipv6Fragment = 44
+	ipv6AH       = 51
+	ipv6DestOpts = 60
+)
+
+// 带有端口的传输层协议
+const (
+	protoTCP     = 6
+	protoUDP     = 17
+	protoDCCP    = 33
+	protoUDPLite = 136
+	protoSCTP    = 132
+)
+
+// Header 报文的网络层和传输层头部
+type Header struct {
+	SIp      net.IP
+	DIp      net.IP
+	Protocol uint8
+	SPort    uint16 // 没有端口的协议、非首个分片为0
+	DPort    uint16
+	Length   int // IP头部中的报文长度
+}
+
+// DecodeHeader 解析IPv4/IPv6头部和传输层端口，报文只复制了头部，不校验报文长度
+func DecodeHeader(payload []byte) (Header, error) {
+	var header Header
+	if len(payload) == 0 {
+		return header, strerror.CreateError("empty payload")
+	}
+
+	var transport []byte
+	switch payload[0] >> 4 {
+	case 4:
+		if len(payload) < 20 {
+			return header, strerror.CreateError("short ipv4 header")
+		}
+		ihl := int(payload[0]&0x0f) * 4
+		if ihl < 20 || len(payload) < ihl {
+			return header, strerror.CreateError("ipv4 header length error")
+		}
+		header.SIp = net.IP(payload[12:16])
+		header.DIp = net.IP(payload[16:20])
+		header.Protocol = payload[9]
+		header.Length = int(binary.BigEndian.Uint16(payload[2:]))
+		// 非首个分片没有传输层头部
+		if binary.BigEndian.Uint16(payload[6:])&0x1fff == 0 {
+			transport = payload[ihl:]
+		}
+
+	case 6:
+		if len(payload) < 40 {
+			return header, strerror.CreateError("short ipv6 header")
+		}
+		header.SIp = net.IP(payload[8:24])
+		header.DIp = net.IP(payload[24:40])
+		header.Length = 40 + int(binary.BigEndian.Uint16(payload[4:]))
+		header.Protocol, transport = skipIPv6Extensions(payload[6], payload[40:])
+
+	default:
+		return header, strerror.CreateError("unknown ip version")
+	}
+
+	switch header.Protocol {
+	case protoTCP, protoUDP, protoDCCP, protoUDPLite, protoSCTP:
+		if len(transport) >= 4 {
+			header.SPort = binary.BigEndian.Uint16(transport)
+			header.DPort = binary.BigEndian.Uint16(transport[2:])
+		}
+	}
+	return header, nil
+}
+
+// skipIPv6Extensions 跳过扩展头，返回传输层协议和头部，非首个分片时头部为空
+func skipIPv6Extensions(next uint8, data []byte) (uint8, []byte) {
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOpts:
+			if len(data) < 8 {
+				return next, nil
+			}
+			length := (int(data[1]) + 1) * 8
+			if len(data) < length {
+				return data[0], nil
+			}
+			next, data = data[0], data[length:]
+		case ipv6AH:
+			if len(data) < 8 {
+				return next, nil
+			}
+			length := (int(data[1]) + 2) * 4
+			if len(data) < length {
+				return data[0], nil
+			}
+			next, data = data[0], data[length:]
+		case ipv6Fragment:
+			if len(data) < 8 {
+				return next, nil
+			}
+			if binary.BigEndian.Uint16(data[2:])&0xfff8 != 0 {
+				return data[0], nil
+			}
+			next, data = data[0], data[8:]
+		default:
+			return next, data
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tail_log
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"netvine.com/firewall/server/model"
+)
+
+// Sink 日志事件的输出，可以是文件、标准输出或者告警存储
+type Sink interface {
+	Write(event model.LogEvent) error
+}
+
+// SinkFunc 函数作为Sink
+type SinkFunc func(event model.LogEvent) error
+
+func (f SinkFunc) Write(event model.LogEvent) error {
+	return f(event)
+}
+
+// MultiSink 依次写入多个Sink，返回第一个错误，其他Sink继续写入
+type MultiSink []Sink
+
+func (m MultiSink) Write(event model.LogEvent) error {
+	var first error
+	for _, sink := range m {
+		if err := sink.Write(event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// JSONLineSink 每个事件输出一行JSON
+type JSONLineSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONLineSink(w io.Writer) *JSONLineSink {
+	return &JSONLineSink{w: w}
+}
+
+func (s *JSONLineSink) Write(event model.LogEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// OpenFileSink 以追加方式打开JSON行文件，返回的文件由调用方关闭
+func OpenFileSink(path string) (*JSONLineSink, *os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewJSONLineSink(file), file, nil
+}
@@ -0,0 +1,261 @@
+package tail_log
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 日志服务器的传输方式
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+)
+
+// DefaultFacility syslog设施 local0
+const DefaultFacility = 16
+
+// 发送队列长度、超时和重连的退避时间
+const (
+	syslogQueueSize  = 4096
+	syslogTimeout    = 5 * time.Second
+	minBackoff       = time.Second
+	maxBackoff       = time.Minute
+	syslogAppName    = "fd-cmd"
+	syslogTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Message 待导出的一条消息
+type Message struct {
+	Time     time.Time
+	Severity int
+	MsgId    string // event / audit
+	Text     string // 按格式生成的事件文本
+}
+
+// Output 导出消息的目的地
+type Output interface {
+	Send(message Message) error
+	Close() error
+}
+
+// ParseSyslogAddr 解析日志服务器地址 udp://10.0.0.1:514 / tcp://10.0.0.1:601 / tls://10.0.0.1:6514
+func ParseSyslogAddr(value string) (network string, addr string, err error) {
+	u, err := url.Parse(value)
+	if err != nil || len(u.Host) == 0 {
+		return "", "", strerror.CreateError("syslog address error:" + value)
+	}
+	switch u.Scheme {
+	case NetworkUDP, NetworkTCP, NetworkTLS:
+	default:
+		return "", "", strerror.CreateError("syslog network error:" + value)
+	}
+	if len(u.Port()) == 0 {
+		return "", "", strerror.CreateError("syslog port is required:" + value)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// SyslogConfig 日志服务器配置
+type SyslogConfig struct {
+	Network  string      // udp / tcp / tls
+	Addr     string      // 地址和端口
+	TLS      *tls.Config // tls方式的证书配置
+	Facility int         // syslog设施
+	Buffer   *DiskBuffer // 日志服务器不可用时保存消息，为空时丢弃
+}
+
+// SyslogOutput 按RFC 5424发送消息，tcp和tls按RFC 6587使用长度前缀分帧
+// 消息先进入队列由发送协程发送，连接失败时按指数退避重连，期间的消息保存到磁盘缓冲，重连后先发送缓冲中的消息
+type SyslogOutput struct {
+	config   SyslogConfig
+	hostname string
+	queue    chan Message
+	done     chan struct{}
+
+	// mu 保护closed，Close之后的Send直接返回错误，不向已关闭的队列发送
+	mu     sync.Mutex
+	closed bool
+
+	conn    net.Conn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+func NewSyslogOutput(config SyslogConfig) *SyslogOutput {
+	if config.Facility == 0 {
+		config.Facility = DefaultFacility
+	}
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	s := &SyslogOutput{config: config, hostname: hostname, queue: make(chan Message, syslogQueueSize), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// Send 加入发送队列，队列满时丢弃，不阻塞读取日志
+func (s *SyslogOutput) Send(message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return strerror.CreateError("syslog output closed, message dropped")
+	}
+
+	select {
+	case s.queue <- message:
+		return nil
+	default:
+		return strerror.CreateError("syslog queue full, message dropped")
+	}
+}
+
+// Close 发送队列中剩余的消息，发送失败的消息保存到磁盘缓冲，下次启动后发送，可以重复调用
+func (s *SyslogOutput) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *SyslogOutput) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-s.queue:
+			if !ok {
+				s.closeConn()
+				if s.config.Buffer != nil {
+					s.config.Buffer.Close()
+				}
+				return
+			}
+			s.deliver(s.frame(message))
+		case <-ticker.C:
+			s.flushBuffer()
+		}
+	}
+}
+
+// frame RFC 5424 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+func (s *SyslogOutput) frame(message Message) string {
+	priority := s.config.Facility*8 + message.Severity
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", priority, message.Time.Format(syslogTimeLayout),
+		s.hostname, syslogAppName, os.Getpid(), message.MsgId, message.Text)
+}
+
+// deliver 缓冲中还有消息时先发送缓冲，保证顺序
+func (s *SyslogOutput) deliver(line string) {
+	if s.flushBuffer() {
+		err := s.write(line)
+		if err == nil {
+			return
+		}
+		s.fail(err)
+	}
+	s.bufferLine(line)
+}
+
+func (s *SyslogOutput) bufferLine(line string) {
+	if s.config.Buffer == nil {
+		return
+	}
+	if err := s.config.Buffer.Push(line); err != nil {
+		fmt.Printf("syslog buffer failed: %v\n", err)
+	}
+}
+
+// flushBuffer 发送磁盘缓冲中的消息，返回是否已连接且缓冲已发送完
+func (s *SyslogOutput) flushBuffer() bool {
+	if s.config.Buffer == nil || s.config.Buffer.Empty() {
+		return s.connect()
+	}
+	if !s.connect() {
+		return false
+	}
+	if err := s.config.Buffer.Drain(s.write); err != nil {
+		s.fail(err)
+		return false
+	}
+	return true
+}
+
+// connect 未连接时建立连接，退避期间直接返回失败
+func (s *SyslogOutput) connect() bool {
+	if s.conn != nil {
+		return true
+	}
+	if time.Now().Before(s.retryAt) {
+		return false
+	}
+
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var conn net.Conn
+	var err error
+	switch s.config.Network {
+	case NetworkTLS:
+		conn, err = tls.DialWithDialer(dialer, NetworkTCP, s.config.Addr, s.config.TLS)
+	default:
+		conn, err = dialer.Dial(s.config.Network, s.config.Addr)
+	}
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	s.conn, s.backoff = conn, 0
+	return true
+}
+
+// write 发送一条消息，udp每条消息一个报文
+func (s *SyslogOutput) write(line string) error {
+	if s.conn == nil {
+		return strerror.CreateError("syslog not connected")
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+		return err
+	}
+	data := line
+	if s.config.Network != NetworkUDP {
+		data = strconv.Itoa(len(line)) + " " + line
+	}
+	_, err := s.conn.Write([]byte(data))
+	return err
+}
+
+// fail 断开连接，退避时间从1秒开始加倍，最长1分钟
+func (s *SyslogOutput) fail(err error) {
+	s.closeConn()
+	s.backoff *= 2
+	if s.backoff < minBackoff {
+		s.backoff = minBackoff
+	}
+	if s.backoff > maxBackoff {
+		s.backoff = maxBackoff
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+	fmt.Printf("syslog %s://%s failed: %v, retry in %v\n", s.config.Network, s.config.Addr, err, s.backoff)
+}
+
+func (s *SyslogOutput) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -1 +1,220 @@
 package tail_log
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// nfnetlink_log 的消息类型和配置，x/sys/unix 中没有定义
+const (
+	nfnlSubsysUlog = 4
+	nfnetlinkV0    = 0
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind   = 1
+	nfulnlCfgCmdUnbind = 2
+	nfulnlCopyPacket   = 2
+)
+
+// NFULNL_MSG_PACKET 的属性
+const (
+	nfulaPacketHdr     = 1
+	nfulaMark          = 2
+	nfulaTimestamp     = 3
+	nfulaIfindexIndev  = 4
+	nfulaIfindexOutdev = 5
+	nfulaHwaddr        = 8
+	nfulaPayload       = 9
+	nfulaPrefix        = 10
+	nfulaHwtype        = 15
+	nfulaHwheader      = 16
+	nfulaHwlen         = 17
+)
+
+// 以太网链路层
+const (
+	arphrdEther       = 1
+	ethernetHeaderLen = 14
+)
+
+// DefaultGroup 策略日志发送到的NFLOG组，与GetLogExpr一致
+const DefaultGroup = 1
+
+// DefaultCopyRange 每个报文复制到用户态的字节数，只需要IP和传输层头部
+const DefaultCopyRange = 256
+
+// Packet NFLOG上报的一个报文
+type Packet struct {
+	Family     uint8     // 报文的协议族 AF_INET / AF_INET6
+	HwProtocol uint16    // 链路层协议 0x0800 / 0x86dd
+	Hook       uint8     // 报文所在的hook
+	Prefix     string    // 日志前缀
+	Mark       uint32    // 报文标记
+	Time       time.Time // 内核时间戳，没有时为零
+	InDev      uint32    // 入接口序号，0表示没有
+	OutDev     uint32    // 出接口序号
+	HwAddr     net.HardwareAddr
+	HwHeader   []byte // 链路层头部
+	Payload    []byte // 从网络层开始的报文
+}
+
+// Conn 绑定到一个NFLOG组的netlink连接，同一个组只能有一个进程绑定
+type Conn struct {
+	conn  *netlink.Conn
+	group uint16
+}
+
+// Dial 在指定的network namespace中绑定NFLOG组，netNS为0时使用当前namespace
+func Dial(group uint16, copyRange uint32, netNS int) (*Conn, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: netNS})
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, group: group}
+
+	if err := c.config(nfulaCfgCmd, []byte{nfulnlCfgCmdBind}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, copyRange)
+	mode[4] = nfulnlCopyPacket
+	if err := c.config(nfulaCfgMode, mode); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// nfgenmsg 协议族、版本和NFLOG组
+func (c *Conn) nfgenmsg(family uint8) []byte {
+	header := []byte{family, nfnetlinkV0, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], c.group)
+	return header
+}
+
+// config 发送NFULNL_MSG_CONFIG并等待确认
+func (c *Conn) config(attrType uint16, value []byte) error {
+	attrs, err := netlink.MarshalAttributes([]netlink.Attribute{{Type: attrType, Data: value}})
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysUlog<<8 | nfulnlMsgConfig),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: append(c.nfgenmsg(unix.AF_UNSPEC), attrs...),
+	})
+	return err
+}
+
+// SetReadDeadline 设置Receive的超时时间，用于定期检查是否需要退出
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Receive 读取一批报文，无法解析的消息直接跳过
+func (c *Conn) Receive() ([]Packet, error) {
+	msgs, err := c.conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	var packets []Packet
+	for _, msg := range msgs {
+		if msg.Header.Type != netlink.HeaderType(nfnlSubsysUlog<<8|nfulnlMsgPacket) || len(msg.Data) < 4 {
+			continue
+		}
+		packet, err := decodePacket(msg.Data)
+		if err != nil {
+			continue
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
+
+// Close 解绑NFLOG组并关闭连接
+func (c *Conn) Close() error {
+	_ = c.config(nfulaCfgCmd, []byte{nfulnlCfgCmdUnbind})
+	return c.conn.Close()
+}
+
+// decodePacket 解析NFULNL_MSG_PACKET的属性
+func decodePacket(data []byte) (Packet, error) {
+	packet := Packet{Family: data[0]}
+	ad, err := netlink.NewAttributeDecoder(data[4:])
+	if err != nil {
+		return packet, err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	var hwType, hwLen uint16
+	for ad.Next() {
+		value := ad.Bytes()
+		switch ad.Type() {
+		case nfulaPacketHdr:
+			if len(value) >= 3 {
+				packet.HwProtocol = binary.BigEndian.Uint16(value)
+				packet.Hook = value[2]
+			}
+		case nfulaMark:
+			packet.Mark = ad.Uint32()
+		case nfulaTimestamp:
+			if len(value) >= 16 {
+				sec := binary.BigEndian.Uint64(value)
+				usec := binary.BigEndian.Uint64(value[8:])
+				packet.Time = time.Unix(int64(sec), int64(usec)*int64(time.Microsecond))
+			}
+		case nfulaIfindexIndev:
+			packet.InDev = ad.Uint32()
+		case nfulaIfindexOutdev:
+			packet.OutDev = ad.Uint32()
+		case nfulaHwaddr:
+			// hw_addrlen(2) pad(2) hw_addr[8]
+			if len(value) >= 4 {
+				addrLen := int(binary.BigEndian.Uint16(value))
+				if addrLen > 0 && 4+addrLen <= len(value) {
+					packet.HwAddr = net.HardwareAddr(value[4 : 4+addrLen])
+				}
+			}
+		case nfulaPayload:
+			packet.Payload = value
+		case nfulaPrefix:
+			packet.Prefix = strings.TrimRight(string(value), "\x00")
+		case nfulaHwtype:
+			hwType = ad.Uint16()
+		case nfulaHwlen:
+			hwLen = ad.Uint16()
+		case nfulaHwheader:
+			packet.HwHeader = value
+		}
+	}
+	if hwType != arphrdEther || hwLen != ethernetHeaderLen {
+		packet.HwHeader = nil
+	}
+	return packet, ad.Err()
+}
+
+// Macs 以太网头部中的源MAC和目的MAC，没有以太网头部时源MAC使用NFULA_HWADDR
+func (p Packet) Macs() (src string, dst string) {
+	if len(p.HwHeader) >= ethernetHeaderLen {
+		return net.HardwareAddr(p.HwHeader[6:12]).String(), net.HardwareAddr(p.HwHeader[0:6]).String()
+	}
+	if len(p.HwAddr) != 0 {
+		src = p.HwAddr.String()
+	}
+	return src, ""
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Label 指标的标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample 采集时读取的指标值
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// labelEscaper 标签值转义 \ " 和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels {policy_id="policy1",version="a1b2"}
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for _, label := range labels {
+		pairs = append(pairs, label.Name+`="`+labelEscaper.Replace(label.Value)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteMetric 按Prometheus文本格式输出一个指标，metricType 为 counter / gauge
+func WriteMetric(w io.Writer, name string, metricType string, help string, samples []Sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(sample.Labels), formatValue(sample.Value))
+	}
+}
+
+// collector 进程内累计的指标
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteAll 输出进程内累计的全部指标
+func WriteAll(w io.Writer) {
+	registryMu.Lock()
+	collectors := append([]collector{}, registry...)
+	registryMu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// labelKey 标签值拼接为索引
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func labels(names []string, values []string) []Label {
+	var result []Label
+	for i, name := range names {
+		result = append(result, Label{Name: name, Value: values[i]})
+	}
+	return result
+}
+
+// CounterVec 按标签累计的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labelNames, values: make(map[string]float64), keys: make(map[string][]string)}
+	register(c)
+	return c
+}
+
+// Inc 计数加一，标签值的个数与标签名一致
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += value
+	c.keys[key] = labelValues
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	var samples []Sample
+	for key, value := range c.values {
+		samples = append(samples, Sample{Labels: labels(c.labels, c.keys[key]), Value: value})
+	}
+	c.mu.Unlock()
+
+	sortSamples(samples)
+	WriteMetric(w, c.name, "counter", c.help, samples)
+}
+
+// DefaultBuckets 下发耗时的分布，秒
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram 一组标签的分布
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec 按标签统计的分布
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labelNames, buckets: buckets, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+// ObserveSince 记录从 start 开始的耗时
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range keys {
+		v := h.values[key]
+		base := labels(h.labels, v.labelValues)
+		for i, bucket := range h.buckets {
+			bucketLabels := append(append([]Label{}, base...), Label{Name: "le", Value: formatValue(bucket)})
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels), v.counts[i])
+		}
+		infLabels := append(append([]Label{}, base...), Label{Name: "le", Value: "+Inf"})
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(infLabels), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(base), formatValue(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(base), v.count)
+	}
+}
+
+// sortSamples 按标签排序，输出稳定
+func sortSamples(samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return formatLabels(samples[i].Labels) < formatLabels(samples[j].Labels)
+	})
+}
+
+// 进程内累计的指标
+var (
+	NetlinkErrors = NewCounterVec("fd_netlink_errors_total", "netlink操作失败的次数", "service")
+	LogEvents     = NewCounterVec("fd_log_events_total", "NFLOG日志事件数", "source", "action")
+	ApplyDuration = NewHistogramVec("fd_apply_duration_seconds", "追加下发策略的耗时", DefaultBuckets, "backend", "result")
+	SyncDuration  = NewHistogramVec("fd_reconcile_duration_seconds", "同步策略链的耗时", DefaultBuckets, "backend", "result")
+	DriftEvents   = NewCounterVec("fd_drift_events_total", "策略链与策略文件不一致的规则数", "kind")
+	DriftHeals    = NewCounterVec("fd_drift_heals_total", "不一致时重新下发策略文件的次数", "result")
+)
+
+// Result 耗时指标的结果标签
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
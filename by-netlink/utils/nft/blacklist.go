@@ -0,0 +1,104 @@
+package nft
+
+import (
+	"bytes"
+	"net"
+	"sort"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+	"netvine.com/firewall/server/model"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// 黑名单的命名集合
+const (
+	BlacklistIp4Set = "ip-mac-blacklist-ip4"
+	BlacklistIp6Set = "ip-mac-blacklist-ip6"
+	BlacklistMacSet = "ip-mac-blacklist-mac"
+)
+
+// BlacklistLogTag 黑名单链的日志前缀
+const BlacklistLogTag = "ip-mac-blacklist"
+
+// GetBlacklistSets 黑名单用到的命名集合，带timeout标志，元素可以单独设置超时时间，不设置时永久有效
+func GetBlacklistSets(table *nftables.Table) []*nftables.Set {
+	return []*nftables.Set{
+		{Table: table, Name: BlacklistIp4Set, KeyType: nftables.TypeIPAddr, HasTimeout: true},
+		{Table: table, Name: BlacklistIp6Set, KeyType: nftables.TypeIP6Addr, HasTimeout: true},
+		{Table: table, Name: BlacklistMacSet, KeyType: nftables.TypeEtherAddr, HasTimeout: true},
+	}
+}
+
+// GetBlacklistElement 地址所在的集合和集合元素，返回格式化后的地址
+func GetBlacklistElement(value string) (string, nftables.SetElement, string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return BlacklistIp4Set, nftables.SetElement{Key: ip4}, ip4.String(), nil
+		}
+		return BlacklistIp6Set, nftables.SetElement{Key: ip}, ip.String(), nil
+	}
+
+	mac, err := net.ParseMAC(value)
+	if err != nil || len(mac) != 6 {
+		return "", nftables.SetElement{}, "", strerror.CreateError("blacklist address error:" + value)
+	}
+	return BlacklistMacSet, nftables.SetElement{Key: mac}, mac.String(), nil
+}
+
+// GetBlacklistEntries 集合元素还原为黑名单地址，按地址排序，timeouts为元素的超时时间和剩余时间
+func GetBlacklistEntries(set *nftables.Set, elements []nftables.SetElement, timeouts map[string]SetElementTimeout) []model.BlacklistEntry {
+	sort.Slice(elements, func(i, j int) bool { return bytes.Compare(elements[i].Key, elements[j].Key) < 0 })
+
+	var entries []model.BlacklistEntry
+	for _, element := range elements {
+		value := net.IP(element.Key).String()
+		if set.Name == BlacklistMacSet {
+			value = net.HardwareAddr(element.Key).String()
+		}
+		timeout := timeouts[string(element.Key)]
+		entries = append(entries, model.BlacklistEntry{Value: value, Type: set.KeyType.Name,
+			Timeout: int64(timeout.Timeout.Seconds()), Expires: int64(timeout.Expiration.Seconds())})
+	}
+	return entries
+}
+
+// GetBlacklistRuleExprs 黑名单链的规则，源地址、目的地址、源MAC在黑名单中时记录日志并丢弃
+// meta nfproto ipv4 ip saddr @ip-mac-blacklist-ip4 log prefix "ip-mac-blacklist" group 1 drop
+func GetBlacklistRuleExprs(sets map[string]*nftables.Set) [][]expr.Any {
+	logExpr, _ := GetLogExpr(BlacklistLogTag)
+	verdict := append(logExpr, &expr.Verdict{Kind: expr.VerdictDrop})
+
+	var rules [][]expr.Any
+	for _, family := range []struct {
+		set     string
+		nfproto byte
+		saddr   uint32
+		daddr   uint32
+		length  uint32
+	}{
+		{BlacklistIp4Set, unix.NFPROTO_IPV4, 12, 16, net.IPv4len},
+		{BlacklistIp6Set, unix.NFPROTO_IPV6, 8, 24, net.IPv6len},
+	} {
+		set := sets[family.set]
+		for _, offset := range []uint32{family.saddr, family.daddr} {
+			rules = append(rules, append([]expr.Any{
+				&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{family.nfproto}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: family.length},
+				&expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID},
+			}, verdict...))
+		}
+	}
+
+	// ether saddr，只检查以太网接口收到的报文
+	set := sets[BlacklistMacSet]
+	rules = append(rules, append([]expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFTYPE, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x01, 0x00}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseLLHeader, Offset: 6, Len: 6},
+		&expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID},
+	}, verdict...))
+	return rules
+}
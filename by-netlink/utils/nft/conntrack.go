@@ -0,0 +1,72 @@
+package nft
+
+import (
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+)
+
+// ctDirectionValue 连接方向在寄存器中的值 original 0 reply 1
+func ctDirectionValue(direction string) byte {
+	if direction == nftcmd.CtDirectionReply {
+		return 1
+	}
+	return 0
+}
+
+// getCtStateExpr 连接状态按位匹配，状态和标记是主机字节序
+// [ ct load state => reg 1 ] [ bitwise reg 1 = (reg=1 & 0x00000006 ) ^ 0x00000000 ] [ cmp neq reg 1 0x00000000 ]
+func getCtStateExpr(state uint32) []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(state),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+	}
+}
+
+// GetCtExpr 获取连接状态、方向和标记表达式
+// ct state established,related ct direction reply ct mark 0x00000010
+func GetCtExpr(policy model.Policy) ([]expr.Any, error) {
+	match, err := nftcmd.GetCtMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var exprs []expr.Any
+	if match.State != 0 {
+		exprs = append(exprs, getCtStateExpr(match.State)...)
+	}
+	if len(match.Direction) != 0 {
+		exprs = append(exprs,
+			&expr.Ct{Register: 1, Key: expr.CtKeyDIRECTION},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{ctDirectionValue(match.Direction)}},
+		)
+	}
+	if match.HasMark {
+		exprs = append(exprs,
+			&expr.Ct{Register: 1, Key: expr.CtKeyMARK},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(match.Mark)},
+		)
+	}
+	return exprs, nil
+}
+
+// GetConntrackRuleExprs 连接跟踪规则的表达式 ct state established,related accept
+func GetConntrackRuleExprs(comment string) ([]expr.Any, error) {
+	state, action := nftcmd.ConntrackRuleState(comment)
+	if state == 0 {
+		return nil, nil
+	}
+	actionExpr, err := GetActionExpr(string(action))
+	if err != nil {
+		return nil, err
+	}
+	return append(getCtStateExpr(state), actionExpr...), nil
+}
@@ -0,0 +1,146 @@
+package nft
+
+import (
+	"bytes"
+	"net"
+	"sort"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// IP-MAC绑定的命名集合，ip4/ip6 已绑定的地址，pair4/pair6 地址.MAC
+const (
+	BindingIp4Set   = "ip-mac-binding-ip4"
+	BindingIp6Set   = "ip-mac-binding-ip6"
+	BindingPair4Set = "ip-mac-binding-pair4"
+	BindingPair6Set = "ip-mac-binding-pair6"
+)
+
+// BindingLogTag 绑定链的日志前缀，告警时带有告警标识
+const BindingLogTag = "ip-mac-binding"
+
+var (
+	bindingPair4Type = nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeEtherAddr)
+	bindingPair6Type = nftables.MustConcatSetType(nftables.TypeIP6Addr, nftables.TypeEtherAddr)
+)
+
+// GetBindingSets 绑定用到的命名集合，地址.MAC使用拼接类型的集合，按哈希查找
+func GetBindingSets(table *nftables.Table) []*nftables.Set {
+	return []*nftables.Set{
+		{Table: table, Name: BindingIp4Set, KeyType: nftables.TypeIPAddr},
+		{Table: table, Name: BindingIp6Set, KeyType: nftables.TypeIP6Addr},
+		{Table: table, Name: BindingPair4Set, KeyType: bindingPair4Type, Concatenation: true},
+		{Table: table, Name: BindingPair6Set, KeyType: bindingPair6Type, Concatenation: true},
+	}
+}
+
+// ParseBinding 校验绑定，返回地址和MAC，IPv4地址为4字节
+func ParseBinding(binding model.IpMacBinding) (net.IP, net.HardwareAddr, error) {
+	ip := net.ParseIP(binding.Ip)
+	if ip == nil {
+		return nil, nil, strerror.CreateError("binding ip error:" + binding.Ip)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	mac, err := net.ParseMAC(binding.Mac)
+	if err != nil || len(mac) != 6 {
+		return nil, nil, strerror.CreateError("binding mac error:" + binding.Mac)
+	}
+	return ip, mac, nil
+}
+
+// bindingKey 地址.MAC，拼接的每个字段按4字节对齐
+func bindingKey(ip net.IP, mac net.HardwareAddr) []byte {
+	key := append(append([]byte{}, ip...), mac...)
+	return append(key, 0, 0)
+}
+
+// GetBindingElements 绑定转换为各个集合的元素，key为集合名称
+func GetBindingElements(bindings []model.IpMacBinding) (map[string][]nftables.SetElement, error) {
+	elements := make(map[string][]nftables.SetElement)
+	for _, binding := range bindings {
+		ip, mac, err := ParseBinding(binding)
+		if err != nil {
+			return nil, err
+		}
+
+		ipSet, pairSet := BindingIp4Set, BindingPair4Set
+		if len(ip) == net.IPv6len {
+			ipSet, pairSet = BindingIp6Set, BindingPair6Set
+		}
+		elements[ipSet] = append(elements[ipSet], nftables.SetElement{Key: ip})
+		elements[pairSet] = append(elements[pairSet], nftables.SetElement{Key: bindingKey(ip, mac)})
+	}
+	return elements, nil
+}
+
+// GetBindings 由地址.MAC集合的元素还原绑定，按地址排序
+func GetBindings(ipLen int, elements []nftables.SetElement) []model.IpMacBinding {
+	var keys [][]byte
+	for _, element := range elements {
+		if len(element.Key) >= ipLen+6 {
+			keys = append(keys, element.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	var bindings []model.IpMacBinding
+	for _, key := range keys {
+		bindings = append(bindings, model.IpMacBinding{
+			Ip:  net.IP(key[:ipLen]).String(),
+			Mac: net.HardwareAddr(key[ipLen : ipLen+6]).String(),
+		})
+	}
+	return bindings
+}
+
+// GetBindingRuleExprs 源地址已绑定且源MAC不一致时告警或者阻断
+// meta nfproto ipv4 meta iiftype ether ip saddr @ip4 ip saddr . ether saddr != @pair4 log prefix "ip-mac-binding" group 1 drop
+func GetBindingRuleExprs(ipSet *nftables.Set, pairSet *nftables.Set, ipv6 bool, action int) []expr.Any {
+	// ip saddr: 4b @ network header + 12，ip6 saddr: 16b @ network header + 8
+	// 拼接时 ether saddr 紧跟在地址之后的32位寄存器中
+	nfproto := byte(unix.NFPROTO_IPV4)
+	offset, length, macRegister := uint32(12), uint32(net.IPv4len), uint32(unix.NFT_REG32_01)
+	if ipv6 {
+		nfproto = unix.NFPROTO_IPV6
+		offset, length, macRegister = 8, net.IPv6len, unix.NFT_REG_2
+	}
+
+	exprs := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{nfproto}},
+		// 只检查以太网接口收到的报文
+		&expr.Meta{Key: expr.MetaKeyIIFTYPE, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x01, 0x00}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: length},
+		&expr.Lookup{SourceRegister: 1, SetName: ipSet.Name, SetID: ipSet.ID},
+		&expr.Payload{DestRegister: macRegister, Base: expr.PayloadBaseLLHeader, Offset: 6, Len: 6},
+		&expr.Lookup{SourceRegister: 1, SetName: pairSet.Name, SetID: pairSet.ID, Invert: true},
+	}
+
+	logExpr, _ := GetLogExpr(nftcmd.LogPrefix(model.Policy{LogTag: BindingLogTag, Action: action}))
+	exprs = append(exprs, logExpr...)
+	if action == nftcmd.DROP {
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictDrop})
+	}
+	return exprs
+}
+
+// GetBindingAction 由绑定链的规则还原动作，没有阻断的规则时为告警
+func GetBindingAction(rules []*nftables.Rule) int {
+	for _, rule := range rules {
+		for _, e := range rule.Exprs {
+			if verdict, ok := e.(*expr.Verdict); ok && verdict.Kind == expr.VerdictDrop {
+				return nftcmd.DROP
+			}
+		}
+	}
+	return nftcmd.WARN
+}
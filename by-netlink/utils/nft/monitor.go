@@ -0,0 +1,238 @@
+package nft
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// monitorReadBuffer 接收缓冲区，flush ruleset 时一次会收到所有规则的删除通知
+const monitorReadBuffer = 4 << 20
+
+// ChangeType 规则集变化的类型，与nft monitor的输出一致
+type ChangeType string
+
+const (
+	ChangeAddTable      ChangeType = "add table"
+	ChangeDeleteTable   ChangeType = "delete table"
+	ChangeAddChain      ChangeType = "add chain"
+	ChangeDeleteChain   ChangeType = "delete chain"
+	ChangeAddRule       ChangeType = "add rule"
+	ChangeDeleteRule    ChangeType = "delete rule"
+	ChangeAddSet        ChangeType = "add set"
+	ChangeDeleteSet     ChangeType = "delete set"
+	ChangeAddElement    ChangeType = "add element"
+	ChangeDeleteElement ChangeType = "delete element"
+	ChangeAddObject     ChangeType = "add object"
+	ChangeDeleteObject  ChangeType = "delete object"
+)
+
+var changeTypes = map[uint16]ChangeType{
+	unix.NFT_MSG_NEWTABLE:   ChangeAddTable,
+	unix.NFT_MSG_DELTABLE:   ChangeDeleteTable,
+	unix.NFT_MSG_NEWCHAIN:   ChangeAddChain,
+	unix.NFT_MSG_DELCHAIN:   ChangeDeleteChain,
+	unix.NFT_MSG_NEWRULE:    ChangeAddRule,
+	unix.NFT_MSG_DELRULE:    ChangeDeleteRule,
+	unix.NFT_MSG_NEWSET:     ChangeAddSet,
+	unix.NFT_MSG_DELSET:     ChangeDeleteSet,
+	unix.NFT_MSG_NEWSETELEM: ChangeAddElement,
+	unix.NFT_MSG_DELSETELEM: ChangeDeleteElement,
+	unix.NFT_MSG_NEWOBJ:     ChangeAddObject,
+	unix.NFT_MSG_DELOBJ:     ChangeDeleteObject,
+}
+
+// Change 一条规则集变化通知
+type Change struct {
+	Type    ChangeType
+	Family  nftables.TableFamily
+	Table   string
+	Chain   string // 链、规则所在的链
+	Name    string // 集合、命名对象的名称
+	Handle  uint64 // 规则句柄
+	Comment string // 规则注释
+}
+
+// String add rule inet fd-table fd-chain handle 5 comment "fd:policy1:a1b2"
+func (c Change) String() string {
+	parts := []string{string(c.Type), FamilyName(c.Family), c.Table}
+	for _, value := range []string{c.Chain, c.Name} {
+		if len(value) != 0 {
+			parts = append(parts, value)
+		}
+	}
+	if c.Handle != 0 {
+		parts = append(parts, "handle", strconv.FormatUint(c.Handle, 10))
+	}
+	if len(c.Comment) != 0 {
+		parts = append(parts, "comment", `"`+c.Comment+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Generation 内核一次提交的全部变化，提交结束时内核发送 NFT_MSG_NEWGEN
+type Generation struct {
+	Id       uint32
+	Pid      uint32 // 提交变化的线程ID，内核使用 task_pid_nr
+	ProcName string // 提交变化的进程名
+	Changes  []Change
+}
+
+// Monitor 订阅nftables规则集变化的netlink连接
+// google/nftables 的连接不能加入多播组，在同一个network namespace中单独建立连接
+type Monitor struct {
+	conn    *netlink.Conn
+	pending []Change
+}
+
+// DialMonitor 在指定的network namespace中订阅规则集变化，netNS为0时使用当前namespace
+func DialMonitor(netNS int) (*Monitor, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: netNS})
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.JoinGroup(unix.NFNLGRP_NFTABLES); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetReadBuffer(monitorReadBuffer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Monitor{conn: conn}, nil
+}
+
+// SetReadDeadline 设置Receive的超时时间，用于定期检查是否需要退出
+func (m *Monitor) SetReadDeadline(t time.Time) error {
+	return m.conn.SetReadDeadline(t)
+}
+
+// Receive 读取通知，返回已经结束的提交，没有结束的变化留到下次返回
+func (m *Monitor) Receive() ([]Generation, error) {
+	msgs, err := m.conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []Generation
+	for _, msg := range msgs {
+		if uint16(msg.Header.Type)>>8 != unix.NFNL_SUBSYS_NFTABLES || len(msg.Data) < 4 {
+			continue
+		}
+		msgType := uint16(msg.Header.Type) & 0xff
+		if msgType == unix.NFT_MSG_NEWGEN {
+			generation, err := decodeGeneration(msg.Data)
+			if err != nil {
+				continue
+			}
+			generation.Changes, m.pending = m.pending, nil
+			generations = append(generations, generation)
+			continue
+		}
+
+		changeType, ok := changeTypes[msgType]
+		if !ok {
+			continue
+		}
+		change, err := decodeChange(changeType, msgType, msg.Data)
+		if err != nil {
+			continue
+		}
+		m.pending = append(m.pending, change)
+	}
+	return generations, nil
+}
+
+// Reset 丢弃没有结束的变化，接收缓冲区溢出后调用
+func (m *Monitor) Reset() {
+	m.pending = nil
+}
+
+func (m *Monitor) Close() error {
+	return m.conn.Close()
+}
+
+// decodeGeneration 解析 NFT_MSG_NEWGEN 的提交序号和进程
+func decodeGeneration(data []byte) (Generation, error) {
+	var generation Generation
+	ad, err := netlink.NewAttributeDecoder(data[4:])
+	if err != nil {
+		return generation, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_GEN_ID:
+			generation.Id = ad.Uint32()
+		case unix.NFTA_GEN_PROC_PID:
+			generation.Pid = ad.Uint32()
+		case unix.NFTA_GEN_PROC_NAME:
+			generation.ProcName = strings.TrimRight(ad.String(), "\x00")
+		}
+	}
+	return generation, ad.Err()
+}
+
+// decodeChange 按消息类型解析表名、链名、对象名、句柄和注释，其他属性不需要
+func decodeChange(changeType ChangeType, msgType uint16, data []byte) (Change, error) {
+	change := Change{Type: changeType, Family: nftables.TableFamily(data[0])}
+	ad, err := netlink.NewAttributeDecoder(data[4:])
+	if err != nil {
+		return change, err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	for ad.Next() {
+		switch msgType {
+		case unix.NFT_MSG_NEWTABLE, unix.NFT_MSG_DELTABLE:
+			if ad.Type() == unix.NFTA_TABLE_NAME {
+				change.Table = ad.String()
+			}
+		case unix.NFT_MSG_NEWCHAIN, unix.NFT_MSG_DELCHAIN:
+			switch ad.Type() {
+			case unix.NFTA_CHAIN_TABLE:
+				change.Table = ad.String()
+			case unix.NFTA_CHAIN_NAME:
+				change.Chain = ad.String()
+			}
+		case unix.NFT_MSG_NEWRULE, unix.NFT_MSG_DELRULE:
+			switch ad.Type() {
+			case unix.NFTA_RULE_TABLE:
+				change.Table = ad.String()
+			case unix.NFTA_RULE_CHAIN:
+				change.Chain = ad.String()
+			case unix.NFTA_RULE_HANDLE:
+				change.Handle = ad.Uint64()
+			case unix.NFTA_RULE_USERDATA:
+				change.Comment = GetRuleComment(ad.Bytes())
+			}
+		case unix.NFT_MSG_NEWSET, unix.NFT_MSG_DELSET:
+			switch ad.Type() {
+			case unix.NFTA_SET_TABLE:
+				change.Table = ad.String()
+			case unix.NFTA_SET_NAME:
+				change.Name = ad.String()
+			}
+		case unix.NFT_MSG_NEWSETELEM, unix.NFT_MSG_DELSETELEM:
+			switch ad.Type() {
+			case unix.NFTA_SET_ELEM_LIST_TABLE:
+				change.Table = ad.String()
+			case unix.NFTA_SET_ELEM_LIST_SET:
+				change.Name = ad.String()
+			}
+		case unix.NFT_MSG_NEWOBJ, unix.NFT_MSG_DELOBJ:
+			switch ad.Type() {
+			case unix.NFTA_OBJ_TABLE:
+				change.Table = ad.String()
+			case unix.NFTA_OBJ_NAME:
+				change.Name = ad.String()
+			}
+		}
+	}
+	return change, ad.Err()
+}
@@ -0,0 +1,275 @@
+package nft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	nftcmd "netvine.com/firewall/server/nft"
+	iptools "netvine.com/firewall/server/utils"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+var familyNames = map[nftables.TableFamily]string{
+	nftables.TableFamilyINet:   "inet",
+	nftables.TableFamilyIPv4:   "ip",
+	nftables.TableFamilyIPv6:   "ip6",
+	nftables.TableFamilyARP:    "arp",
+	nftables.TableFamilyBridge: "bridge",
+	nftables.TableFamilyNetdev: "netdev",
+}
+
+// ParseFamily 地址族名称转换为TableFamily
+func ParseFamily(name string) (nftables.TableFamily, error) {
+	for family, familyName := range familyNames {
+		if familyName == name {
+			return family, nil
+		}
+	}
+	return nftables.TableFamilyUnspecified, strerror.CreateError("family error:" + name)
+}
+
+func FamilyName(family nftables.TableFamily) string {
+	return familyNames[family]
+}
+
+var hookNames = map[string]*nftables.ChainHook{
+	"prerouting":  nftables.ChainHookPrerouting,
+	"input":       nftables.ChainHookInput,
+	"forward":     nftables.ChainHookForward,
+	"output":      nftables.ChainHookOutput,
+	"postrouting": nftables.ChainHookPostrouting,
+	"ingress":     nftables.ChainHookIngress,
+}
+
+// ParseHook 挂载点名称转换为ChainHook，为空时是普通链
+func ParseHook(name string) (*nftables.ChainHook, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	hook, ok := hookNames[name]
+	if !ok {
+		return nil, strerror.CreateError("hook error:" + name)
+	}
+	return hook, nil
+}
+
+// HookName netdev的ingress和prerouting取值相同，需要根据地址族区分
+func HookName(family nftables.TableFamily, hook *nftables.ChainHook) string {
+	if hook == nil {
+		return ""
+	}
+	if family == nftables.TableFamilyNetdev && *hook == *nftables.ChainHookIngress {
+		return "ingress"
+	}
+	for name, h := range hookNames {
+		if *h == *hook && name != "ingress" {
+			return name
+		}
+	}
+	return strconv.Itoa(int(*hook))
+}
+
+// ParseChainPolicy 链默认动作，为空时默认放行
+func ParseChainPolicy(name string) (*nftables.ChainPolicy, error) {
+	var policy nftables.ChainPolicy
+	switch name {
+	case "", "accept":
+		policy = nftables.ChainPolicyAccept
+	case "drop":
+		policy = nftables.ChainPolicyDrop
+	default:
+		return nil, strerror.CreateError("chain policy error:" + name)
+	}
+	return &policy, nil
+}
+
+func ChainPolicyName(policy *nftables.ChainPolicy) string {
+	if policy == nil {
+		return ""
+	}
+	if *policy == nftables.ChainPolicyDrop {
+		return "drop"
+	}
+	return "accept"
+}
+
+// 支持的集合元素类型
+var setKeyTypes = map[string]nftables.SetDatatype{
+	nftables.TypeIPAddr.Name:      nftables.TypeIPAddr,
+	nftables.TypeIP6Addr.Name:     nftables.TypeIP6Addr,
+	nftables.TypeEtherAddr.Name:   nftables.TypeEtherAddr,
+	nftables.TypeInetService.Name: nftables.TypeInetService,
+	nftables.TypeIFName.Name:      nftables.TypeIFName,
+}
+
+// ParseSetKeyType 集合元素类型
+func ParseSetKeyType(name string) (nftables.SetDatatype, error) {
+	keyType, ok := setKeyTypes[name]
+	if !ok {
+		return nftables.SetDatatype{}, strerror.CreateError("set key type error:" + name)
+	}
+	return keyType, nil
+}
+
+// GetSetElements 集合元素，地址支持范围、网段，端口支持范围
+// 包含范围时返回区间集合的元素
+func GetSetElements(keyType nftables.SetDatatype, values []string) ([]nftables.SetElement, bool, error) {
+	switch keyType.Name {
+	case nftables.TypeIPAddr.Name, nftables.TypeIP6Addr.Name:
+		for _, value := range values {
+			isIPv6, err := iptools.IsIPv6(value)
+			if err != nil {
+				return nil, false, err
+			}
+			if isIPv6 != (keyType.Name == nftables.TypeIP6Addr.Name) {
+				return nil, false, strerror.CreateError("ip family error:" + value)
+			}
+		}
+		return GetIpSetElements(values)
+
+	case nftables.TypeEtherAddr.Name:
+		var elements []nftables.SetElement
+		for _, value := range values {
+			mac, err := net.ParseMAC(value)
+			if err != nil || len(mac) != 6 {
+				return nil, false, strerror.CreateError("mac error:" + value)
+			}
+			elements = append(elements, nftables.SetElement{Key: mac})
+		}
+		return elements, false, nil
+
+	case nftables.TypeInetService.Name:
+		return getPortSetElements(values)
+
+	case nftables.TypeIFName.Name:
+		var elements []nftables.SetElement
+		for _, value := range values {
+			if len(value) == 0 || len(value) >= 16 {
+				return nil, false, strerror.CreateError("ifname error:" + value)
+			}
+			elements = append(elements, nftables.SetElement{Key: ifname(value)})
+		}
+		return elements, false, nil
+	}
+	return nil, false, strerror.CreateError("set key type error:" + keyType.Name)
+}
+
+// getPortSetElements 端口 22 / 1000-2000，合并后有端口范围时使用区间集合
+func getPortSetElements(values []string) ([]nftables.SetElement, bool, error) {
+	parsed, err := nftcmd.GetPortRanges(values)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var ranges []iptools.IpRange
+	interval := false
+	for _, r := range parsed {
+		if r.Start != r.End {
+			interval = true
+		}
+		ranges = append(ranges, iptools.IpRange{Start: portBytes(uint64(r.Start)), End: portBytes(uint64(r.End))})
+	}
+
+	var elements []nftables.SetElement
+	if !interval {
+		for _, r := range ranges {
+			elements = append(elements, nftables.SetElement{Key: r.Start})
+		}
+		return elements, false, nil
+	}
+
+	// 与地址段一致，从0开始
+	if !isZero(ranges[0].Start) {
+		elements = append(elements, nftables.SetElement{Key: make([]byte, 2), IntervalEnd: true})
+	}
+	for _, r := range ranges {
+		elements = append(elements, nftables.SetElement{Key: r.Start})
+		if end, ok := iptools.NextIp(r.End); ok {
+			elements = append(elements, nftables.SetElement{Key: end, IntervalEnd: true})
+		}
+	}
+	return elements, true, nil
+}
+
+func portBytes(port uint64) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(port))
+	return b
+}
+
+// setKeyString 元素转换为字符串
+func setKeyString(keyType nftables.SetDatatype, key []byte) string {
+	switch keyType.Name {
+	case nftables.TypeIPAddr.Name, nftables.TypeIP6Addr.Name:
+		return net.IP(key).String()
+	case nftables.TypeEtherAddr.Name:
+		return net.HardwareAddr(key).String()
+	case nftables.TypeInetService.Name:
+		if len(key) == 2 {
+			return strconv.Itoa(int(binary.BigEndian.Uint16(key)))
+		}
+	case nftables.TypeIFName.Name:
+		return strings.TrimRight(string(key), "\x00")
+	}
+	return "0x" + hex.EncodeToString(key)
+}
+
+// prevKey 元素减一，区间结束元素是结束值+1
+func prevKey(key []byte) []byte {
+	prev := make([]byte, len(key))
+	copy(prev, key)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+// GetElementRanges 集合元素转换为闭区间，非区间集合每个元素是一个区间
+func GetElementRanges(interval bool, elements []nftables.SetElement) []iptools.IpRange {
+	var ranges []iptools.IpRange
+	if !interval {
+		for _, element := range elements {
+			ranges = append(ranges, iptools.IpRange{Start: element.Key, End: element.Key})
+		}
+		return ranges
+	}
+
+	// 内核返回的区间元素没有顺序，按元素值排序后起始、结束成对出现
+	sorted := append([]nftables.SetElement{}, elements...)
+	sort.SliceStable(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0 })
+	for i, element := range sorted {
+		if element.IntervalEnd {
+			continue
+		}
+
+		end := bytes.Repeat([]byte{0xff}, len(element.Key))
+		if i+1 < len(sorted) && sorted[i+1].IntervalEnd {
+			end = prevKey(sorted[i+1].Key)
+		}
+		ranges = append(ranges, iptools.IpRange{Start: element.Key, End: end})
+	}
+	return ranges
+}
+
+// GetSetElementStrings 集合元素转换为字符串，区间集合合并为 start-end
+func GetSetElementStrings(keyType nftables.SetDatatype, interval bool, elements []nftables.SetElement) []string {
+	var values []string
+	for _, r := range GetElementRanges(interval, elements) {
+		start, end := setKeyString(keyType, r.Start), setKeyString(keyType, r.End)
+		if start == end {
+			values = append(values, start)
+		} else {
+			values = append(values, start+"-"+end)
+		}
+	}
+	return values
+}
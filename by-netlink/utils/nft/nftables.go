@@ -1,14 +1,18 @@
 package nft
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
+	"net"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
 	iptools "netvine.com/firewall/server/utils"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,7 +42,7 @@ func OpenSystemNFTConn() (*nftables.Conn, netns.NsHandle) {
 	ns, err := netns.GetFromPid(init_pid)
 
 	if err != nil {
-		fmt.Sprintln("GetFromPid err", err.Error())
+		fmt.Println("GetFromPid err", err.Error())
 	}
 
 	// nftables.AsLasting() 持久连接，可以重用
@@ -59,12 +63,12 @@ func CleanupSystemNFTConn(newNS netns.NsHandle) {
 	}
 }
 
-func (nft *NfTables) CreateTableIfNotExist(tableName string) (*nftables.Table, error) {
+func (nft *NfTables) CreateTableIfNotExist(family nftables.TableFamily, tableName string) (*nftables.Table, error) {
 	var table *nftables.Table
 	if nft.Conn != nil {
-		tables, err := nft.Conn.ListTablesOfFamily(nftables.TableFamilyIPv4)
+		tables, err := nft.Conn.ListTablesOfFamily(family)
 		if err != nil {
-			fmt.Printf("failed to list IPv4 tables: %v\n", err)
+			fmt.Printf("failed to list tables: %v\n", err)
 			return nil, err
 		}
 
@@ -79,7 +83,7 @@ func (nft *NfTables) CreateTableIfNotExist(tableName string) (*nftables.Table, e
 
 		if !tableExist {
 			table = nft.Conn.AddTable(&nftables.Table{
-				Family: nftables.TableFamilyIPv4,
+				Family: family,
 				Name:   tableName,
 			})
 		}
@@ -88,42 +92,55 @@ func (nft *NfTables) CreateTableIfNotExist(tableName string) (*nftables.Table, e
 	return table, nil
 }
 
-func (nft *NfTables) CreateChainIfNotExist(table *nftables.Table, chainName string) (*nftables.Chain, error) {
-	var chain *nftables.Chain
-
-	if table == nil {
-		return nil, strerror.CreateError("CreateChainIfNotExist nftables 表不能为空")
+// CreateBaseChainIfNotExist 查找链，不存在时按指定的挂载点、优先级和默认动作创建，返回是否新建
+// 已存在的链不修改默认动作
+func (nft *NfTables) CreateBaseChainIfNotExist(table *nftables.Table, chainName string, hook *nftables.ChainHook, priority *nftables.ChainPriority, policy nftables.ChainPolicy) (*nftables.Chain, bool, error) {
+	chains, err := nft.Conn.ListChainsOfTableFamily(table.Family)
+	if err != nil {
+		return nil, false, err
 	}
-
-	if nft.Conn != nil {
-		chains, err := nft.Conn.ListChainsOfTableFamily(nftables.TableFamilyIPv4)
-		if err != nil {
-			fmt.Printf("failed to list IPv4 chains: %v\n", err)
-			return nil, err
+	for _, c := range chains {
+		if c.Name == chainName && c.Table.Name == table.Name {
+			return c, false, nil
 		}
+	}
 
-		chainExist := false
-		for _, c := range chains {
-			if c.Name == chainName && c.Table.Name == table.Name {
-				chainExist = true
-				chain = c
-				break
-			}
-		}
+	chain := nft.Conn.AddChain(&nftables.Chain{
+		Name:     chainName,
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  hook,
+		Priority: priority,
+		Policy:   &policy,
+	})
+	return chain, true, nil
+}
 
-		policyDrop := nftables.ChainPolicyDrop
-		if !chainExist {
-			chain = nft.Conn.AddChain(&nftables.Chain{
-				Name:     chainName,
-				Table:    table,
-				Type:     nftables.ChainTypeFilter,
-				Hooknum:  nftables.ChainHookForward,
-				Priority: nftables.ChainPriorityFilter,
-				Policy:   &policyDrop,
-			})
+// CreateSetsIfNotExist 查找命名集合，不存在时创建，只返回指定的集合，key为集合名称
+// 表需要已经提交，否则无法读取表中的集合
+func (nft *NfTables) CreateSetsIfNotExist(table *nftables.Table, sets []*nftables.Set) (map[string]*nftables.Set, error) {
+	existSets, err := nft.Conn.GetSets(table)
+	if err != nil {
+		return nil, err
+	}
+	exist := make(map[string]*nftables.Set)
+	for _, set := range existSets {
+		set.Table = table
+		exist[set.Name] = set
+	}
+
+	result := make(map[string]*nftables.Set)
+	for _, set := range sets {
+		if existSet, ok := exist[set.Name]; ok {
+			result[set.Name] = existSet
+			continue
 		}
+		if err := nft.Conn.AddSet(set, nil); err != nil {
+			return nil, err
+		}
+		result[set.Name] = set
 	}
-	return chain, nil
+	return result, nil
 }
 
 // 字符串类型，只要增加一个结束符"\x00"即可
@@ -145,26 +162,8 @@ func macaddr(addr string) []byte {
 	return macByte
 }
 
-// 时间转换
-func metaTime(timeStr string) []byte {
-	// 1970-01-01
-	timeS := "1970-01-01 " + timeStr
-	loc, _ := time.LoadLocation("Asia/Shanghai")
-	metaTime, err := time.ParseInLocation("2006-01-02 15:04:05", timeS, loc)
-	if err != nil {
-		return []byte{}
-	}
-
-	timestamp := metaTime.Unix()
-	bytesBuffer := bytes.NewBuffer([]byte{})
-	_ = binary.Write(bytesBuffer, binary.LittleEndian, timestamp)
-
-	byte := bytesBuffer.Bytes()
-	return byte[:4]
-}
-
 // AddInterfaceExpr 生成网卡规则表达式
-func AddInterfaceExpr(table *nftables.Table, conn *nftables.Conn, setName string, key expr.MetaKey, values []string) ([]expr.Any, error) {
+func AddInterfaceExpr(table *nftables.Table, conn *nftables.Conn, key expr.MetaKey, values []string) ([]expr.Any, error) {
 	arrLength := len(values)
 	var exprLocal []expr.Any
 
@@ -180,10 +179,12 @@ func AddInterfaceExpr(table *nftables.Table, conn *nftables.Conn, setName string
 				Data:     ifname(values[0]),
 			})
 		} else {
+			// 匿名集合随规则一起创建和删除，多条规则之间不会重名
 			ifSet := &nftables.Set{
-				Table:   table,
-				Name:    setName,
-				KeyType: nftables.TypeIFName,
+				Table:     table,
+				Anonymous: true,
+				Constant:  true,
+				KeyType:   nftables.TypeIFName,
 			}
 
 			var setEle []nftables.SetElement
@@ -206,48 +207,134 @@ func AddInterfaceExpr(table *nftables.Table, conn *nftables.Conn, setName string
 	return exprLocal, nil
 }
 
-// AddProtocolExpr 生成协议规则表达式 TCP/UDP/ICMP
-func AddProtocolExpr(procotolStr string) ([]expr.Any, error) {
-	if len(procotolStr) > 0 {
-		procotolStr = strings.ToLower(procotolStr)
-		protocol := unix.IPPROTO_TCP
+// AddProtocolExpr 生成协议规则表达式，多个协议使用匿名集合
+// ICMP类型、代码和TCP标志跟在协议后面，协议保证传输层头部的类型
+// [ payload load 1b @ transport header + 13 => reg 1 ]
+// [ bitwise reg 1 = ( reg 1 & 0x00000012 ) ^ 0x00000000 ]
+// [ cmp eq reg 1 0x00000002 ]
+func AddProtocolExpr(table *nftables.Table, conn *nftables.Conn, match nftcmd.ProtocolMatch) ([]expr.Any, error) {
+	var exprLocal []expr.Any
+	if len(match.Protocols) == 0 {
+		return nil, nil
+	}
 
-		if procotolStr == "udp" {
-			protocol = unix.IPPROTO_UDP
-		} else if procotolStr == "icmp" {
-			protocol = unix.IPPROTO_ICMP
+	// [ meta load l4proto => reg 1 ]
+	exprLocal = append(exprLocal, &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1})
+	if len(match.Protocols) == 1 {
+		// [ cmp eq reg 1 0x00000006 ]
+		exprLocal = append(exprLocal, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{match.Protocols[0]},
+		})
+	} else {
+		protocolSet := &nftables.Set{
+			Table:     table,
+			Anonymous: true,
+			Constant:  true,
+			KeyType:   nftables.TypeInetProto,
 		}
 
-		exprLocal := []expr.Any{
-			// [ meta load l4proto => reg 1 ]
-			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
-			// [ cmp eq reg 1 0x00000006 ]
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     []byte{byte(protocol)},
-			},
+		var setEle []nftables.SetElement
+		for _, protocol := range match.Protocols {
+			setEle = append(setEle, nftables.SetElement{Key: []byte{protocol}})
 		}
-		return exprLocal, nil
+		if err := conn.AddSet(protocolSet, setEle); err != nil {
+			return nil, strerror.CreateError("AddProtocolExpr set error")
+		}
+
+		exprLocal = append(exprLocal, &expr.Lookup{
+			SourceRegister: 1,
+			SetName:        protocolSet.Name,
+			SetID:          protocolSet.ID,
+		})
+	}
+
+	// icmp type: 1b @ transport header + 0, icmp code: 1b @ transport header + 1
+	for offset, value := range []int{match.IcmpType, match.IcmpCode} {
+		if value < 0 {
+			continue
+		}
+		exprLocal = append(exprLocal,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: uint32(offset), Len: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{byte(value)}},
+		)
+	}
+
+	// tcp flags: 1b @ transport header + 13
+	if match.TcpMask != 0 {
+		exprLocal = append(exprLocal,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 13, Len: 1},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{match.TcpMask}, Xor: []byte{0}},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{match.TcpFlags}},
+		)
+	}
+	return exprLocal, nil
+}
+
+// AddFamilyExpr 只匹配一个地址族 meta nfproto ipv4，family 为空时不限制
+func AddFamilyExpr(family string) []expr.Any {
+	var nfproto byte
+	switch family {
+	case nftcmd.FamilyIPv4:
+		nfproto = unix.NFPROTO_IPV4
+	case nftcmd.FamilyIPv6:
+		nfproto = unix.NFPROTO_IPV6
+	default:
+		return nil
+	}
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{nfproto}},
 	}
-	return nil, nil
 }
 
-// AddIPExpr 生成IP规则表达式
-func AddIPExpr(table *nftables.Table, conn *nftables.Conn, setName string, payloadOffset uint32, values []string) ([]expr.Any, error) {
+// AddIPExpr 生成IP规则表达式，同一组地址必须属于同一个地址族
+func AddIPExpr(table *nftables.Table, conn *nftables.Conn, source bool, values []string) ([]expr.Any, error) {
 	arrLength := len(values)
 	var exprLocal []expr.Any
 
 	if arrLength > 0 {
 		fmt.Printf("AddIPExpr %v\n", values)
 
-		// [ payload load 4b @ network header + 12 => reg 1 ]
-		exprLocal = append(exprLocal, &expr.Payload{
-			DestRegister: 1,
-			Base:         expr.PayloadBaseNetworkHeader,
-			Offset:       payloadOffset, // TODO
-			Len:          4,             // TODO
-		})
+		isIPv6, err := iptools.IsIPv6(values[0])
+		if err != nil {
+			return nil, err
+		}
+
+		// ip saddr: 4b @ network header + 12, ip daddr: 4b @ network header + 16
+		// ip6 saddr: 16b @ network header + 8, ip6 daddr: 16b @ network header + 24
+		nfproto := byte(unix.NFPROTO_IPV4)
+		keyType := nftables.TypeIPAddr
+		payloadOffset, payloadLen := uint32(16), uint32(net.IPv4len)
+		if source {
+			payloadOffset = 12
+		}
+		if isIPv6 {
+			nfproto = unix.NFPROTO_IPV6
+			keyType = nftables.TypeIP6Addr
+			payloadOffset, payloadLen = 24, net.IPv6len
+			if source {
+				payloadOffset = 8
+			}
+		}
+
+		exprLocal = append(exprLocal,
+			// [ meta load nfproto => reg 1 ]
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			// [ cmp eq reg 1 0x00000002 ]
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte{nfproto},
+			},
+			// [ payload load 4b @ network header + 12 => reg 1 ]
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       payloadOffset,
+				Len:          payloadLen,
+			})
 
 		if arrLength <= 1 {
 			startIpByte, endIpByte, err := iptools.GetIpBytes(values[0])
@@ -273,26 +360,20 @@ func AddIPExpr(table *nftables.Table, conn *nftables.Conn, setName string, paylo
 			}
 		} else {
 			ipSet := &nftables.Set{
-				Table:   table,
-				Name:    setName,
-				KeyType: nftables.TypeIPAddr,
+				Table:     table,
+				Anonymous: true,
+				Constant:  true,
+				KeyType:   keyType,
 			}
 
-			var setEle []nftables.SetElement
-			for _, ipAddr := range values {
-				startIpByte, endIpByte, err := iptools.GetIpBytes(ipAddr)
-				if err != nil {
-					return nil, err
-				}
-				if len(endIpByte) == 0 {
-					setEle = append(setEle, nftables.SetElement{Key: startIpByte})
-				} else {
-					setEle = append(setEle, nftables.SetElement{Key: startIpByte, KeyEnd: endIpByte})
-				}
+			setEle, interval, err := GetIpSetElements(values)
+			if err != nil {
+				return nil, err
 			}
+			ipSet.Interval = interval
 
 			if err := conn.AddSet(ipSet, setEle); err != nil {
-				return nil, strerror.CreateError("AddInterfaceExpr set error")
+				return nil, strerror.CreateError("AddIPExpr set error")
 			}
 
 			exprLocal = append(exprLocal, &expr.Lookup{
@@ -306,11 +387,61 @@ func AddIPExpr(table *nftables.Table, conn *nftables.Conn, setName string, paylo
 	return exprLocal, nil
 }
 
+// GetIpSetElements 生成地址集合元素，包含地址段时使用区间集合
+// 区间集合的元素和nft一致: 起始地址，结束地址+1(interval-end)
+func GetIpSetElements(values []string) ([]nftables.SetElement, bool, error) {
+	var setEle []nftables.SetElement
+	interval := false
+	for _, ipAddr := range values {
+		if strings.ContainsAny(ipAddr, "-/") {
+			interval = true
+			break
+		}
+	}
+
+	if !interval {
+		for _, ipAddr := range values {
+			startIpByte, _, err := iptools.GetIpBytes(ipAddr)
+			if err != nil {
+				return nil, false, err
+			}
+			setEle = append(setEle, nftables.SetElement{Key: startIpByte})
+		}
+		return setEle, false, nil
+	}
+
+	ipRanges, err := iptools.GetIpRanges(values)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(ipRanges) != 0 && !isZero(ipRanges[0].Start) {
+		setEle = append(setEle, nftables.SetElement{Key: make([]byte, len(ipRanges[0].Start)), IntervalEnd: true})
+	}
+	for _, ipRange := range ipRanges {
+		setEle = append(setEle, nftables.SetElement{Key: ipRange.Start})
+		if end, ok := iptools.NextIp(ipRange.End); ok {
+			setEle = append(setEle, nftables.SetElement{Key: end, IntervalEnd: true})
+		}
+	}
+	return setEle, true, nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // GetMacExpr 获取MAC地址规则表达式
 func GetMacExpr(metaKey expr.MetaKey, mac string) ([]expr.Any, error) {
 	if len(mac) > 0 {
+		// ether daddr 偏移0，ether saddr 偏移6
 		var offset uint32
-		if metaKey == expr.MetaKeyIIFNAME {
+		if metaKey == expr.MetaKeyIIFTYPE {
 			offset = 6
 		}
 
@@ -343,104 +474,333 @@ func GetMacExpr(metaKey expr.MetaKey, mac string) ([]expr.Any, error) {
 	return nil, nil
 }
 
-// GetPortExpr 获取端口规则表达式
-func GetPortExpr(offset uint32, port uint) ([]expr.Any, error) {
-	if port > 0 && port <= 65535 {
-		hexValue := fmt.Sprintf("%X", port)
-		// 确保是4位
-		for i := len(hexValue); i < 4; i++ {
-			hexValue = "0" + hexValue
-		}
-
-		hexByte, _ := hex.DecodeString(hexValue)
-		fmt.Printf("%v,%v\n", port, hexByte)
-
-		exprLocal := []expr.Any{
-			// [ payload load 2b @ transport header + 2 => reg 1 ]
-			&expr.Payload{
-				DestRegister: 1,
-				Base:         expr.PayloadBaseTransportHeader,
-				Offset:       offset,
-				Len:          2,
-			},
-			// [ cmp eq reg 1 0x000010e1 ]
-			&expr.Cmp{
+// GetPortExpr 获取端口规则表达式，单个端口使用cmp，单个范围使用range，多个端口使用匿名集合
+// [ payload load 2b @ transport header + 2 => reg 1 ]
+// [ range eq reg 1 0x0000401f 0x0000a41f ]
+func GetPortExpr(table *nftables.Table, conn *nftables.Conn, offset uint32, ports model.Ports) ([]expr.Any, error) {
+	ranges, err := nftcmd.GetPortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	fmt.Printf("GetPortExpr %v\n", ports)
+
+	exprLocal := []expr.Any{&expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseTransportHeader,
+		Offset:       offset,
+		Len:          2,
+	}}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		if r.Start == r.End {
+			exprLocal = append(exprLocal, &expr.Cmp{
 				Op:       expr.CmpOpEq,
 				Register: 1,
-				Data:     hexByte,
-			},
+				Data:     portBytes(uint64(r.Start)),
+			})
+		} else {
+			exprLocal = append(exprLocal, &expr.Range{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				FromData: portBytes(uint64(r.Start)),
+				ToData:   portBytes(uint64(r.End)),
+			})
 		}
-
 		return exprLocal, nil
 	}
-	return nil, nil
-}
 
-type TimeType uint
+	setEle, interval, err := getPortSetElements(ports)
+	if err != nil {
+		return nil, err
+	}
+	portSet := &nftables.Set{
+		Table:     table,
+		Anonymous: true,
+		Constant:  true,
+		Interval:  interval,
+		KeyType:   nftables.TypeInetService,
+	}
+	if err := conn.AddSet(portSet, setEle); err != nil {
+		return nil, strerror.CreateError("GetPortExpr set error")
+	}
+
+	exprLocal = append(exprLocal, &expr.Lookup{
+		SourceRegister: 1,
+		SetName:        portSet.Name,
+		SetID:          portSet.ID,
+	})
+	return exprLocal, nil
+}
 
+// 内核 NFT_META_TIME_* ，x/sys/unix 中还没有定义
 const (
-	HourType  TimeType = 0
-	DayType   TimeType = 1
-	MonthType TimeType = 2
+	MetaKeyTimeNS   expr.MetaKey = 30 // 纳秒时间戳 uint64
+	MetaKeyTimeDay  expr.MetaKey = 31 // 星期 uint8 0-6
+	MetaKeyTimeHour expr.MetaKey = 32 // 当天经过的秒数(UTC) uint32
 
-	HourKey  = "hour"
-	DayKey   = "day"
-	MonthKey = "Month"
+	daySeconds = 24 * 60 * 60
 )
 
-type PolicyTime struct {
-	Type    TimeType
-	IsRange bool
-	Value   interface{} // 单个值
-	Start   interface{} // 范围起始
-	End     interface{} // 范围结束
+// timeInterval 时间区间，主机字节序的数值
+type timeInterval struct {
+	Start uint64
+	End   uint64
 }
 
-// GetTimeExpr 获取时间
-// 格式  hour@16:00:00-18:00:00,20:00:00-21:00:00 ; day@1,3-5 ; month@1,20-25
-func GetTimeExpr(table *nftables.Table, conn *nftables.Conn, setName string, values []string) ([]expr.Any, error) {
-	arrLength := len(values)
-	var exprLocal []expr.Any
-	var policyRaw []PolicyTime
+// parseTimeRange 解析单个时间或者时间范围
+func parseTimeRange(value string, parse func(string) (uint64, error)) (uint64, uint64, error) {
+	if !strings.Contains(value, "-") {
+		v, err := parse(value)
+		return v, v, err
+	}
 
-	if arrLength > 0 {
-		fmt.Printf("GetTimeExpr %v\n", values)
+	startStr, endStr, err := nftcmd.SplitTimeRange(value)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, err := parse(startStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parse(endStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
 
-		for _, timeVal := range values {
-			timeSplit1 := strings.Split(timeVal, "@")
-			if len(timeSplit1) < 2 {
-				return nil, strerror.CreateError("time type error")
-			}
+// parseTimeStamp 2022-11-22 18:00:00 本地时间转换为纳秒时间戳
+func parseTimeStamp(value string) (uint64, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local)
+		if err == nil {
+			return uint64(t.UnixNano()), nil
+		}
+	}
+	return 0, strerror.CreateError("time stamp error:" + value)
+}
 
-			timeTypeStr := timeSplit1[0]
-			timeValArr := strings.Split(timeSplit1[1], ",")
+// parseHour 18:00:00 本地时间转换为秒数，时区偏移在生成区间时处理
+func parseHour(value string) (uint64, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		t, err := time.Parse(layout, strings.TrimSpace(value))
+		if err == nil {
+			return uint64(t.Hour()*3600 + t.Minute()*60 + t.Second()), nil
+		}
+	}
+	return 0, strerror.CreateError("hour error:" + value)
+}
 
-			if len(timeValArr) == 0 {
-				return nil, strerror.CreateError("time value error")
-			}
+// parseWeek 0-6 0表示星期天
+func parseWeek(value string) (uint64, error) {
+	week, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || week < 0 || week > 6 {
+		return 0, strerror.CreateError("week error:" + value)
+	}
+	return uint64(week), nil
+}
 
-			var currTimeType TimeType
-			switch timeTypeStr {
-			case HourKey:
-				currTimeType = HourType
-			case DayKey:
-				currTimeType = DayType
-			case MonthKey:
-				currTimeType = MonthType
-			}
+// getHourIntervals 内核按UTC计算小时，和nft一样按当前时区换算，跨零点的区间拆成两段
+func getHourIntervals(values []string) ([]timeInterval, error) {
+	_, offset := time.Now().Zone()
+	toUTC := func(seconds uint64) uint64 {
+		return uint64(((int64(seconds)-int64(offset))%daySeconds + daySeconds) % daySeconds)
+	}
+
+	var intervals []timeInterval
+	for _, value := range values {
+		start, end, err := parseTimeRange(value, parseHour)
+		if err != nil {
+			return nil, err
+		}
+
+		start, end = toUTC(start), toUTC(end)
+		if start <= end {
+			intervals = append(intervals, timeInterval{Start: start, End: end})
+		} else {
+			intervals = append(intervals, timeInterval{Start: start, End: daySeconds - 1}, timeInterval{Start: 0, End: end})
+		}
+	}
+	return intervals, nil
+}
+
+func getTimeIntervals(values []string, parse func(string) (uint64, error)) ([]timeInterval, error) {
+	var intervals []timeInterval
+	for _, value := range values {
+		start, end, err := parseTimeRange(value, parse)
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, strerror.CreateError("time range error:" + value)
+		}
+		intervals = append(intervals, timeInterval{Start: start, End: end})
+	}
+	return intervals, nil
+}
 
-			for _, val := range timeValArr {
-				if strings.Contains(val, "-") {
-					timeRangeArr := strings.Split(val, "-")
-					if len(timeRangeArr) < 2 {
-						return nil, strerror.CreateError("time range error")
-					}
-					policyRaw = append(policyRaw, PolicyTime{Type: currTimeType, IsRange: true, Start: timeRangeArr[0], End: timeRangeArr[1]})
-				} else {
-					policyRaw = append(policyRaw, PolicyTime{Type: currTimeType, Value: val})
+// mergeTimeIntervals 排序并合并重叠、相邻的区间
+func mergeTimeIntervals(intervals []timeInterval) []timeInterval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start < intervals[j].Start
+	})
+
+	var merged []timeInterval
+	for _, interval := range intervals {
+		if len(merged) != 0 {
+			last := &merged[len(merged)-1]
+			if interval.Start <= last.End+1 {
+				if interval.End > last.End {
+					last.End = interval.End
 				}
+				continue
 			}
 		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// timeBytes 网络字节序，规则中先用byteorder把寄存器转换为网络字节序再比较
+func timeBytes(value uint64, size uint32) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	return b[8-size:]
+}
+
+// getTimeIntervalExpr 单个区间使用range，多个区间使用匿名区间集合
+// [ meta load hour => reg 1 ]
+// [ byteorder reg 1 = hton(reg 1, 4, 4) ]
+// [ range eq reg 1 0x00002a30 0x00003840 ]
+func getTimeIntervalExpr(table *nftables.Table, conn *nftables.Conn, key expr.MetaKey, keyType nftables.SetDatatype, intervals []timeInterval) ([]expr.Any, error) {
+	size := keyType.Bytes
+	intervals = mergeTimeIntervals(intervals)
+
+	exprLocal := []expr.Any{&expr.Meta{Key: key, Register: 1}}
+	if size > 1 {
+		exprLocal = append(exprLocal, &expr.Byteorder{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Op:             expr.ByteorderHton,
+			Len:            size,
+			Size:           size,
+		})
+	}
+
+	if len(intervals) == 1 {
+		interval := intervals[0]
+		if interval.Start == interval.End {
+			exprLocal = append(exprLocal, &expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     timeBytes(interval.Start, size),
+			})
+		} else {
+			exprLocal = append(exprLocal, &expr.Range{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				FromData: timeBytes(interval.Start, size),
+				ToData:   timeBytes(interval.End, size),
+			})
+		}
+		return exprLocal, nil
+	}
+
+	timeSet := &nftables.Set{
+		Table:     table,
+		Anonymous: true,
+		Constant:  true,
+		Interval:  true,
+		KeyType:   keyType,
+	}
+
+	// 区间集合的元素和nft一致: 起始值，结束值+1(interval-end)
+	var setEle []nftables.SetElement
+	if intervals[0].Start != 0 {
+		setEle = append(setEle, nftables.SetElement{Key: timeBytes(0, size), IntervalEnd: true})
+	}
+	maxValue := uint64(1)<<(size*8) - 1
+	if size == 8 {
+		maxValue = ^uint64(0)
+	}
+	for _, interval := range intervals {
+		setEle = append(setEle, nftables.SetElement{Key: timeBytes(interval.Start, size)})
+		if interval.End < maxValue {
+			setEle = append(setEle, nftables.SetElement{Key: timeBytes(interval.End+1, size), IntervalEnd: true})
+		}
+	}
+
+	if err := conn.AddSet(timeSet, setEle); err != nil {
+		return nil, strerror.CreateError("GetTimeExpr set error")
+	}
+
+	exprLocal = append(exprLocal, &expr.Lookup{
+		SourceRegister: 1,
+		SetName:        timeSet.Name,
+		SetID:          timeSet.ID,
+	})
+	return exprLocal, nil
+}
+
+// GetTimeExpr 获取时间规则表达式，与nft命令生成的规则一致
+// meta time { "2022-11-01 18:00:00"-"2022-11-01 19:00:00", ... } meta hour "18:00:00"-"19:00:00" meta day { 1,3-5 }
+func GetTimeExpr(table *nftables.Table, conn *nftables.Conn, values []model.PolicyTime) ([]expr.Any, error) {
+	var exprLocal []expr.Any
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+	fmt.Printf("GetTimeExpr %v\n", values)
+
+	timeValues, err := nftcmd.GetPolicyTimeValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	// 日期时间，时间戳
+	if len(timeValues.TimeStamps) != 0 {
+		intervals, err := getTimeIntervals(timeValues.TimeStamps, parseTimeStamp)
+		if err != nil {
+			return nil, err
+		}
+		timeExpr, err := getTimeIntervalExpr(table, conn, MetaKeyTimeNS, nftables.TypeTimeDate, intervals)
+		if err != nil {
+			return nil, err
+		}
+		exprLocal = append(exprLocal, timeExpr...)
+	}
+
+	// 小时
+	if len(timeValues.Hours) != 0 {
+		intervals, err := getHourIntervals(timeValues.Hours)
+		if err != nil {
+			return nil, err
+		}
+		// nft中hour类型是4字节
+		hourType := nftables.TypeTimeHour
+		hourType.Bytes = 4
+		hourExpr, err := getTimeIntervalExpr(table, conn, MetaKeyTimeHour, hourType, intervals)
+		if err != nil {
+			return nil, err
+		}
+		exprLocal = append(exprLocal, hourExpr...)
+	}
+
+	// 周
+	if len(timeValues.Weeks) != 0 {
+		intervals, err := getTimeIntervals(timeValues.Weeks, parseWeek)
+		if err != nil {
+			return nil, err
+		}
+		dayExpr, err := getTimeIntervalExpr(table, conn, MetaKeyTimeDay, nftables.TypeTimeDay, intervals)
+		if err != nil {
+			return nil, err
+		}
+		exprLocal = append(exprLocal, dayExpr...)
 	}
 
 	return exprLocal, nil
@@ -449,8 +809,8 @@ func GetTimeExpr(table *nftables.Table, conn *nftables.Conn, setName string, val
 // GetLogExpr 获取log规则表达式
 func GetLogExpr(logTag string) ([]expr.Any, error) {
 	if len(logTag) > 0 {
-		// 日志模块
-		keyGQ := uint32((1 << unix.NFTA_LOG_PREFIX))
+		// 日志模块，发送到NFLOG group 1
+		keyGQ := uint32((1 << unix.NFTA_LOG_PREFIX) | (1 << unix.NFTA_LOG_GROUP))
 		exprLocal := []expr.Any{&expr.Log{
 			Key:        keyGQ,
 			QThreshold: uint16(20),
@@ -463,6 +823,96 @@ func GetLogExpr(logTag string) ([]expr.Any, error) {
 	return nil, nil
 }
 
+// ObjectTypeCounter 命名计数器的对象类型 NFT_OBJECT_COUNTER
+const ObjectTypeCounter = 1
+
+// GetCounterExpr 引用策略的命名计数器，计数器加入当前批次，已存在时不会清零
+// counter name fd-counter-policy1
+func GetCounterExpr(table *nftables.Table, conn *nftables.Conn, comment string) []expr.Any {
+	name := nftcmd.CounterName(comment)
+	if len(name) == 0 {
+		return nil
+	}
+	conn.AddObj(&nftables.CounterObj{Table: table, Name: name})
+	return []expr.Any{&expr.Objref{Type: ObjectTypeCounter, Name: name}}
+}
+
+// getLimitStatements 限速和连接数限制，都是超过限制时命中
+func getLimitStatements(match nftcmd.LimitMatch) []expr.Any {
+	var exprs []expr.Any
+	if match.Rate != 0 {
+		limitType := expr.LimitTypePkts
+		if match.Bytes {
+			limitType = expr.LimitTypePktBytes
+		}
+		exprs = append(exprs, &expr.Limit{
+			Type:  limitType,
+			Rate:  match.Rate,
+			Over:  true,
+			Unit:  expr.LimitTime(match.Unit),
+			Burst: match.Burst,
+		})
+	}
+	if match.ConnCount != 0 {
+		exprs = append(exprs, &expr.Connlimit{Count: match.ConnCount, Flags: expr.NFT_CONNLIMIT_F_INV})
+	}
+	return exprs
+}
+
+// GetLimitExpr 获取限速和连接数限制表达式
+// limit rate over 100/second burst 5 packets ct count over 10
+// 按地址计算时地址作为动态集合的元素，每个地址单独限速 update @fd-meter-policy1-ip4 { ip saddr limit rate over 100/second }
+func GetLimitExpr(table *nftables.Table, conn *nftables.Conn, policy model.Policy) ([]expr.Any, error) {
+	match, err := nftcmd.GetLimitMatch(policy)
+	if err != nil || !match.Limited() {
+		return nil, err
+	}
+	statements := getLimitStatements(match)
+	if len(match.Per) == 0 {
+		return statements, nil
+	}
+
+	ipv6, err := nftcmd.MeterIPv6(policy)
+	if err != nil {
+		return nil, err
+	}
+	set := &nftables.Set{
+		Table:      table,
+		Name:       nftcmd.MeterSetName(policy, ipv6),
+		KeyType:    nftables.TypeIPAddr,
+		Dynamic:    true,
+		HasTimeout: true,
+		Timeout:    nftcmd.MeterTimeout,
+	}
+	offset, length := uint32(12), uint32(net.IPv4len)
+	if ipv6 {
+		set.KeyType = nftables.TypeIP6Addr
+		offset, length = 8, net.IPv6len
+	}
+	if match.Per == nftcmd.LimitPerDest {
+		offset += length
+	}
+
+	// 集合已经存在时直接使用，策略更新后地址的计数继续有效
+	if existSet, err := conn.GetSetByName(table, set.Name); err == nil {
+		existSet.Table = table
+		set = existSet
+	} else if err := conn.AddSet(set, nil); err != nil {
+		return nil, err
+	}
+
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: length},
+		&expr.Dynset{
+			SrcRegKey: 1,
+			SetName:   set.Name,
+			SetID:     set.ID,
+			Operation: unix.NFT_DYNSET_OP_UPDATE,
+			Exprs:     statements,
+		},
+	}, nil
+}
+
 // GetPortExpr 获取端口规则表达式
 func GetActionExpr(action string) ([]expr.Any, error) {
 	var exprLocal []expr.Any
@@ -493,3 +943,30 @@ func GetActionExpr(action string) ([]expr.Any, error) {
 	}
 	return nil, nil
 }
+
+// 规则注释在UserData中的类型，与nft命令 comment "..." 一致
+const udataRuleComment = 0
+
+// GetCommentUserData 规则注释编码为UserData: 类型(1字节) 长度(1字节) 内容(以"\x00"结尾)
+func GetCommentUserData(comment string) []byte {
+	value := []byte(comment + "\x00")
+	if len(value) > 255 {
+		value = append(value[:254], 0)
+	}
+	return append([]byte{udataRuleComment, byte(len(value))}, value...)
+}
+
+// GetRuleComment 从规则的UserData中解析注释
+func GetRuleComment(userData []byte) string {
+	for len(userData) >= 2 {
+		udataType, length := userData[0], int(userData[1])
+		if len(userData) < 2+length {
+			break
+		}
+		if udataType == udataRuleComment {
+			return strings.TrimRight(string(userData[2:2+length]), "\x00")
+		}
+		userData = userData[2+length:]
+	}
+	return ""
+}
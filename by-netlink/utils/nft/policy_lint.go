@@ -0,0 +1,442 @@
+package nft
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/google/nftables/binaryutil"
+
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	iptools "netvine.com/firewall/server/utils"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// lintSpace 策略匹配的报文空间，每个维度是有序、不重叠的区间
+// 地址按地址族分开，某个地址族下源或目的没有地址时该地址族为空
+type lintSpace struct {
+	dims   [][]iptools.IpRange
+	family [2][2][]iptools.IpRange
+}
+
+// fullRange 某个宽度的全部取值
+func fullRange(size int) []iptools.IpRange {
+	return []iptools.IpRange{{Start: make([]byte, size), End: bytes.Repeat([]byte{0xff}, size)}}
+}
+
+// mergeRanges 排序并合并重叠、相邻的区间
+func mergeRanges(ranges []iptools.IpRange) []iptools.IpRange {
+	sorted := append([]iptools.IpRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Start, sorted[j].Start) < 0
+	})
+
+	var merged []iptools.IpRange
+	for _, r := range sorted {
+		if len(merged) != 0 {
+			last := &merged[len(merged)-1]
+			next, ok := iptools.NextIp(last.End)
+			if !ok || bytes.Compare(r.Start, next) <= 0 {
+				if bytes.Compare(r.End, last.End) > 0 {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// containsRanges b 中的区间都在 a 中，a 已经合并
+func containsRanges(a []iptools.IpRange, b []iptools.IpRange) bool {
+	for _, rb := range b {
+		contained := false
+		for _, ra := range a {
+			if bytes.Compare(ra.Start, rb.Start) <= 0 && bytes.Compare(rb.End, ra.End) <= 0 {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return false
+		}
+	}
+	return true
+}
+
+// intersectRanges a 和 b 是否有交集
+func intersectRanges(a []iptools.IpRange, b []iptools.IpRange) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if bytes.Compare(ra.Start, rb.End) <= 0 && bytes.Compare(rb.Start, ra.End) <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func valueRanges(values [][]byte, size int) []iptools.IpRange {
+	if len(values) == 0 {
+		return fullRange(size)
+	}
+	var ranges []iptools.IpRange
+	for _, value := range values {
+		ranges = append(ranges, iptools.IpRange{Start: value, End: value})
+	}
+	return mergeRanges(ranges)
+}
+
+func intervalRanges(intervals []timeInterval, size uint32) []iptools.IpRange {
+	var ranges []iptools.IpRange
+	for _, interval := range intervals {
+		ranges = append(ranges, iptools.IpRange{Start: timeBytes(interval.Start, size), End: timeBytes(interval.End, size)})
+	}
+	return mergeRanges(ranges)
+}
+
+func portRanges(ports model.Ports) ([]iptools.IpRange, error) {
+	if len(ports) == 0 {
+		return fullRange(2), nil
+	}
+
+	parsed, err := nftcmd.GetPortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []iptools.IpRange
+	for _, r := range parsed {
+		ranges = append(ranges, iptools.IpRange{Start: portBytes(uint64(r.Start)), End: portBytes(uint64(r.End))})
+	}
+	return ranges, nil
+}
+
+func macRanges(mac string) []iptools.IpRange {
+	if len(mac) == 0 {
+		return fullRange(6)
+	}
+	return valueRanges([][]byte{macaddr(mac)}, 6)
+}
+
+func ifnameRanges(names []string) []iptools.IpRange {
+	var values [][]byte
+	for _, name := range names {
+		values = append(values, ifname(name))
+	}
+	return valueRanges(values, 16)
+}
+
+// protocolRanges 协议、ICMP类型、ICMP代码、TCP标志四个维度
+func protocolRanges(policy model.Policy) ([][]iptools.IpRange, error) {
+	match, err := nftcmd.GetProtocolMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var protocols [][]byte
+	for _, protocol := range match.Protocols {
+		protocols = append(protocols, []byte{protocol})
+	}
+	dims := [][]iptools.IpRange{valueRanges(protocols, 1)}
+
+	for _, value := range []int{match.IcmpType, match.IcmpCode} {
+		if value < 0 {
+			dims = append(dims, fullRange(1))
+		} else {
+			dims = append(dims, valueRanges([][]byte{{byte(value)}}, 1))
+		}
+	}
+
+	// 满足 flags & mask == value 的所有标志组合
+	var flags [][]byte
+	for v := 0; v < 256; v++ {
+		if uint8(v)&match.TcpMask == match.TcpFlags {
+			flags = append(flags, []byte{byte(v)})
+		}
+	}
+	dims = append(dims, valueRanges(flags, 1))
+	return dims, nil
+}
+
+// ctRanges 连接状态、方向、标记三个维度，报文只有一个连接状态
+func ctRanges(policy model.Policy) ([][]iptools.IpRange, error) {
+	match, err := nftcmd.GetCtMatch(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var states [][]byte
+	for bit := uint32(1); bit <= nftcmd.CtStateUntracked; bit <<= 1 {
+		if match.State&bit != 0 {
+			states = append(states, []byte{byte(bit)})
+		}
+	}
+	dims := [][]iptools.IpRange{valueRanges(states, 1)}
+
+	var directions [][]byte
+	if len(match.Direction) != 0 {
+		directions = append(directions, []byte{ctDirectionValue(match.Direction)})
+	}
+	dims = append(dims, valueRanges(directions, 1))
+
+	var marks [][]byte
+	if match.HasMark {
+		marks = append(marks, binaryutil.BigEndian.PutUint32(match.Mark))
+	}
+	return append(dims, valueRanges(marks, 4)), nil
+}
+
+// ipFamilyRanges 按地址族拆分地址，没有地址时两个地址族都是全部地址
+func ipFamilyRanges(values []string) ([2][]iptools.IpRange, error) {
+	var familys [2][]iptools.IpRange
+	if len(values) == 0 {
+		return [2][]iptools.IpRange{fullRange(net.IPv4len), fullRange(net.IPv6len)}, nil
+	}
+
+	for _, value := range values {
+		start, end, err := iptools.GetIpBytes(value)
+		if err != nil {
+			return familys, err
+		}
+		if len(end) == 0 {
+			end = start
+		}
+		i := 0
+		if len(start) == net.IPv6len {
+			i = 1
+		}
+		familys[i] = append(familys[i], iptools.IpRange{Start: start, End: end})
+	}
+	for i := range familys {
+		familys[i] = mergeRanges(familys[i])
+	}
+	return familys, nil
+}
+
+// timeRanges 时间戳、小时、星期三个维度，没有设置的维度是全部时间
+func timeRanges(times []model.PolicyTime) ([][]iptools.IpRange, error) {
+	dims := [][]iptools.IpRange{fullRange(8), fullRange(4), fullRange(1)}
+	if len(times) == 0 {
+		return dims, nil
+	}
+
+	timeValues, err := nftcmd.GetPolicyTimeValues(times)
+	if err != nil {
+		return nil, err
+	}
+	if len(timeValues.TimeStamps) != 0 {
+		intervals, err := getTimeIntervals(timeValues.TimeStamps, parseTimeStamp)
+		if err != nil {
+			return nil, err
+		}
+		dims[0] = intervalRanges(intervals, 8)
+	}
+	if len(timeValues.Hours) != 0 {
+		intervals, err := getHourIntervals(timeValues.Hours)
+		if err != nil {
+			return nil, err
+		}
+		dims[1] = intervalRanges(intervals, 4)
+	}
+	if len(timeValues.Weeks) != 0 {
+		intervals, err := getTimeIntervals(timeValues.Weeks, parseWeek)
+		if err != nil {
+			return nil, err
+		}
+		dims[2] = intervalRanges(intervals, 1)
+	}
+	return dims, nil
+}
+
+// getLintSpace 策略的匹配空间，与生成的规则表达式一致
+func getLintSpace(policy model.Policy) (lintSpace, error) {
+	var space lintSpace
+
+	policy, err := nftcmd.ExpandApp(policy)
+	if err != nil {
+		return space, err
+	}
+	if _, err := nftcmd.GetLimitMatch(policy); err != nil {
+		return space, err
+	}
+	protocolDims, err := protocolRanges(policy)
+	if err != nil {
+		return space, err
+	}
+	space.dims = append([][]iptools.IpRange{
+		ifnameRanges(policy.SRegion),
+		ifnameRanges(policy.DRegion),
+		macRanges(policy.SMac),
+		macRanges(policy.DMac),
+	}, protocolDims...)
+	for _, ports := range []model.Ports{policy.SPort, policy.DPort} {
+		ranges, err := portRanges(ports)
+		if err != nil {
+			return space, err
+		}
+		space.dims = append(space.dims, ranges)
+	}
+
+	timeDims, err := timeRanges(policy.Time)
+	if err != nil {
+		return space, err
+	}
+	space.dims = append(space.dims, timeDims...)
+
+	ctDims, err := ctRanges(policy)
+	if err != nil {
+		return space, err
+	}
+	space.dims = append(space.dims, ctDims...)
+
+	sIps, err := ipFamilyRanges(policy.SIp)
+	if err != nil {
+		return space, err
+	}
+	dIps, err := ipFamilyRanges(policy.DIp)
+	if err != nil {
+		return space, err
+	}
+	for i := range space.family {
+		if len(sIps[i]) != 0 && len(dIps[i]) != 0 {
+			space.family[i] = [2][]iptools.IpRange{sIps[i], dIps[i]}
+		}
+	}
+	if space.empty() {
+		return space, strerror.CreateError("source ip and destination ip are not in the same family")
+	}
+	return space, nil
+}
+
+func (s lintSpace) empty() bool {
+	return len(s.family[0][0]) == 0 && len(s.family[1][0]) == 0
+}
+
+// covers s 是否完全覆盖 other
+func (s lintSpace) covers(other lintSpace) bool {
+	for i := range s.dims {
+		if !containsRanges(s.dims[i], other.dims[i]) {
+			return false
+		}
+	}
+	for i := range other.family {
+		if len(other.family[i][0]) == 0 {
+			continue
+		}
+		if len(s.family[i][0]) == 0 ||
+			!containsRanges(s.family[i][0], other.family[i][0]) || !containsRanges(s.family[i][1], other.family[i][1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects s 和 other 是否有相同的报文
+func (s lintSpace) intersects(other lintSpace) bool {
+	for i := range s.dims {
+		if !intersectRanges(s.dims[i], other.dims[i]) {
+			return false
+		}
+	}
+	for i := range s.family {
+		if len(s.family[i][0]) != 0 && len(other.family[i][0]) != 0 &&
+			intersectRanges(s.family[i][0], other.family[i][0]) && intersectRanges(s.family[i][1], other.family[i][1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEffect 命中后的动作和日志相同，允许的应用是否深度检测也要相同
+func sameEffect(a model.Policy, b model.Policy) bool {
+	return a.Action == b.Action && nftcmd.PolicyRuleAction(a) == nftcmd.PolicyRuleAction(b) && nftcmd.LogPrefix(a) == nftcmd.LogPrefix(b)
+}
+
+func lintIssue(issueType string, policys []model.Policy, index int, related int, message string) model.LintIssue {
+	issue := model.LintIssue{Type: issueType, Index: index, Id: policys[index].Id, Related: related, Message: message}
+	if related >= 0 {
+		issue.RelatedId = policys[related].Id
+	}
+	return issue
+}
+
+// LintPolicys 按链中的顺序检查策略之间的覆盖、冗余和冲突
+func LintPolicys(policys []model.Policy) []model.LintIssue {
+	var issues []model.LintIssue
+
+	spaces := make([]lintSpace, len(policys))
+	valid := make([]bool, len(policys))
+	for i, policy := range policys {
+		space, err := getLintSpace(policy)
+		if err != nil {
+			issues = append(issues, lintIssue(model.LintInvalid, policys, i, -1, err.Error()))
+			continue
+		}
+		spaces[i], valid[i] = space, true
+	}
+
+	// 被前面的策略完全覆盖的策略不会命中，不再与后面的策略比较
+	covered := make([]bool, len(policys))
+	removable := make([]bool, len(policys))
+	for j := range policys {
+		if !valid[j] {
+			continue
+		}
+
+		// 只报告第一条覆盖它的策略，带有限速、连接数限制的策略只处理超过限制的报文，不会覆盖其他策略
+		for i := 0; i < j; i++ {
+			if !valid[i] || nftcmd.HasLimit(policys[i]) || !spaces[i].covers(spaces[j]) {
+				continue
+			}
+			if sameEffect(policys[i], policys[j]) {
+				issues = append(issues, lintIssue(model.LintRedundant, policys, j, i,
+					fmt.Sprintf("policy #%d is covered by policy #%d with the same action", j, i)))
+			} else {
+				issues = append(issues, lintIssue(model.LintShadowed, policys, j, i,
+					fmt.Sprintf("policy #%d never matches, policy #%d matches first with action %s", j, i, nftcmd.ActionName(policys[i].Action))))
+			}
+			covered[j] = true
+			break
+		}
+		if covered[j] {
+			continue
+		}
+
+		for i := 0; i < j; i++ {
+			if !valid[i] || covered[i] || !spaces[i].intersects(spaces[j]) {
+				continue
+			}
+
+			if spaces[j].covers(spaces[i]) {
+				if !sameEffect(policys[i], policys[j]) {
+					issues = append(issues, lintIssue(model.LintOverlap, policys, i, j,
+						fmt.Sprintf("policy #%d is an exception of policy #%d with action %s", i, j, nftcmd.ActionName(policys[j].Action))))
+				} else if !removable[i] && !nftcmd.HasLimit(policys[j]) && !conflictBetween(policys, spaces, valid, covered, i, j) {
+					removable[i] = true
+					issues = append(issues, lintIssue(model.LintRedundant, policys, i, j,
+						fmt.Sprintf("policy #%d is covered by policy #%d with the same action", i, j)))
+				}
+				continue
+			}
+
+			if !sameEffect(policys[i], policys[j]) {
+				issues = append(issues, lintIssue(model.LintCorrelated, policys, j, i,
+					fmt.Sprintf("policy #%d partially overlaps policy #%d with a different action", j, i)))
+			}
+		}
+	}
+	return issues
+}
+
+// conflictBetween i 和 j 之间是否有与 i 重叠且动作不同的策略，有则删除 i 会改变结果
+func conflictBetween(policys []model.Policy, spaces []lintSpace, valid []bool, covered []bool, i int, j int) bool {
+	for k := i + 1; k < j; k++ {
+		if valid[k] && !covered[k] && spaces[k].intersects(spaces[i]) && !sameEffect(policys[k], policys[i]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package nft
+
+import (
+	"reflect"
+	"testing"
+
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+)
+
+func TestLintPolicys(t *testing.T) {
+	policy := func(id string, sip string, action int, ports ...string) model.Policy {
+		p := model.Policy{Id: id, SIp: []string{sip}, Action: action}
+		if len(ports) != 0 {
+			p.Protocol, p.DPort = "tcp", ports
+		}
+		return p
+	}
+
+	type issue struct {
+		Type    string
+		Index   int
+		Related int
+	}
+
+	tests := []struct {
+		name    string
+		policys []model.Policy
+		want    []issue
+	}{
+		{
+			"disjoint",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.DROP), policy("b", "172.16.0.0/12", nftcmd.ALLOW)},
+			nil,
+		},
+		{
+			"shadowed by broader drop",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.DROP), policy("b", "10.1.0.0/16", nftcmd.ALLOW, "22")},
+			[]issue{{model.LintShadowed, 1, 0}},
+		},
+		{
+			"redundant after broader policy",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.DROP), policy("b", "10.1.0.0/16", nftcmd.DROP)},
+			[]issue{{model.LintRedundant, 1, 0}},
+		},
+		{
+			"redundant before broader policy",
+			[]model.Policy{policy("a", "10.1.0.0/16", nftcmd.DROP), policy("b", "10.0.0.0/8", nftcmd.DROP)},
+			[]issue{{model.LintRedundant, 0, 1}},
+		},
+		{
+			"exception of broader policy",
+			[]model.Policy{policy("a", "10.1.0.0/16", nftcmd.ALLOW, "22"), policy("b", "10.0.0.0/8", nftcmd.DROP)},
+			[]issue{{model.LintOverlap, 0, 1}},
+		},
+		{
+			"partial overlap with different action",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.ALLOW, "22"), policy("b", "10.1.0.0/16", nftcmd.DROP, "22", "80")},
+			[]issue{{model.LintCorrelated, 1, 0}},
+		},
+		{
+			"partial overlap with same action",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.DROP, "22"), policy("b", "10.1.0.0/16", nftcmd.DROP, "22", "80")},
+			nil,
+		},
+		{
+			"conflict in between keeps policy",
+			[]model.Policy{
+				policy("a", "10.1.0.0/16", nftcmd.DROP),
+				policy("b", "10.0.0.0/15", nftcmd.ALLOW, "22"),
+				policy("c", "10.0.0.0/8", nftcmd.DROP),
+			},
+			[]issue{{model.LintCorrelated, 1, 0}, {model.LintOverlap, 1, 2}},
+		},
+		{
+			"shadowed policy not compared again",
+			[]model.Policy{
+				policy("a", "10.0.0.0/8", nftcmd.DROP),
+				policy("b", "10.1.0.0/16", nftcmd.ALLOW),
+				policy("c", "10.1.1.0/24", nftcmd.DROP),
+			},
+			[]issue{{model.LintShadowed, 1, 0}, {model.LintRedundant, 2, 0}},
+		},
+		{
+			"different log is not the same effect",
+			[]model.Policy{
+				policy("a", "10.0.0.0/8", nftcmd.DROP),
+				{Id: "b", SIp: []string{"10.1.0.0/16"}, Action: nftcmd.DROP, LogTag: "ssh"},
+			},
+			[]issue{{model.LintShadowed, 1, 0}},
+		},
+		{
+			"limit does not cover",
+			[]model.Policy{
+				{Id: "a", SIp: []string{"10.0.0.0/8"}, Action: nftcmd.DROP, Limit: "100/second"},
+				policy("b", "10.1.0.0/16", nftcmd.DROP),
+			},
+			nil,
+		},
+		{
+			"invalid port",
+			[]model.Policy{policy("a", "10.0.0.0/8", nftcmd.DROP, "0"), policy("b", "10.1.0.0/16", nftcmd.DROP)},
+			[]issue{{model.LintInvalid, 0, -1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []issue
+			for _, i := range LintPolicys(tt.policys) {
+				got = append(got, issue{i.Type, i.Index, i.Related})
+				if i.Id != tt.policys[i.Index].Id {
+					t.Errorf("issue %v id = %s, want %s", i, i.Id, tt.policys[i.Index].Id)
+				}
+				if i.Related >= 0 && i.RelatedId != tt.policys[i.Related].Id {
+					t.Errorf("issue %v related id = %s, want %s", i, i.RelatedId, tt.policys[i.Related].Id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("LintPolicys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package nft
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	iptools "netvine.com/firewall/server/utils"
+	strerror "netvine.com/firewall/server/utils/error"
+)
+
+// matchProtocol 报文协议、ICMP类型和TCP标志是否满足策略
+func matchProtocol(policy model.Policy, packet model.Packet) (bool, error) {
+	match, err := nftcmd.GetProtocolMatch(policy)
+	if err != nil || len(match.Protocols) == 0 {
+		return err == nil, err
+	}
+	if len(packet.Protocol) == 0 {
+		return false, nil
+	}
+
+	protocol, err := nftcmd.ParseProtocol(packet.Protocol)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, p := range match.Protocols {
+		if p == protocol {
+			found = true
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	if match.IcmpType >= 0 {
+		if len(packet.IcmpType) == 0 {
+			return false, nil
+		}
+		icmpType, err := nftcmd.ParseIcmpType(protocol, packet.IcmpType)
+		if err != nil || int(icmpType) != match.IcmpType {
+			return false, err
+		}
+	}
+	if match.IcmpCode >= 0 {
+		icmpCode, err := strconv.ParseUint(packet.IcmpCode, 10, 8)
+		if err != nil || int(icmpCode) != match.IcmpCode {
+			return false, nil
+		}
+	}
+
+	if match.TcpMask != 0 {
+		var flags uint8
+		if len(packet.TcpFlags) != 0 {
+			mask, set, err := nftcmd.ParseTcpFlags(packet.TcpFlags)
+			if err != nil {
+				return false, err
+			}
+			flags = mask & set
+		}
+		if flags&match.TcpMask != match.TcpFlags {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchIp 报文地址是否在策略地址中，inet表中IPv4地址只匹配IPv4报文
+func matchIp(values []string, ip []byte) (bool, error) {
+	if len(values) == 0 {
+		return true, nil
+	}
+	if len(ip) == 0 {
+		return false, nil
+	}
+
+	for _, value := range values {
+		start, end, err := iptools.GetIpBytes(value)
+		if err != nil {
+			return false, err
+		}
+		if len(end) == 0 {
+			end = start
+		}
+		if len(start) == len(ip) && bytes.Compare(ip, start) >= 0 && bytes.Compare(ip, end) <= 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchString(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMac(mac string, value string) bool {
+	if len(mac) == 0 {
+		return true
+	}
+	return len(value) != 0 && bytes.Equal(macaddr(mac), macaddr(value))
+}
+
+// matchPort 报文端口为0表示报文没有端口
+func matchPort(ports model.Ports, value int) (bool, error) {
+	if len(ports) == 0 {
+		return true, nil
+	}
+
+	ranges, err := nftcmd.GetPortRanges(ports)
+	if err != nil || value <= 0 {
+		return false, err
+	}
+	for _, r := range ranges {
+		if value >= int(r.Start) && value <= int(r.End) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchCtState 报文的连接状态是否在状态位中，报文没有连接状态时不匹配
+func matchCtState(state uint32, value string) (bool, error) {
+	if len(value) == 0 {
+		return false, nil
+	}
+	bits, err := nftcmd.ParseCtState(value)
+	if err != nil {
+		return false, err
+	}
+	return bits&state != 0, nil
+}
+
+// matchCt 报文连接状态、方向和标记是否满足策略
+func matchCt(policy model.Policy, packet model.Packet) (bool, error) {
+	match, err := nftcmd.GetCtMatch(policy)
+	if err != nil {
+		return false, err
+	}
+
+	if match.State != 0 {
+		if ok, err := matchCtState(match.State, packet.CtState); !ok || err != nil {
+			return false, err
+		}
+	}
+	if len(match.Direction) != 0 && match.Direction != packet.CtDirection {
+		return false, nil
+	}
+	if match.HasMark {
+		if len(packet.CtMark) == 0 {
+			return false, nil
+		}
+		mark, err := nftcmd.ParseCtMark(packet.CtMark)
+		if err != nil || mark != match.Mark {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// MatchConntrackRule 报文是否命中策略链最前面的连接跟踪规则
+func MatchConntrackRule(comment string, packet model.Packet) (bool, error) {
+	state, _ := nftcmd.ConntrackRuleState(comment)
+	if state == 0 {
+		return false, nil
+	}
+	return matchCtState(state, packet.CtState)
+}
+
+func inIntervals(intervals []timeInterval, value uint64) bool {
+	for _, interval := range intervals {
+		if value >= interval.Start && value <= interval.End {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTime 同一类型的时间段取并集，不同类型之间取交集，与GetTimeExpr一致
+func matchTime(times []model.PolicyTime, t time.Time) (bool, error) {
+	if len(times) == 0 {
+		return true, nil
+	}
+
+	timeValues, err := nftcmd.GetPolicyTimeValues(times)
+	if err != nil {
+		return false, err
+	}
+
+	if len(timeValues.TimeStamps) != 0 {
+		intervals, err := getTimeIntervals(timeValues.TimeStamps, parseTimeStamp)
+		if err != nil {
+			return false, err
+		}
+		if !inIntervals(intervals, uint64(t.UnixNano())) {
+			return false, nil
+		}
+	}
+
+	// 内核按UTC计算当天的秒数
+	if len(timeValues.Hours) != 0 {
+		intervals, err := getHourIntervals(timeValues.Hours)
+		if err != nil {
+			return false, err
+		}
+		utc := t.UTC()
+		if !inIntervals(intervals, uint64(utc.Hour()*3600+utc.Minute()*60+utc.Second())) {
+			return false, nil
+		}
+	}
+
+	if len(timeValues.Weeks) != 0 {
+		intervals, err := getTimeIntervals(timeValues.Weeks, parseWeek)
+		if err != nil {
+			return false, err
+		}
+		if !inIntervals(intervals, uint64(t.Local().Weekday())) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MatchPolicy 报文是否命中策略，匹配条件与生成的规则表达式一致
+func MatchPolicy(policy model.Policy, packet model.Packet) (bool, error) {
+	policy, err := nftcmd.ExpandApp(policy)
+	if err != nil {
+		return false, err
+	}
+
+	if !matchString(policy.SRegion, packet.IIfName) || !matchString(policy.DRegion, packet.OIfName) {
+		return false, nil
+	}
+
+	if ok, err := matchProtocol(policy, packet); !ok || err != nil {
+		return false, err
+	}
+
+	sIp, dIp, err := packetIps(packet)
+	if err != nil {
+		return false, err
+	}
+	if !matchFamily(policy.Family, sIp, dIp) {
+		return false, nil
+	}
+	if ok, err := matchIp(policy.SIp, sIp); !ok || err != nil {
+		return false, err
+	}
+	if ok, err := matchIp(policy.DIp, dIp); !ok || err != nil {
+		return false, err
+	}
+
+	if !matchMac(policy.SMac, packet.SMac) || !matchMac(policy.DMac, packet.DMac) {
+		return false, nil
+	}
+
+	if ok, err := matchPort(policy.SPort, packet.SPort); !ok || err != nil {
+		return false, err
+	}
+	if ok, err := matchPort(policy.DPort, packet.DPort); !ok || err != nil {
+		return false, err
+	}
+	if ok, err := matchCt(policy, packet); !ok || err != nil {
+		return false, err
+	}
+
+	packetTime := packet.Time
+	if packetTime.IsZero() {
+		packetTime = time.Now()
+	}
+	return matchTime(policy.Time, packetTime)
+}
+
+// matchFamily 策略限制地址族时，报文地址必须属于该地址族
+func matchFamily(family string, sIp []byte, dIp []byte) bool {
+	if len(family) == 0 {
+		return true
+	}
+	ip := sIp
+	if len(ip) == 0 {
+		ip = dIp
+	}
+	if len(ip) == 0 {
+		return false
+	}
+	return (len(ip) == net.IPv6len) == (family == nftcmd.FamilyIPv6)
+}
+
+// packetIps 报文地址，源和目的必须属于同一个地址族
+func packetIps(packet model.Packet) ([]byte, []byte, error) {
+	var ips [2][]byte
+	for i, value := range []string{packet.SIp, packet.DIp} {
+		if len(value) == 0 {
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, nil, strerror.CreateError("ip error:" + value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ips[i] = ip
+	}
+
+	if len(ips[0]) != 0 && len(ips[1]) != 0 && len(ips[0]) != len(ips[1]) {
+		return nil, nil, strerror.CreateError("source ip and destination ip are not in the same family")
+	}
+	return ips[0], ips[1], nil
+}
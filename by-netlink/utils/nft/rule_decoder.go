@@ -0,0 +1,484 @@
+package nft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"netvine.com/firewall/server/model"
+	nftcmd "netvine.com/firewall/server/nft"
+	iptools "netvine.com/firewall/server/utils"
+)
+
+// 寄存器中加载的字段
+type ruleField int
+
+const (
+	fieldNone ruleField = iota
+	fieldIgnore
+	fieldIIfName
+	fieldOIfName
+	fieldL4Proto
+	fieldSIp
+	fieldDIp
+	fieldSMac
+	fieldDMac
+	fieldSPort
+	fieldDPort
+	fieldIcmpType
+	fieldIcmpCode
+	fieldTcpFlags
+	fieldTimeStamp
+	fieldTimeHour
+	fieldTimeDay
+	fieldCtState
+	fieldCtDirection
+	fieldCtMark
+)
+
+// RuleDecoder 将本程序生成的规则表达式还原为策略
+// 规则中的匿名集合需要通过连接读取元素
+type RuleDecoder struct {
+	Conn  *nftables.Conn
+	Table *nftables.Table
+}
+
+// metaField meta load 对应的策略字段
+func metaField(key expr.MetaKey) ruleField {
+	switch key {
+	case expr.MetaKeyIIFNAME:
+		return fieldIIfName
+	case expr.MetaKeyOIFNAME:
+		return fieldOIfName
+	case expr.MetaKeyL4PROTO:
+		return fieldL4Proto
+	case MetaKeyTimeNS:
+		return fieldTimeStamp
+	case MetaKeyTimeHour:
+		return fieldTimeHour
+	case MetaKeyTimeDay:
+		return fieldTimeDay
+	case expr.MetaKeyNFPROTO, expr.MetaKeyIIFTYPE, expr.MetaKeyOIFTYPE:
+		// 地址族、链路类型是地址和MAC的前置条件
+		return fieldIgnore
+	}
+	return fieldNone
+}
+
+// ctField ct load 对应的策略字段
+func ctField(key expr.CtKey) ruleField {
+	switch key {
+	case expr.CtKeySTATE:
+		return fieldCtState
+	case expr.CtKeyDIRECTION:
+		return fieldCtDirection
+	case expr.CtKeyMARK:
+		return fieldCtMark
+	}
+	return fieldNone
+}
+
+// payloadField payload load 对应的策略字段
+func payloadField(payload *expr.Payload) ruleField {
+	switch payload.Base {
+	case expr.PayloadBaseLLHeader:
+		if payload.Len == 6 && payload.Offset == 6 {
+			return fieldSMac
+		}
+		if payload.Len == 6 && payload.Offset == 0 {
+			return fieldDMac
+		}
+	case expr.PayloadBaseNetworkHeader:
+		switch {
+		case payload.Len == net.IPv4len && payload.Offset == 12, payload.Len == net.IPv6len && payload.Offset == 8:
+			return fieldSIp
+		case payload.Len == net.IPv4len && payload.Offset == 16, payload.Len == net.IPv6len && payload.Offset == 24:
+			return fieldDIp
+		}
+	case expr.PayloadBaseTransportHeader:
+		switch {
+		case payload.Len == 2 && payload.Offset == 0:
+			return fieldSPort
+		case payload.Len == 2 && payload.Offset == 2:
+			return fieldDPort
+		case payload.Len == 1 && payload.Offset == 0:
+			return fieldIcmpType
+		case payload.Len == 1 && payload.Offset == 1:
+			return fieldIcmpCode
+		case payload.Len == 1 && payload.Offset == 13:
+			return fieldTcpFlags
+		}
+	}
+	return fieldNone
+}
+
+// lookupRanges 读取集合元素
+func (d *RuleDecoder) lookupRanges(lookup *expr.Lookup) ([]iptools.IpRange, error) {
+	if d.Conn == nil || d.Table == nil {
+		return nil, fmt.Errorf("no connection to read set %s", lookup.SetName)
+	}
+
+	set, err := d.Conn.GetSetByName(d.Table, lookup.SetName)
+	if err != nil {
+		return nil, err
+	}
+	set.Table = d.Table
+
+	elements, err := d.Conn.GetSetElements(set)
+	if err != nil {
+		return nil, err
+	}
+	return GetElementRanges(set.Interval, elements), nil
+}
+
+// DecodeRule 规则还原为策略，无法识别的表达式返回在unknown中
+func (d *RuleDecoder) DecodeRule(rule *nftables.Rule) (policy model.Policy, unknown []string) {
+	policy.Id, _ = nftcmd.ParseRuleComment(GetRuleComment(rule.UserData))
+	policy.TableName = rule.Table.Name
+	if rule.Chain != nil {
+		policy.ChainName = rule.Chain.Name
+	}
+
+	field := fieldNone
+	warn := false
+	var verdict nftcmd.RuleAction
+	var tcpMask uint8
+	var ctState uint32
+	for _, e := range rule.Exprs {
+		var ranges []iptools.IpRange
+		switch v := e.(type) {
+		case *expr.Meta:
+			field = metaField(v.Key)
+			if field == fieldNone {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Ct:
+			field = ctField(v.Key)
+			if field == fieldNone {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Payload:
+			field = payloadField(v)
+			if field == fieldNone {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Byteorder:
+			// 时间比较前转换为网络字节序，寄存器中的字段不变
+			continue
+		case *expr.Bitwise:
+			// TCP标志先与掩码再比较，连接状态先与状态位再判断是否为0
+			switch {
+			case field == fieldTcpFlags && len(v.Mask) == 1:
+				tcpMask = v.Mask[0]
+			case field == fieldCtState && len(v.Mask) == 4:
+				ctState = binaryutil.NativeEndian.Uint32(v.Mask)
+			default:
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+				field = fieldNone
+			}
+			continue
+		case *expr.Cmp:
+			if field == fieldCtState {
+				if v.Op != expr.CmpOpNeq || ctState == 0 || !isZero(v.Data) {
+					unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+				} else {
+					policy.CtState = nftcmd.FormatCtState(ctState)
+				}
+				field = fieldNone
+				continue
+			}
+			if v.Op != expr.CmpOpEq {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+				continue
+			}
+			ranges = []iptools.IpRange{{Start: v.Data, End: v.Data}}
+		case *expr.Range:
+			if v.Op != expr.CmpOpEq {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+				continue
+			}
+			ranges = []iptools.IpRange{{Start: v.FromData, End: v.ToData}}
+		case *expr.Lookup:
+			if v.Invert {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+				continue
+			}
+			var err error
+			ranges, err = d.lookupRanges(v)
+			if err != nil {
+				unknown = append(unknown, fmt.Sprintf("%T%+v: %v", e, e, err))
+				continue
+			}
+		case *expr.Limit, *expr.Connlimit:
+			if !setPolicyLimit(&policy, e) {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Dynset:
+			// 按地址限速，集合元素是前面加载的源地址或者目的地址
+			ok := len(v.Exprs) != 0 && (field == fieldSIp || field == fieldDIp)
+			for _, statement := range v.Exprs {
+				ok = ok && setPolicyLimit(&policy, statement)
+			}
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			} else if field == fieldSIp {
+				policy.LimitPer = nftcmd.LimitPerSource
+			} else {
+				policy.LimitPer = nftcmd.LimitPerDest
+			}
+			field = fieldNone
+			continue
+		case *expr.Counter:
+			continue
+		case *expr.Objref:
+			// 策略的命名计数器，由规则注释决定
+			if v.Type != ObjectTypeCounter || !nftcmd.IsCounterName(v.Name) {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Log:
+			var logSwitch bool
+			policy.LogTag, warn, logSwitch = nftcmd.ParseLogPrefix(string(v.Data))
+			if logSwitch {
+				policy.LogSwitch = 1
+			}
+			continue
+		case *expr.Verdict:
+			switch v.Kind {
+			case expr.VerdictDrop:
+				policy.Action = nftcmd.DROP
+				verdict = nftcmd.ActionDrop
+			case expr.VerdictAccept:
+				policy.Action = nftcmd.ALLOW
+				verdict = nftcmd.ActionAccept
+			default:
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			}
+			continue
+		case *expr.Queue:
+			policy.Action = nftcmd.ALLOW
+			verdict = nftcmd.ActionQueue
+			continue
+		default:
+			unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			continue
+		}
+
+		if field == fieldTcpFlags {
+			if tcpMask == 0 || len(ranges) != 1 || len(ranges[0].Start) != 1 {
+				unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+			} else {
+				policy.TcpFlags = nftcmd.FormatTcpFlags(tcpMask, ranges[0].Start[0])
+			}
+			field = fieldNone
+			continue
+		}
+
+		if !setPolicyField(&policy, field, ranges) {
+			unknown = append(unknown, fmt.Sprintf("%T%+v", e, e))
+		}
+		field = fieldNone
+	}
+
+	// 告警动作的规则动作是queue，通过日志前缀区分
+	if warn && policy.Action == nftcmd.ALLOW {
+		policy.Action = nftcmd.WARN
+	}
+
+	// 协议、目的端口和规则动作与应用一致时还原应用，不需要深度检测的应用规则动作是accept
+	if app, ok := nftcmd.FindApp(policy, verdict); ok {
+		policy.App = app
+	}
+	return policy, unknown
+}
+
+// setPolicyField 比较的值写入策略字段，无法还原时返回false
+func setPolicyField(policy *model.Policy, field ruleField, ranges []iptools.IpRange) bool {
+	switch field {
+	case fieldIgnore:
+		return true
+	case fieldIIfName, fieldOIfName:
+		var names []string
+		for _, r := range ranges {
+			names = append(names, strings.TrimRight(string(r.Start), "\x00"))
+		}
+		if field == fieldIIfName {
+			policy.SRegion = names
+		} else {
+			policy.DRegion = names
+		}
+	case fieldL4Proto:
+		var protocols []string
+		for _, r := range ranges {
+			if len(r.Start) != 1 {
+				return false
+			}
+			protocols = append(protocols, nftcmd.ProtocolName(r.Start[0]))
+		}
+		policy.Protocol = strings.Join(protocols, ",")
+	case fieldIcmpType, fieldIcmpCode:
+		if len(ranges) != 1 || len(ranges[0].Start) != 1 {
+			return false
+		}
+		if field == fieldIcmpCode {
+			policy.IcmpCode = strconv.Itoa(int(ranges[0].Start[0]))
+			return true
+		}
+		protocol, err := nftcmd.ParseProtocol(policy.Protocol)
+		if err != nil {
+			return false
+		}
+		policy.IcmpType = nftcmd.IcmpTypeName(protocol, ranges[0].Start[0])
+	case fieldSIp, fieldDIp:
+		var ips []string
+		for _, r := range ranges {
+			ips = append(ips, iptools.FormatIpRange(r.Start, r.End))
+		}
+		if field == fieldSIp {
+			policy.SIp = ips
+		} else {
+			policy.DIp = ips
+		}
+	case fieldSMac, fieldDMac:
+		if len(ranges) != 1 {
+			return false
+		}
+		if field == fieldSMac {
+			policy.SMac = net.HardwareAddr(ranges[0].Start).String()
+		} else {
+			policy.DMac = net.HardwareAddr(ranges[0].Start).String()
+		}
+	case fieldSPort, fieldDPort:
+		var ports model.Ports
+		for _, r := range ranges {
+			if len(r.Start) != 2 || len(r.End) != 2 {
+				return false
+			}
+			portRange := nftcmd.PortRange{Start: binary.BigEndian.Uint16(r.Start), End: binary.BigEndian.Uint16(r.End)}
+			ports = append(ports, portRange.String())
+		}
+		if field == fieldSPort {
+			policy.SPort = ports
+		} else {
+			policy.DPort = ports
+		}
+	case fieldCtDirection:
+		if len(ranges) != 1 || len(ranges[0].Start) != 1 || ranges[0].Start[0] > 1 {
+			return false
+		}
+		policy.CtDirection = nftcmd.CtDirectionOriginal
+		if ranges[0].Start[0] == 1 {
+			policy.CtDirection = nftcmd.CtDirectionReply
+		}
+	case fieldCtMark:
+		if len(ranges) != 1 || len(ranges[0].Start) != 4 {
+			return false
+		}
+		policy.CtMark = nftcmd.FormatCtMark(binaryutil.NativeEndian.Uint32(ranges[0].Start))
+	case fieldTimeStamp:
+		for _, r := range ranges {
+			policy.Time = append(policy.Time, model.PolicyTime{Day: formatTimeStamp(r.Start) + "-" + formatTimeStamp(r.End)})
+		}
+	case fieldTimeHour:
+		for _, hour := range formatHourRanges(ranges) {
+			policy.Time = append(policy.Time, model.PolicyTime{Hour: hour})
+		}
+	case fieldTimeDay:
+		var weeks []string
+		for _, r := range ranges {
+			if r.Start[0] == r.End[0] {
+				weeks = append(weeks, strconv.Itoa(int(r.Start[0])))
+			} else {
+				weeks = append(weeks, fmt.Sprintf("%d-%d", r.Start[0], r.End[0]))
+			}
+		}
+		policy.Time = append(policy.Time, model.PolicyTime{Week: strings.Join(weeks, ",")})
+	default:
+		return false
+	}
+	return true
+}
+
+// setPolicyLimit 超过限制时命中的限速和连接数限制写入策略，无法还原时返回false
+func setPolicyLimit(policy *model.Policy, e expr.Any) bool {
+	switch v := e.(type) {
+	case *expr.Limit:
+		if !v.Over {
+			return false
+		}
+		bytes := v.Type == expr.LimitTypePktBytes
+		policy.Limit = nftcmd.FormatLimitRate(v.Rate, uint64(v.Unit), bytes)
+		if bytes || v.Burst != nftcmd.DefaultLimitBurst {
+			policy.LimitBurst = int(v.Burst)
+		}
+	case *expr.Connlimit:
+		if v.Flags&expr.NFT_CONNLIMIT_F_INV == 0 {
+			return false
+		}
+		policy.ConnLimit = int(v.Count)
+	default:
+		return false
+	}
+	return true
+}
+
+func timeValue(b []byte) uint64 {
+	value := make([]byte, 8)
+	copy(value[8-len(b):], b)
+	return binary.BigEndian.Uint64(value)
+}
+
+// formatTimeStamp 纳秒时间戳转换为本地时间
+func formatTimeStamp(b []byte) string {
+	return time.Unix(0, int64(timeValue(b))).Local().Format("2006-01-02 15:04:05")
+}
+
+func formatHour(seconds uint64) string {
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+// formatHourRanges UTC秒数转换为本地时间，生成规则时跨零点拆分的两段重新合并
+func formatHourRanges(ranges []iptools.IpRange) []string {
+	_, offset := time.Now().Zone()
+	toLocal := func(b []byte) uint64 {
+		return uint64(((int64(timeValue(b))+int64(offset))%daySeconds + daySeconds) % daySeconds)
+	}
+
+	var intervals []timeInterval
+	for _, r := range ranges {
+		intervals = append(intervals, timeInterval{Start: toLocal(r.Start), End: toLocal(r.End)})
+	}
+
+	var hours []string
+	used := make([]bool, len(intervals))
+	for i := range intervals {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		interval := intervals[i]
+		for j := range intervals {
+			if !used[j] && (interval.End+1)%daySeconds == intervals[j].Start {
+				interval.End = intervals[j].End
+				used[j] = true
+			}
+		}
+
+		if interval.Start == interval.End {
+			hours = append(hours, formatHour(interval.Start))
+		} else {
+			hours = append(hours, formatHour(interval.Start)+"-"+formatHour(interval.End))
+		}
+	}
+	return hours
+}
@@ -0,0 +1,108 @@
+package nft
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// SetElementTimeout 集合元素的超时时间和剩余时间
+type SetElementTimeout struct {
+	Timeout    time.Duration // 元素超时时间，0表示永不过期
+	Expiration time.Duration // 剩余时间
+}
+
+// GetSetElementTimeouts 读取集合元素的超时时间和剩余时间，key为集合元素的Key
+// nftables库解析元素时不保留NFTA_SET_ELEM_EXPIRATION，这里直接发送NFT_MSG_GETSETELEM
+func GetSetElementTimeouts(ns netns.NsHandle, set *nftables.Set) (map[string]SetElementTimeout, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(ns)})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_SET_TABLE, Data: []byte(set.Table.Name + "\x00")},
+		{Type: unix.NFTA_SET_NAME, Data: []byte(set.Name + "\x00")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// nfgenmsg: family, version, res_id
+	header := []byte{byte(set.Table.Family), unix.NFNETLINK_V0, 0, 0}
+	messages, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETSETELEM),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append(header, data...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := make(map[string]SetElementTimeout)
+	for _, message := range messages {
+		if len(message.Data) < 4 {
+			continue
+		}
+		if err := decodeSetElementTimeouts(message.Data[4:], timeouts); err != nil {
+			return nil, err
+		}
+	}
+	return timeouts, nil
+}
+
+// decodeSetElementTimeouts NFTA_SET_ELEM_LIST_ELEMENTS -> NFTA_LIST_ELEM -> NFTA_SET_ELEM_*
+func decodeSetElementTimeouts(data []byte, timeouts map[string]SetElementTimeout) error {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	for ad.Next() {
+		if ad.Type() != unix.NFTA_SET_ELEM_LIST_ELEMENTS {
+			continue
+		}
+		ad.Nested(func(elements *netlink.AttributeDecoder) error {
+			for elements.Next() {
+				if elements.Type() != unix.NFTA_LIST_ELEM {
+					continue
+				}
+				elements.Nested(func(element *netlink.AttributeDecoder) error {
+					var key []byte
+					var timeout SetElementTimeout
+					for element.Next() {
+						switch element.Type() {
+						case unix.NFTA_SET_ELEM_KEY:
+							element.Nested(func(value *netlink.AttributeDecoder) error {
+								for value.Next() {
+									if value.Type() == unix.NFTA_DATA_VALUE {
+										key = value.Bytes()
+									}
+								}
+								return nil
+							})
+						case unix.NFTA_SET_ELEM_TIMEOUT:
+							timeout.Timeout = time.Duration(element.Uint64()) * time.Millisecond
+						case unix.NFTA_SET_ELEM_EXPIRATION:
+							timeout.Expiration = time.Duration(element.Uint64()) * time.Millisecond
+						}
+					}
+					if key != nil {
+						timeouts[string(key)] = timeout
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	return ad.Err()
+}